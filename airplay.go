@@ -0,0 +1,244 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// AirPlay receivers that were published in select options. Like the audio
+// device selects the config is published again when the list changes
+var publishedAirPlayTargets []string
+
+// Receiver chosen with PREFIX/command/airplay, empty when the audio is not
+// sent to AirPlay
+var currentAirPlayTarget string
+var airPlayMutex sync.Mutex
+
+// Select option and state for the audio played on the Mac
+const airPlayOff = "Off"
+
+// How long dns-sd listens for receivers. It never exits by itself
+const airPlayBrowseTime = 2 * time.Second
+
+var raopServiceRegexp = regexp.MustCompile(`\s+Add\s+\d+\s+\d+\s+\S+\s+_raop\._tcp\.\s+(.+)$`)
+
+// Names of AirPlay audio receivers on the local network, e.g. HomePods
+func getAirPlayTargets() ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), airPlayBrowseTime)
+	defer cancel()
+
+	// $ /usr/bin/dns-sd -B _raop._tcp local
+	// Browsing for _raop._tcp.local
+	// DATE: ---Wed 10 Apr 2024---
+	// 10:00:00.000  ...STARTING...
+	// Timestamp     A/R    Flags  if Domain               Service Type         Instance Name
+	// 10:00:00.001  Add        3  14 local.               _raop._tcp.          7C2ACA123456@Living Room
+	// 10:00:00.001  Add        2  14 local.               _raop._tcp.          F0B3EC654321@Kitchen
+	cmd := exec.CommandContext(ctx, "/usr/bin/dns-sd", "-B", "_raop._tcp", "local")
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, commandError("/usr/bin/dns-sd", err)
+	}
+
+	found := map[string]bool{}
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		m := raopServiceRegexp.FindStringSubmatch(scanner.Text())
+		if m == nil {
+			continue
+		}
+
+		// the part before @ is the MAC address of the receiver
+		_, name, ok := strings.Cut(m[1], "@")
+		if !ok {
+			name = m[1]
+		}
+		found[strings.TrimSpace(name)] = true
+	}
+
+	// killed by the timeout, that is how it always ends
+	cmd.Wait()
+
+	targets := make([]string, 0, len(found))
+	for name := range found {
+		targets = append(targets, name)
+	}
+	sort.Strings(targets)
+
+	return targets, nil
+}
+
+// macOS has no command line tool for AirPlay, so the receiver is clicked in
+// the Sound menu of Control Center. The Sound menu must be shown in the menu
+// bar and mac2mqtt allowed in Privacy & Security > Accessibility
+const airPlayScript = `on run argv
+	set target to item 1 of argv
+	tell application "System Events" to tell process "ControlCenter"
+		click (first menu bar item of menu bar 1 whose description is "Sound")
+		delay 1
+		set found to false
+		repeat with e in (entire contents of window 1)
+			try
+				if name of e is target then
+					click e
+					set found to true
+					exit repeat
+				end if
+			end try
+		end repeat
+		key code 53
+		if not found then error "AirPlay receiver " & target & " is not in the Sound menu"
+	end tell
+end run`
+
+func setAirPlayTarget(name string) error {
+	return runCommand("/usr/bin/osascript", "-e", airPlayScript, name)
+}
+
+// Plays the sound on the Mac again. With SwitchAudioSource the first local
+// output device is chosen, without it the receiver is clicked in the Sound
+// menu again, that turns it off
+func stopAirPlay(target string) error {
+	if switchAudioSourcePath != "" {
+		devices, err := getAudioDevices("output")
+		if err != nil {
+			return err
+		}
+
+		for _, d := range devices {
+			if d != "AirPlay" {
+				return setAudioDevice("output", d)
+			}
+		}
+		return fmt.Errorf("no local output device")
+	}
+
+	if target == "" {
+		return nil
+	}
+	return setAirPlayTarget(target)
+}
+
+func publishAirPlayConfig(client mqtt.Client, targets []string) {
+	airPlaySelectConfig := SelectConfig{
+		Name:              hostname + " AirPlay Output",
+		Icon:              "mdi:cast-audio-variant",
+		CommandTopic:      getTopicPrefix() + "/command/airplay",
+		StateTopic:        getTopicPrefix() + "/state/airplay",
+		Options:           append([]string{airPlayOff}, targets...),
+		UniqueID:          hostname + "_airplay",
+		AvailabilityTopic: getAvailabilityTopic(),
+		Device:            getDevice(),
+	}
+	publishConfig(client, "select", hostname+"_airplay", airPlaySelectConfig)
+
+	airPlayMutex.Lock()
+	publishedAirPlayTargets = targets
+	airPlayMutex.Unlock()
+}
+
+func updateAirPlay(client mqtt.Client) {
+	targets, err := getAirPlayTargets()
+	if err != nil {
+		reportError(client, "updating airplay receivers", err)
+		return
+	}
+
+	airPlayMutex.Lock()
+	changed := strings.Join(targets, "\n") != strings.Join(publishedAirPlayTargets, "\n")
+	airPlayMutex.Unlock()
+
+	if changed && len(targets) > 0 {
+		logInfof("AirPlay receivers changed, publishing new select options")
+		publishAirPlayConfig(client, targets)
+	}
+
+	// the audio went back to a local device, e.g. with PREFIX/command/audio_output
+	if switchAudioSourcePath != "" {
+		current, err := getCurrentAudioDevice("output")
+		if err == nil && current != "AirPlay" {
+			airPlayMutex.Lock()
+			currentAirPlayTarget = ""
+			airPlayMutex.Unlock()
+		}
+	}
+
+	airPlayMutex.Lock()
+	target := currentAirPlayTarget
+	airPlayMutex.Unlock()
+
+	if target == "" {
+		target = airPlayOff
+	}
+
+	publishState(client, "airplay", getTopicPrefix()+"/state/airplay", target)
+}
+
+func publishAirPlayDiscoveryConfig(client mqtt.Client) {
+	targets, err := getAirPlayTargets()
+	if err != nil {
+		reportError(client, "reading airplay receivers", err)
+		return
+	}
+
+	// a select needs options, it is published by the update that finds some
+	if len(targets) > 0 {
+		publishAirPlayConfig(client, targets)
+	}
+}
+
+func init() {
+	registerSensor(sensorFuncs{
+		name:      "airplay",
+		interval:  systemInterval,
+		poll:      updateAirPlay,
+		discovery: publishAirPlayDiscoveryConfig,
+	})
+
+	registerCommand("airplay", "airplay", func(client mqtt.Client, command string, payload string) {
+		if payload == "" {
+			return
+		}
+
+		if payload == airPlayOff {
+			airPlayMutex.Lock()
+			target := currentAirPlayTarget
+			airPlayMutex.Unlock()
+
+			if err := stopAirPlay(target); err != nil {
+				reportError(client, "stopping airplay output", err)
+				return
+			}
+
+			airPlayMutex.Lock()
+			currentAirPlayTarget = ""
+			airPlayMutex.Unlock()
+
+			publishState(client, "airplay", getTopicPrefix()+"/state/airplay", airPlayOff)
+			return
+		}
+
+		if err := setAirPlayTarget(payload); err != nil {
+			reportError(client, "setting airplay output", err)
+			return
+		}
+
+		airPlayMutex.Lock()
+		currentAirPlayTarget = payload
+		airPlayMutex.Unlock()
+
+		publishState(client, "airplay", getTopicPrefix()+"/state/airplay", payload)
+	})
+}
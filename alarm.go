@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"os/exec"
+	"strconv"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+const (
+	alarmSound    = "/System/Library/Sounds/Sosumi.aiff"
+	alarmDuration = 30 * time.Second
+)
+
+// Stops the playing alarm, nil when it is not playing
+var alarmCancel context.CancelFunc
+var alarmMutex sync.Mutex
+
+// Plays a loud sound at full volume for d to find the Mac in the house, then
+// puts the volume and mute back as they were
+func startAlarm(client mqtt.Client, d time.Duration) error {
+	alarmMutex.Lock()
+	defer alarmMutex.Unlock()
+
+	if alarmCancel != nil {
+		return nil
+	}
+
+	volume, err := getCurrentVolume()
+	if err != nil {
+		return err
+	}
+	muted, err := getMuteStatus()
+	if err != nil {
+		return err
+	}
+
+	if err := setMute(false); err != nil {
+		return err
+	}
+	if err := setVolume(100); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), d)
+	alarmCancel = cancel
+
+	go func() {
+		for ctx.Err() == nil {
+			// killed when ctx is done, so stop works in the middle of the sound
+			if err := exec.CommandContext(ctx, "/usr/bin/afplay", alarmSound).Run(); err != nil && ctx.Err() == nil {
+				logErrorf("Error playing alarm: %v", err)
+				break
+			}
+		}
+
+		alarmMutex.Lock()
+		alarmCancel()
+		alarmCancel = nil
+		alarmMutex.Unlock()
+
+		if err := setVolume(volume); err != nil {
+			reportError(client, "restoring volume", err)
+		}
+		if err := setMute(muted); err != nil {
+			reportError(client, "restoring mute", err)
+		}
+
+		updateAlarm(client)
+	}()
+
+	return nil
+}
+
+func stopAlarm() {
+	alarmMutex.Lock()
+	defer alarmMutex.Unlock()
+
+	if alarmCancel != nil {
+		alarmCancel()
+	}
+}
+
+func getAlarmStatus() bool {
+	alarmMutex.Lock()
+	defer alarmMutex.Unlock()
+
+	return alarmCancel != nil
+}
+
+func updateAlarm(client mqtt.Client) {
+	publishState(client, "alarm", getTopicPrefix()+"/state/alarm", strconv.FormatBool(getAlarmStatus()))
+}
+
+func publishAlarmDiscoveryConfig(client mqtt.Client) {
+	alarmSwitchConfig := SwitchConfig{
+		Name:              hostname + " Alarm",
+		Icon:              "mdi:alarm-light",
+		StateTopic:        getTopicPrefix() + "/state/alarm",
+		CommandTopic:      getTopicPrefix() + "/command/alarm",
+		PayloadOn:         "true",
+		PayloadOff:        "false",
+		UniqueID:          hostname + "_alarm",
+		AvailabilityTopic: getAvailabilityTopic(),
+		Device:            getDevice(),
+	}
+	publishConfig(client, "switch", hostname+"_alarm", alarmSwitchConfig)
+}
+
+func init() {
+	registerSensor(sensorFuncs{
+		name:      "alarm",
+		poll:      updateAlarm,
+		discovery: publishAlarmDiscoveryConfig,
+	})
+
+	registerCommand("alarm", "alarm", func(client mqtt.Client, command string, payload string) {
+		d := alarmDuration
+
+		// true or the number of seconds to play
+		if seconds, err := strconv.Atoi(payload); err == nil && seconds > 0 {
+			d = time.Duration(seconds) * time.Second
+		} else if b, err := strconv.ParseBool(payload); err != nil {
+			logWarnf("Incorrect alarm value")
+			return
+		} else if !b {
+			stopAlarm()
+			return
+		}
+
+		if err := startAlarm(client, d); err != nil {
+			reportError(client, "starting alarm", err)
+		}
+
+		updateAlarm(client)
+	})
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+type activeApp struct {
+	Name     string `json:"name"`
+	BundleID string `json:"bundle_id"`
+}
+
+func getActiveApp() (activeApp, error) {
+	output, err := getCommandOutput("/usr/bin/osascript",
+		"-e", `tell application "System Events" to set p to first application process whose frontmost is true`,
+		"-e", `return (name of p) & linefeed & (bundle identifier of p)`)
+	if err != nil {
+		return activeApp{}, err
+	}
+
+	// $ osascript -e '...'
+	// zoom.us
+	// us.zoom.xos
+
+	name, bundleID, _ := strings.Cut(output, "\n")
+
+	// processes without a bundle return "missing value"
+	if bundleID == "missing value" {
+		bundleID = ""
+	}
+
+	return activeApp{Name: name, BundleID: bundleID}, nil
+}
+
+func updateActiveApp(client mqtt.Client) {
+	app, err := getActiveApp()
+	if err != nil {
+		reportError(client, "updating active app", err)
+		return
+	}
+
+	payload, err := json.Marshal(app)
+	if err != nil {
+		logErrorf("Error marshaling active app: %v", err)
+		return
+	}
+
+	publishState(client, "active app", getTopicPrefix()+"/state/active_app", payload)
+}
+
+// Checks the running state of all apps in one osascript call. Application()
+// throws for an app that is not installed, it is reported as not running
+const appsRunningScript = `var names = %s;
+JSON.stringify(names.map(function (name) {
+	try { return Application(name).running(); } catch (e) { return false; }
+}))`
+
+// Running state of the apps from the apps section of mac2mqtt.yaml, keys
+// are object ids of the app names
+func getAppsRunning() (map[string]bool, error) {
+	names, err := json.Marshal(settings.Apps)
+	if err != nil {
+		return nil, err
+	}
+
+	// $ osascript -l JavaScript -e '...'
+	// [true,false]
+	output, err := getCommandOutput("/usr/bin/osascript", "-l", "JavaScript", "-e", fmt.Sprintf(appsRunningScript, names))
+	if err != nil {
+		return nil, err
+	}
+
+	var running []bool
+	if err := json.Unmarshal([]byte(output), &running); err != nil || len(running) != len(settings.Apps) {
+		return nil, fmt.Errorf("unexpected osascript output: %s", output)
+	}
+
+	apps := map[string]bool{}
+	for i, name := range settings.Apps {
+		apps[getObjectId(name)] = running[i]
+	}
+
+	return apps, nil
+}
+
+// Returns the app name from the apps section for an object id
+func findApp(id string) (string, bool) {
+	for _, name := range settings.Apps {
+		if getObjectId(name) == id {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+func commandLaunchApp(name string) error {
+	return runCommand("/usr/bin/open", "-a", name)
+}
+
+// The app can ask to save documents, then it keeps running
+func commandQuitApp(name string) error {
+	return runCommand("/usr/bin/osascript", "-e", "tell application "+strconv.Quote(name)+" to quit")
+}
+
+func updateAppsRunning(client mqtt.Client) {
+	if len(settings.Apps) == 0 {
+		return
+	}
+
+	apps, err := getAppsRunning()
+	if err != nil {
+		reportError(client, "updating apps", err)
+		return
+	}
+
+	payload, err := json.Marshal(apps)
+	if err != nil {
+		logErrorf("Error marshaling apps: %v", err)
+		return
+	}
+
+	publishState(client, "apps", getTopicPrefix()+"/state/apps", payload)
+}
+
+func publishAppControlDiscoveryConfig(client mqtt.Client) {
+	topicPrefix := getTopicPrefix()
+	device := getDevice()
+
+	for _, name := range settings.Apps {
+		id := getObjectId(name)
+
+		runningConfig := BinarySensorConfig{
+			Name:              hostname + " " + name + " Running",
+			Icon:              "mdi:application-outline",
+			StateTopic:        topicPrefix + "/state/apps",
+			ValueTemplate:     "{{ 'ON' if value_json['" + id + "'] else 'OFF' }}",
+			UniqueID:          hostname + "_app_" + id,
+			DeviceClass:       "running",
+			ExpireAfter:       expireAfter(screenInterval),
+			AvailabilityTopic: getAvailabilityTopic(),
+			Device:            device,
+		}
+		publishConfig(client, "binary_sensor", hostname+"_app_"+id, runningConfig)
+
+		launchConfig := ButtonConfig{
+			Name:              hostname + " Launch " + name,
+			Icon:              "mdi:open-in-app",
+			CommandTopic:      topicPrefix + "/command/app/" + id,
+			PayloadPress:      "launch",
+			UniqueID:          hostname + "_launch_" + id,
+			AvailabilityTopic: getAvailabilityTopic(),
+			Device:            device,
+		}
+		publishConfig(client, "button", hostname+"_launch_"+id, launchConfig)
+
+		quitConfig := ButtonConfig{
+			Name:              hostname + " Quit " + name,
+			Icon:              "mdi:close-box-outline",
+			CommandTopic:      topicPrefix + "/command/app/" + id,
+			PayloadPress:      "quit",
+			UniqueID:          hostname + "_quit_" + id,
+			AvailabilityTopic: getAvailabilityTopic(),
+			Device:            device,
+		}
+		publishConfig(client, "button", hostname+"_quit_"+id, quitConfig)
+	}
+}
+
+func publishAppsDiscoveryConfig(client mqtt.Client) {
+	topicPrefix := getTopicPrefix()
+
+	activeAppConfig := SensorConfig{
+		Name:                hostname + " Active App",
+		Icon:                "mdi:application",
+		StateTopic:          topicPrefix + "/state/active_app",
+		UniqueID:            hostname + "_active_app",
+		ValueTemplate:       "{{ value_json.name }}",
+		JsonAttributesTopic: topicPrefix + "/state/active_app",
+		ExpireAfter:         expireAfter(screenInterval),
+		AvailabilityTopic:   getAvailabilityTopic(),
+		Device:              getDevice(),
+	}
+	publishConfig(client, "sensor", hostname+"_active_app", activeAppConfig)
+}
+
+func init() {
+	registerSensor(sensorFuncs{
+		name:      "active_app",
+		interval:  screenInterval,
+		poll:      updateActiveApp,
+		discovery: publishAppsDiscoveryConfig,
+	})
+
+	registerSensor(sensorFuncs{
+		name:      "apps",
+		interval:  screenInterval,
+		poll:      updateAppsRunning,
+		discovery: publishAppControlDiscoveryConfig,
+	})
+
+	registerCommand("apps", "app/", func(client mqtt.Client, command string, payload string) {
+		name, ok := findApp(strings.TrimPrefix(command, "app/"))
+		if !ok {
+			logWarnf("Unknown app %s", strings.TrimPrefix(command, "app/"))
+			return
+		}
+
+		var err error
+		switch payload {
+		case "launch":
+			err = commandLaunchApp(name)
+		case "quit":
+			err = commandQuitApp(name)
+		default:
+			logWarnf("Incorrect app value")
+			return
+		}
+
+		if err != nil {
+			reportError(client, "controlling app "+name, err)
+			return
+		}
+
+		time.Sleep(1 * time.Second)
+
+		updateAppsRunning(client)
+	})
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// AppleScript that stays running, checks volume settings every second and
+// prints "VOLUME MUTED" only when they change. Checking inside one osascript
+// process is much cheaper than starting a new one for every check
+const volumeWatchScript = `set lastState to ""
+repeat
+	set s to get volume settings
+	set currentState to ((output volume of s) as text) & " " & ((output muted of s) as text)
+	if currentState is not lastState then
+		log currentState
+		set lastState to currentState
+	end if
+	delay 1
+end repeat`
+
+// "50 false" => 50, false
+func parseVolumeState(line string) (volume int, muted bool, ok bool) {
+	fields := strings.Fields(line)
+	if len(fields) != 2 {
+		return 0, false, false
+	}
+
+	// output volume is "missing value" for devices without volume control (e.g. HDMI)
+	volume, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return 0, false, false
+	}
+
+	muted, err = strconv.ParseBool(fields[1])
+	if err != nil {
+		return 0, false, false
+	}
+
+	return volume, muted, true
+}
+
+// Publishes volume and mute as soon as they change. osascript is restarted if
+// it exits, and killed when ctx is done
+func watchVolume(ctx context.Context, client mqtt.Client) {
+	for {
+		cmd := exec.CommandContext(ctx, "/usr/bin/osascript", "-e", volumeWatchScript)
+
+		// AppleScript "log" writes to stderr
+		stderr, err := cmd.StderrPipe()
+		if err == nil {
+			err = cmd.Start()
+		}
+		if err != nil {
+			logErrorf("Error starting volume watcher: %v", err)
+			if !sleepContext(ctx, 10*time.Second) {
+				return
+			}
+			continue
+		}
+
+		scanner := bufio.NewScanner(stderr)
+		for scanner.Scan() {
+			volume, muted, ok := parseVolumeState(scanner.Text())
+			if !ok {
+				continue
+			}
+
+			publishState(client, "volume", getTopicPrefix()+"/state/volume", strconv.Itoa(volume))
+			publishState(client, "mute", getTopicPrefix()+"/state/mute", strconv.FormatBool(muted))
+		}
+
+		err = cmd.Wait()
+		if ctx.Err() != nil {
+			return
+		}
+
+		logWarnf("Volume watcher exited: %v. Restarting in 10 seconds...", err)
+		if !sleepContext(ctx, 10*time.Second) {
+			return
+		}
+	}
+}
+
+// Path to the `SwitchAudioSource` tool (https://github.com/deweller/switchaudio-osx),
+// empty if it is not installed and audio device selection is disabled
+var switchAudioSourcePath string
+
+// Device lists that were published in select options, by device type
+// ("output" or "input"). Select options are fixed in the discovery config, so
+// it is published again when a device is connected or disconnected
+var publishedAudioDevices = map[string][]string{}
+var publishedAudioDevicesMutex sync.Mutex
+
+// deviceType is "output" or "input"
+func getAudioDevices(deviceType string) ([]string, error) {
+	output, err := getCommandOutput(switchAudioSourcePath, "-a", "-t", deviceType)
+	if err != nil {
+		return nil, err
+	}
+
+	// $ SwitchAudioSource -a -t output
+	// MacBook Pro Speakers
+	// AirPods Pro
+	// LG UltraFine Display Audio
+
+	var devices []string
+	for _, line := range strings.Split(output, "\n") {
+		if line != "" {
+			devices = append(devices, line)
+		}
+	}
+
+	return devices, nil
+}
+
+func getCurrentAudioDevice(deviceType string) (string, error) {
+	// $ SwitchAudioSource -c -t output
+	// MacBook Pro Speakers
+	return getCommandOutput(switchAudioSourcePath, "-c", "-t", deviceType)
+}
+
+func setAudioDevice(deviceType string, name string) error {
+	return runCommand(switchAudioSourcePath, "-t", deviceType, "-s", name)
+}
+
+// The name is looked up in the list of the last poll first. SwitchAudioSource
+// is asked only for a name that is not there, e.g. of a device connected since
+func isAudioDevice(deviceType string, name string) (bool, error) {
+	publishedAudioDevicesMutex.Lock()
+	published := publishedAudioDevices[deviceType]
+	publishedAudioDevicesMutex.Unlock()
+
+	for _, d := range published {
+		if d == name {
+			return true, nil
+		}
+	}
+
+	devices, err := getAudioDevices(deviceType)
+	if err != nil {
+		return false, err
+	}
+
+	for _, d := range devices {
+		if d == name {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func publishAudioDeviceConfig(client mqtt.Client, deviceType string, devices []string) {
+	topicPrefix := getTopicPrefix()
+
+	icon := "mdi:speaker"
+	if deviceType == "input" {
+		icon = "mdi:microphone"
+	}
+
+	audioDeviceSelectConfig := SelectConfig{
+		Name:              hostname + " Audio " + strings.ToUpper(deviceType[:1]) + deviceType[1:],
+		Icon:              icon,
+		CommandTopic:      topicPrefix + "/command/audio_" + deviceType,
+		StateTopic:        topicPrefix + "/state/audio_" + deviceType,
+		Options:           devices,
+		UniqueID:          hostname + "_audio_" + deviceType,
+		AvailabilityTopic: getAvailabilityTopic(),
+		Device:            getDevice(),
+	}
+	publishConfig(client, "select", hostname+"_audio_"+deviceType, audioDeviceSelectConfig)
+
+	publishedAudioDevicesMutex.Lock()
+	publishedAudioDevices[deviceType] = devices
+	publishedAudioDevicesMutex.Unlock()
+}
+
+func updateAudioDevice(client mqtt.Client, deviceType string) {
+	devices, err := getAudioDevices(deviceType)
+	if err != nil {
+		reportError(client, "updating audio "+deviceType+" devices", err)
+		return
+	}
+
+	publishedAudioDevicesMutex.Lock()
+	changed := strings.Join(devices, "\n") != strings.Join(publishedAudioDevices[deviceType], "\n")
+	publishedAudioDevicesMutex.Unlock()
+
+	if changed {
+		logInfof("Audio %s devices changed, publishing new select options", deviceType)
+		publishAudioDeviceConfig(client, deviceType, devices)
+	}
+
+	current, err := getCurrentAudioDevice(deviceType)
+	if err != nil {
+		reportError(client, "updating audio "+deviceType, err)
+		return
+	}
+
+	publishState(client, "audio "+deviceType, getTopicPrefix()+"/state/audio_"+deviceType, current)
+}
+
+func publishAudioDiscoveryConfig(client mqtt.Client) {
+	if switchAudioSourcePath == "" {
+		return
+	}
+
+	// when a list can't be read now, the select is published by the next update
+	for _, deviceType := range []string{"output", "input"} {
+		devices, err := getAudioDevices(deviceType)
+		if err != nil {
+			reportError(client, "reading audio "+deviceType+" devices", err)
+			continue
+		}
+
+		publishAudioDeviceConfig(client, deviceType, devices)
+	}
+}
+
+func init() {
+	registerSensor(sensorFuncs{
+		name:     "audio_devices",
+		interval: audioDevicesInterval,
+		poll: func(client mqtt.Client) {
+			if switchAudioSourcePath != "" {
+				updateAudioDevice(client, "output")
+				updateAudioDevice(client, "input")
+			}
+		},
+		discovery: publishAudioDiscoveryConfig,
+	})
+
+	audioDeviceCommand := func(client mqtt.Client, command string, payload string) {
+		if switchAudioSourcePath == "" {
+			return
+		}
+
+		deviceType := strings.TrimPrefix(command, "audio_")
+		ok, err := isAudioDevice(deviceType, payload)
+		if err != nil {
+			reportError(client, "reading audio "+deviceType+" devices", err)
+			return
+		}
+		if !ok {
+			logWarnf("Incorrect audio %s value", deviceType)
+			return
+		}
+
+		if err := setAudioDevice(deviceType, payload); err != nil {
+			reportError(client, "setting audio device", err)
+			return
+		}
+
+		time.Sleep(1 * time.Second)
+
+		updateAudioDevice(client, deviceType)
+	}
+	registerCommand("audio_devices", "audio_output", audioDeviceCommand)
+	registerCommand("audio_devices", "audio_input", audioDeviceCommand)
+}
@@ -0,0 +1,391 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bessarabov/mac2mqtt/internal/pmset"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+type batteryHealth struct {
+	// Estimated minutes until empty (or full when charging),
+	// nil while macOS is still calculating
+	TimeRemaining *int   `json:"time_remaining"`
+	CycleCount    int    `json:"cycle_count"`
+	Condition     string `json:"condition"`
+	// percent of the design capacity
+	MaxCapacity int `json:"max_capacity"`
+	// Watts the connected power adapter can deliver, nil without adapter
+	AdapterWattage *int `json:"adapter_wattage"`
+	// Watts going into the battery, 0 when it is not charging
+	ChargingPower float64 `json:"charging_power"`
+}
+
+// false on desktop Macs, battery sensors are not polled and their configs are
+// removed from Home Assistant. Read once at start
+var hasBattery bool
+
+func isBatteryPresent() bool {
+	output, err := getCommandOutput("/usr/bin/pmset", "-g", "batt")
+	if err != nil {
+		return false
+	}
+
+	return pmset.HasBattery(output)
+}
+
+// Percent, power adapter and charging state from `pmset -g batt`
+func getBatteryInfo() (pmset.Battery, error) {
+	output, err := getCommandOutput("/usr/bin/pmset", "-g", "batt")
+	if err != nil {
+		return pmset.Battery{}, err
+	}
+
+	return pmset.ParseBatt(output)
+}
+
+func getBatteryHealth() (batteryHealth, error) {
+	battery, err := getBatteryInfo()
+	if err != nil {
+		return batteryHealth{}, err
+	}
+
+	health := batteryHealth{TimeRemaining: battery.TimeRemaining}
+
+	output, err := getCommandOutput("/usr/sbin/system_profiler", "SPPowerDataType")
+	if err != nil {
+		return batteryHealth{}, err
+	}
+
+	// $ /usr/sbin/system_profiler SPPowerDataType
+	// ...
+	//       Health Information:
+	//           Cycle Count: 123
+	//           Condition: Normal
+	//           Maximum Capacity: 89%
+	// ...
+
+	for _, line := range strings.Split(output, "\n") {
+		key, value, found := strings.Cut(strings.TrimSpace(line), ": ")
+		if !found {
+			continue
+		}
+
+		switch key {
+		case "Cycle Count":
+			health.CycleCount, _ = strconv.Atoi(value)
+		case "Condition":
+			health.Condition = value
+		case "Maximum Capacity":
+			health.MaxCapacity, _ = strconv.Atoi(strings.TrimSuffix(value, "%"))
+		case "Wattage (W)":
+			// it is shown only while an adapter is connected
+			if wattage, err := strconv.Atoi(value); err == nil {
+				health.AdapterWattage = &wattage
+			}
+		}
+	}
+
+	health.ChargingPower, err = getChargingPower()
+	if err != nil {
+		return batteryHealth{}, err
+	}
+
+	return health, nil
+}
+
+// Watts going into the battery
+func getChargingPower() (float64, error) {
+	output, err := getCommandOutput("/usr/sbin/ioreg", "-rn", "AppleSmartBattery")
+	if err != nil {
+		return 0, err
+	}
+
+	// $ /usr/sbin/ioreg -rn AppleSmartBattery
+	// +-o AppleSmartBattery  <class AppleSmartBattery, id 0x1000002b1, registered, matched, active, busy 0 (0 ms), retain 7>
+	//     {
+	//       ...
+	//       "Amperage" = 1520
+	//       ...
+	//       "Voltage" = 12656
+	//       ...
+	//
+	// Amperage is in mA and negative while discharging, ioreg prints negative
+	// values as unsigned 64 bit numbers, e.g. 18446744073709550542
+
+	var amperage, voltage int64
+	for _, line := range strings.Split(output, "\n") {
+		key, value, found := strings.Cut(strings.TrimSpace(line), " = ")
+		if !found {
+			continue
+		}
+
+		switch key {
+		case `"Amperage"`:
+			u, _ := strconv.ParseUint(value, 10, 64)
+			amperage = int64(u)
+		case `"Voltage"`:
+			voltage, _ = strconv.ParseInt(value, 10, 64)
+		}
+	}
+
+	if amperage <= 0 {
+		return 0, nil
+	}
+
+	// mA * mV, rounded to 0.1 W
+	return math.Round(float64(amperage*voltage)/1e5) / 10, nil
+}
+
+func updateBattery(client mqtt.Client) {
+	battery, err := getBatteryInfo()
+	if err != nil {
+		reportError(client, "updating battery", err)
+		return
+	}
+
+	publishState(client, "battery", getTopicPrefix()+"/state/battery", strconv.Itoa(battery.Percent))
+	publishState(client, "power adapter", getTopicPrefix()+"/state/power_adapter", strconv.FormatBool(battery.ACPower))
+	publishState(client, "charging state", getTopicPrefix()+"/state/charging_state", battery.State)
+}
+
+// Path to the `bclm` tool (https://github.com/zackelia/bclm),
+// empty if it is not installed and charge limit control is disabled
+var bclmPath string
+
+// Maximum charge in percent
+func getChargeLimit() (int, error) {
+	output, err := getCommandOutput(bclmPath, "read")
+	if err != nil {
+		return 0, err
+	}
+
+	// $ bclm read
+	// 80
+
+	return strconv.Atoi(output)
+}
+
+// Writing the limit to SMC requires mac2mqtt to run as root. Apple Silicon
+// Macs support only 80 and 100
+func setChargeLimit(i int) error {
+	return runCommand(bclmPath, "write", strconv.Itoa(i))
+}
+
+func updateChargeLimit(client mqtt.Client) {
+	limit, err := getChargeLimit()
+	if err != nil {
+		reportError(client, "updating charge limit", err)
+		return
+	}
+
+	publishState(client, "charge limit", getTopicPrefix()+"/state/charge_limit", strconv.Itoa(limit))
+}
+
+func updateBatteryHealth(client mqtt.Client) {
+	health, err := getBatteryHealth()
+	if err != nil {
+		reportError(client, "updating battery health", err)
+		return
+	}
+
+	payload, err := json.Marshal(health)
+	if err != nil {
+		logErrorf("Error marshaling battery health: %v", err)
+		return
+	}
+
+	publishState(client, "battery health", getTopicPrefix()+"/state/battery_health", payload)
+}
+
+func publishBatteryDiscoveryConfig(client mqtt.Client) {
+	if !hasBattery {
+		client = configRemover{client}
+	}
+
+	topicPrefix := getTopicPrefix()
+	device := getDevice()
+
+	// Battery sensor
+	batteryConfig := SensorConfig{
+		Name:              hostname + " Battery Level",
+		StateTopic:        topicPrefix + "/state/battery",
+		UniqueID:          hostname + "_battery",
+		UnitOfMeasurement: "%",
+		DeviceClass:       "battery",
+		ExpireAfter:       expireAfter(batteryInterval),
+		AvailabilityTopic: getAvailabilityTopic(),
+		Device:            device,
+	}
+	publishConfig(client, "sensor", hostname+"_battery", batteryConfig)
+
+	// Power adapter binary sensor
+	powerAdapterConfig := BinarySensorConfig{
+		Name:              hostname + " Power Adapter",
+		StateTopic:        topicPrefix + "/state/power_adapter",
+		PayloadOn:         "true",
+		PayloadOff:        "false",
+		UniqueID:          hostname + "_power_adapter",
+		DeviceClass:       "plug",
+		ExpireAfter:       expireAfter(batteryInterval),
+		AvailabilityTopic: getAvailabilityTopic(),
+		Device:            device,
+	}
+	publishConfig(client, "binary_sensor", hostname+"_power_adapter", powerAdapterConfig)
+
+	chargingStateConfig := SensorConfig{
+		Name:              hostname + " Charging State",
+		Icon:              "mdi:battery-charging",
+		StateTopic:        topicPrefix + "/state/charging_state",
+		UniqueID:          hostname + "_charging_state",
+		DeviceClass:       "enum",
+		Options:           []string{"charging", "discharging", "full", "not charging"},
+		ExpireAfter:       expireAfter(batteryInterval),
+		AvailabilityTopic: getAvailabilityTopic(),
+		Device:            device,
+	}
+	publishConfig(client, "sensor", hostname+"_charging_state", chargingStateConfig)
+
+	timeRemainingConfig := SensorConfig{
+		Name:                      hostname + " Battery Time Remaining",
+		SuggestedDisplayPrecision: precision(0),
+		StateTopic:                topicPrefix + "/state/battery_health",
+		UniqueID:                  hostname + "_battery_time_remaining",
+		UnitOfMeasurement:         "min",
+		DeviceClass:               "duration",
+		ValueTemplate:             "{{ value_json.time_remaining }}",
+		ExpireAfter:               expireAfter(batteryInterval),
+		AvailabilityTopic:         getAvailabilityTopic(),
+		Device:                    device,
+	}
+	publishConfig(client, "sensor", hostname+"_battery_time_remaining", timeRemainingConfig)
+
+	cycleCountConfig := SensorConfig{
+		Name:              hostname + " Battery Cycle Count",
+		Icon:              "mdi:battery-sync",
+		EntityCategory:    "diagnostic",
+		StateTopic:        topicPrefix + "/state/battery_health",
+		UniqueID:          hostname + "_battery_cycle_count",
+		ValueTemplate:     "{{ value_json.cycle_count }}",
+		ExpireAfter:       expireAfter(batteryInterval),
+		AvailabilityTopic: getAvailabilityTopic(),
+		Device:            device,
+	}
+	publishConfig(client, "sensor", hostname+"_battery_cycle_count", cycleCountConfig)
+
+	conditionConfig := SensorConfig{
+		Name:              hostname + " Battery Condition",
+		Icon:              "mdi:battery-heart-variant",
+		EntityCategory:    "diagnostic",
+		StateTopic:        topicPrefix + "/state/battery_health",
+		UniqueID:          hostname + "_battery_condition",
+		ValueTemplate:     "{{ value_json.condition }}",
+		ExpireAfter:       expireAfter(batteryInterval),
+		AvailabilityTopic: getAvailabilityTopic(),
+		Device:            device,
+	}
+	publishConfig(client, "sensor", hostname+"_battery_condition", conditionConfig)
+
+	maxCapacityConfig := SensorConfig{
+		Name:              hostname + " Battery Max Capacity",
+		Icon:              "mdi:battery-heart",
+		EntityCategory:    "diagnostic",
+		StateTopic:        topicPrefix + "/state/battery_health",
+		UniqueID:          hostname + "_battery_max_capacity",
+		UnitOfMeasurement: "%",
+		ValueTemplate:     "{{ value_json.max_capacity }}",
+		ExpireAfter:       expireAfter(batteryInterval),
+		AvailabilityTopic: getAvailabilityTopic(),
+		Device:            device,
+	}
+	publishConfig(client, "sensor", hostname+"_battery_max_capacity", maxCapacityConfig)
+
+	adapterWattageConfig := SensorConfig{
+		Name:              hostname + " Power Adapter Wattage",
+		Icon:              "mdi:power-plug-battery",
+		StateTopic:        topicPrefix + "/state/battery_health",
+		UniqueID:          hostname + "_adapter_wattage",
+		UnitOfMeasurement: "W",
+		DeviceClass:       "power",
+		ValueTemplate:     "{{ value_json.adapter_wattage }}",
+		ExpireAfter:       expireAfter(batteryInterval),
+		AvailabilityTopic: getAvailabilityTopic(),
+		Device:            device,
+	}
+	publishConfig(client, "sensor", hostname+"_adapter_wattage", adapterWattageConfig)
+
+	chargingPowerConfig := SensorConfig{
+		Name:                      hostname + " Charging Power",
+		Icon:                      "mdi:battery-charging-high",
+		StateTopic:                topicPrefix + "/state/battery_health",
+		UniqueID:                  hostname + "_charging_power",
+		UnitOfMeasurement:         "W",
+		DeviceClass:               "power",
+		StateClass:                "measurement",
+		SuggestedDisplayPrecision: precision(1),
+		ValueTemplate:             "{{ value_json.charging_power }}",
+		ExpireAfter:               expireAfter(batteryInterval),
+		AvailabilityTopic:         getAvailabilityTopic(),
+		Device:                    device,
+	}
+	publishConfig(client, "sensor", hostname+"_charging_power", chargingPowerConfig)
+
+	if bclmPath != "" {
+		chargeLimitConfig := NumberConfig{
+			Name:              hostname + " Charge Limit",
+			Icon:              "mdi:battery-lock",
+			EntityCategory:    "config",
+			CommandTopic:      topicPrefix + "/command/charge_limit",
+			StateTopic:        topicPrefix + "/state/charge_limit",
+			UniqueID:          hostname + "_charge_limit",
+			Min:               50,
+			Max:               100,
+			AvailabilityTopic: getAvailabilityTopic(),
+			Device:            device,
+		}
+		publishConfig(client, "number", hostname+"_charge_limit", chargeLimitConfig)
+	}
+}
+
+func init() {
+	registerSensor(sensorFuncs{
+		name:     "battery",
+		interval: batteryInterval,
+		poll: func(client mqtt.Client) {
+			if !hasBattery {
+				return
+			}
+			updateBattery(client)
+			// Power adapter status is published together with battery info
+			updateBatteryHealth(client)
+			if bclmPath != "" {
+				updateChargeLimit(client)
+			}
+		},
+		discovery: publishBatteryDiscoveryConfig,
+	})
+
+	registerCommand("battery", "charge_limit", func(client mqtt.Client, command string, payload string) {
+		if bclmPath == "" || !hasBattery {
+			return
+		}
+
+		i, err := strconv.Atoi(payload)
+		if err != nil || i < 50 || i > 100 {
+			logWarnf("Incorrect charge limit value")
+			return
+		}
+
+		if err := setChargeLimit(i); err != nil {
+			reportError(client, "setting charge limit", err)
+		}
+
+		time.Sleep(1 * time.Second)
+
+		updateChargeLimit(client)
+	})
+}
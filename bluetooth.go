@@ -0,0 +1,322 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// Path to the `blueutil` tool (https://github.com/toy/blueutil),
+// empty if it is not installed and Bluetooth entities are disabled
+var blueutilPath string
+
+type bluetoothDevices struct {
+	Count   int      `json:"count"`
+	Devices []string `json:"devices"`
+}
+
+func getBluetoothPower() (bool, error) {
+	output, err := getCommandOutput(blueutilPath, "--power")
+	if err != nil {
+		return false, err
+	}
+
+	// $ blueutil --power
+	// 1
+
+	return output == "1", nil
+}
+
+// true - turn Bluetooth on
+// false - turn Bluetooth off
+func setBluetoothPower(b bool) error {
+	state := "0"
+	if b {
+		state = "1"
+	}
+
+	return runCommand(blueutilPath, "--power", state)
+}
+
+func getBluetoothConnectedDevices() (bluetoothDevices, error) {
+	output, err := getCommandOutput(blueutilPath, "--connected", "--format", "json")
+	if err != nil {
+		return bluetoothDevices{}, err
+	}
+
+	// $ blueutil --connected --format json
+	// [{"address":"a8-91-3d-00-00-00","name":"AirPods Pro","connected":true,...}]
+
+	var devices []struct {
+		Address string `json:"address"`
+		Name    string `json:"name"`
+	}
+	if err := json.Unmarshal([]byte(output), &devices); err != nil {
+		return bluetoothDevices{}, fmt.Errorf("parsing blueutil output: %v", err)
+	}
+
+	result := bluetoothDevices{Devices: []string{}}
+	for _, d := range devices {
+		name := d.Name
+		if name == "" {
+			name = d.Address
+		}
+		result.Devices = append(result.Devices, name)
+	}
+	result.Count = len(result.Devices)
+
+	return result, nil
+}
+
+type bluetoothBattery struct {
+	Name string `json:"name"`
+	// Levels in percent. Single battery devices (mice, keyboards, headphones)
+	// report only main, AirPods report left, right and case
+	Main  *int `json:"main,omitempty"`
+	Left  *int `json:"left,omitempty"`
+	Right *int `json:"right,omitempty"`
+	Case  *int `json:"case,omitempty"`
+}
+
+// Battery parts in the order they are published to Home Assistant
+var bluetoothBatteryParts = []string{"main", "left", "right", "case"}
+
+func (b bluetoothBattery) level(part string) *int {
+	switch part {
+	case "main":
+		return b.Main
+	case "left":
+		return b.Left
+	case "right":
+		return b.Right
+	case "case":
+		return b.Case
+	}
+	return nil
+}
+
+func parseBatteryPercent(value interface{}) *int {
+	s, ok := value.(string)
+	if !ok {
+		return nil
+	}
+
+	i, err := strconv.Atoi(strings.TrimSuffix(s, "%"))
+	if err != nil {
+		return nil
+	}
+
+	return &i
+}
+
+// Connected Bluetooth devices that report battery level. Works without blueutil
+func getBluetoothBatteries() ([]bluetoothBattery, error) {
+	output, err := getCommandOutput("/usr/sbin/system_profiler", "SPBluetoothDataType", "-json")
+	if err != nil {
+		return nil, err
+	}
+
+	// $ /usr/sbin/system_profiler SPBluetoothDataType -json
+	// {"SPBluetoothDataType":[{"device_connected":[{"AirPods Pro":{
+	//     "device_address":"A8:91:3D:00:00:00",
+	//     "device_batteryLevelCase":"52%",
+	//     "device_batteryLevelLeft":"100%",
+	//     "device_batteryLevelRight":"98%",...}}],...}]}
+
+	var data struct {
+		SPBluetoothDataType []struct {
+			DeviceConnected []map[string]map[string]interface{} `json:"device_connected"`
+		} `json:"SPBluetoothDataType"`
+	}
+	if err := json.Unmarshal([]byte(output), &data); err != nil {
+		return nil, fmt.Errorf("parsing system_profiler output: %v", err)
+	}
+
+	var batteries []bluetoothBattery
+
+	for _, controller := range data.SPBluetoothDataType {
+		for _, connected := range controller.DeviceConnected {
+			for name, properties := range connected {
+				b := bluetoothBattery{
+					Name:  name,
+					Main:  parseBatteryPercent(properties["device_batteryLevelMain"]),
+					Left:  parseBatteryPercent(properties["device_batteryLevelLeft"]),
+					Right: parseBatteryPercent(properties["device_batteryLevelRight"]),
+					Case:  parseBatteryPercent(properties["device_batteryLevelCase"]),
+				}
+
+				if b.Main != nil || b.Left != nil || b.Right != nil || b.Case != nil {
+					batteries = append(batteries, b)
+				}
+			}
+		}
+	}
+
+	return batteries, nil
+}
+
+func getBluetoothBatteryStateTopic(id string) string {
+	return getTopicPrefix() + "/state/bluetooth_battery/" + id
+}
+
+// With onlyNew set configs are published only for parts that were not announced yet
+func publishBluetoothBatteryConfig(client mqtt.Client, b bluetoothBattery, onlyNew bool) {
+	id := getObjectId(b.Name)
+
+	for _, part := range bluetoothBatteryParts {
+		objectId := hostname + "_bt_battery_" + id + "_" + part
+		if b.level(part) == nil || (onlyNew && isConfigPublished(objectId)) {
+			continue
+		}
+
+		name := hostname + " " + b.Name + " Battery"
+		if part != "main" {
+			name += " " + strings.ToUpper(part[:1]) + part[1:]
+		}
+
+		batteryConfig := SensorConfig{
+			Name:              name,
+			StateTopic:        getBluetoothBatteryStateTopic(id),
+			UniqueID:          objectId,
+			UnitOfMeasurement: "%",
+			DeviceClass:       "battery",
+			ValueTemplate:     "{{ value_json." + part + " }}",
+			ExpireAfter:       expireAfter(batteryInterval),
+			AvailabilityTopic: getAvailabilityTopic(),
+			Device:            getDevice(),
+		}
+		publishConfig(client, "sensor", objectId, batteryConfig)
+	}
+}
+
+func updateBluetoothBatteries(client mqtt.Client) {
+	batteries, err := getBluetoothBatteries()
+	if err != nil {
+		reportError(client, "updating bluetooth batteries", err)
+		return
+	}
+
+	for _, b := range batteries {
+		publishBluetoothBatteryConfig(client, b, true)
+
+		payload, err := json.Marshal(b)
+		if err != nil {
+			logErrorf("Error marshaling bluetooth battery: %v", err)
+			continue
+		}
+
+		publishState(client, "bluetooth battery "+b.Name, getBluetoothBatteryStateTopic(getObjectId(b.Name)), payload)
+	}
+}
+
+func updateBluetooth(client mqtt.Client) {
+	power, err := getBluetoothPower()
+	if err != nil {
+		reportError(client, "updating bluetooth power", err)
+		return
+	}
+
+	publishState(client, "bluetooth power", getTopicPrefix()+"/state/bluetooth", strconv.FormatBool(power))
+
+	devices, err := getBluetoothConnectedDevices()
+	if err != nil {
+		reportError(client, "updating bluetooth devices", err)
+		return
+	}
+
+	payload, err := json.Marshal(devices)
+	if err != nil {
+		logErrorf("Error marshaling bluetooth devices: %v", err)
+		return
+	}
+
+	publishState(client, "bluetooth devices", getTopicPrefix()+"/state/bluetooth_devices", payload)
+}
+
+// Batteries that can't be read now are announced by the next update
+func publishBluetoothBatteriesDiscoveryConfig(client mqtt.Client) {
+	batteries, err := getBluetoothBatteries()
+	if err != nil {
+		reportError(client, "reading bluetooth batteries", err)
+	}
+	for _, b := range batteries {
+		publishBluetoothBatteryConfig(client, b, false)
+	}
+}
+
+func publishBluetoothDiscoveryConfig(client mqtt.Client) {
+	topicPrefix := getTopicPrefix()
+	device := getDevice()
+
+	if blueutilPath != "" {
+		bluetoothSwitchConfig := SwitchConfig{
+			Name:              hostname + " Bluetooth",
+			Icon:              "mdi:bluetooth",
+			StateTopic:        topicPrefix + "/state/bluetooth",
+			CommandTopic:      topicPrefix + "/command/bluetooth",
+			PayloadOn:         "true",
+			PayloadOff:        "false",
+			UniqueID:          hostname + "_bluetooth",
+			AvailabilityTopic: getAvailabilityTopic(),
+			Device:            device,
+		}
+		publishConfig(client, "switch", hostname+"_bluetooth", bluetoothSwitchConfig)
+
+		bluetoothDevicesConfig := SensorConfig{
+			Name:                hostname + " Bluetooth Devices",
+			Icon:                "mdi:bluetooth-connect",
+			StateTopic:          topicPrefix + "/state/bluetooth_devices",
+			UniqueID:            hostname + "_bluetooth_devices",
+			ValueTemplate:       "{{ value_json.count }}",
+			JsonAttributesTopic: topicPrefix + "/state/bluetooth_devices",
+			ExpireAfter:         expireAfter(bluetoothInterval),
+			AvailabilityTopic:   getAvailabilityTopic(),
+			Device:              device,
+		}
+		publishConfig(client, "sensor", hostname+"_bluetooth_devices", bluetoothDevicesConfig)
+	}
+}
+
+func init() {
+	registerSensor(sensorFuncs{
+		name:     "bluetooth",
+		interval: bluetoothInterval,
+		poll: func(client mqtt.Client) {
+			if blueutilPath != "" {
+				updateBluetooth(client)
+			}
+		},
+		discovery: publishBluetoothDiscoveryConfig,
+	})
+
+	registerSensor(sensorFuncs{
+		name:      "bluetooth_batteries",
+		interval:  batteryInterval,
+		poll:      updateBluetoothBatteries,
+		discovery: publishBluetoothBatteriesDiscoveryConfig,
+	})
+
+	registerCommand("bluetooth", "bluetooth", func(client mqtt.Client, command string, payload string) {
+		if blueutilPath == "" {
+			return
+		}
+
+		b, err := strconv.ParseBool(payload)
+		if err != nil {
+			logWarnf("Incorrect bluetooth value")
+			return
+		}
+
+		if err := setBluetoothPower(b); err != nil {
+			reportError(client, "setting bluetooth power", err)
+		}
+
+		time.Sleep(1 * time.Second)
+
+		updateBluetooth(client)
+	})
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// An additional MQTT broker, e.g. a cloud broker next to the local Mosquitto.
+// States and discovery configs are published to it too, commands are received
+// only from the main broker set with mqtt_ip or mqtt_url
+type brokerConfig struct {
+	URL              string `yaml:"url"`
+	User             string `yaml:"user"`
+	Password         string `yaml:"password"`
+	PasswordKeychain string `yaml:"password_keychain"`
+}
+
+var extraClients []mqtt.Client
+
+// Publishes to the main broker and to all additional brokers. Everything else,
+// subscriptions included, goes to the main broker only
+type multiClient struct {
+	mqtt.Client
+	extra []mqtt.Client
+}
+
+// Returned token is the one of the main broker. Additional brokers may be
+// far away or down, they must not slow down or fail publishing to the main one
+func (m *multiClient) Publish(topic string, qos byte, retained bool, payload interface{}) mqtt.Token {
+	for _, c := range m.extra {
+		if !c.IsConnectionOpen() {
+			continue
+		}
+
+		server := getBrokerName(c)
+		token := c.Publish(topic, qos, retained, payload)
+		go func() {
+			if !token.WaitTimeout(tokenTimeOut) {
+				logWarnf("Publish to %s timed out after %v", server, tokenTimeOut)
+			} else if token.Error() != nil {
+				logErrorf("Error publishing to %s: %v", server, token.Error())
+			}
+		}()
+	}
+
+	return m.Client.Publish(topic, qos, retained, payload)
+}
+
+func (m *multiClient) Disconnect(quiesce uint) {
+	for _, c := range m.extra {
+		c.Disconnect(quiesce)
+	}
+	m.Client.Disconnect(quiesce)
+}
+
+// Client of the main broker that publishes to the additional brokers too
+func withBrokers(client mqtt.Client) mqtt.Client {
+	if len(extraClients) == 0 {
+		return client
+	}
+
+	return &multiClient{Client: client, extra: extraClients}
+}
+
+func getBrokerName(client mqtt.Client) string {
+	options := client.OptionsReader()
+	if servers := options.Servers(); len(servers) > 0 {
+		return servers[0].Host
+	}
+	return "MQTT"
+}
+
+// Additional brokers are connected in the background, mac2mqtt works with
+// the main broker while they are unreachable
+func connectBrokers(brokers []brokerConfig) {
+	for _, b := range brokers {
+		opts := getMQTTOptions(b.URL, b.User, b.Password)
+
+		opts.OnConnect = func(client mqtt.Client) {
+			logInfof("Connected to %s", getBrokerName(client))
+
+			updateAvailability(client, true)
+			publishHADiscoveryConfig(client)
+
+			// the broker has missed the states that were not changed, so
+			// everything is published again
+			resetStateCache()
+		}
+		opts.OnConnectionLost = func(client mqtt.Client, err error) {
+			logWarnf("Disconnected from %s: %v", getBrokerName(client), err)
+		}
+
+		c := mqtt.NewClient(opts)
+		c.Connect()
+
+		extraClients = append(extraClients, c)
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// Takes a screenshot of the main display as JPEG no bigger than
+// settings.ScreenshotMaxSize pixels on the longest side
+func getScreenshot() ([]byte, error) {
+	f, err := os.CreateTemp("", "mac2mqtt-screenshot-*.jpg")
+	if err != nil {
+		return nil, fmt.Errorf("creating screenshot file: %v", err)
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+
+	// -x: no sound, -m: main display only. Requires Screen Recording permission
+	if err := runCommand("/usr/sbin/screencapture", "-x", "-m", "-t", "jpg", f.Name()); err != nil {
+		return nil, err
+	}
+
+	// Retina screenshots are several megabytes, too much for MQTT
+	if err := runCommand("/usr/bin/sips", "-Z", strconv.Itoa(settings.ScreenshotMaxSize), f.Name()); err != nil {
+		return nil, err
+	}
+
+	return os.ReadFile(f.Name())
+}
+
+// Path to the `imagesnap` tool (https://github.com/rharder/imagesnap),
+// empty if it is not installed or the webcam is not enabled in mac2mqtt.yaml
+var imagesnapPath string
+
+// Takes a photo with the built-in camera, scaled like screenshots
+func getWebcamImage() ([]byte, error) {
+	f, err := os.CreateTemp("", "mac2mqtt-webcam-*.jpg")
+	if err != nil {
+		return nil, fmt.Errorf("creating webcam file: %v", err)
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+
+	// -w: give the camera a second to adjust exposure. Requires Camera permission
+	if err := runCommand(imagesnapPath, "-q", "-w", "1", f.Name()); err != nil {
+		return nil, err
+	}
+
+	if err := runCommand("/usr/bin/sips", "-Z", strconv.Itoa(settings.ScreenshotMaxSize), f.Name()); err != nil {
+		return nil, err
+	}
+
+	return os.ReadFile(f.Name())
+}
+
+func updateWebcam(client mqtt.Client) {
+	image, err := getWebcamImage()
+	if err != nil {
+		reportError(client, "taking webcam photo", err)
+		return
+	}
+
+	publishState(client, "webcam", getTopicPrefix()+"/camera/webcam", image)
+}
+
+func updateScreenshot(client mqtt.Client) {
+	image, err := getScreenshot()
+	if err != nil {
+		reportError(client, "taking screenshot", err)
+		return
+	}
+
+	publishState(client, "screenshot", getTopicPrefix()+"/camera/screenshot", image)
+}
+
+func publishScreenshotDiscoveryConfig(client mqtt.Client) {
+	topicPrefix := getTopicPrefix()
+	device := getDevice()
+
+	screenshotCameraConfig := CameraConfig{
+		Name:              hostname + " Screen",
+		Icon:              "mdi:monitor-screenshot",
+		Topic:             topicPrefix + "/camera/screenshot",
+		UniqueID:          hostname + "_screenshot",
+		AvailabilityTopic: getAvailabilityTopic(),
+		Device:            device,
+	}
+	publishConfig(client, "camera", hostname+"_screenshot", screenshotCameraConfig)
+
+	screenshotButtonConfig := ButtonConfig{
+		Name:              hostname + " Take Screenshot",
+		Icon:              "mdi:monitor-screenshot",
+		CommandTopic:      topicPrefix + "/command/screenshot",
+		PayloadPress:      "screenshot",
+		UniqueID:          hostname + "_take_screenshot",
+		AvailabilityTopic: getAvailabilityTopic(),
+		Device:            device,
+	}
+	publishConfig(client, "button", hostname+"_take_screenshot", screenshotButtonConfig)
+}
+
+func publishWebcamDiscoveryConfig(client mqtt.Client) {
+	if imagesnapPath == "" {
+		return
+	}
+
+	topicPrefix := getTopicPrefix()
+	device := getDevice()
+
+	webcamCameraConfig := CameraConfig{
+		Name:              hostname + " Webcam",
+		Icon:              "mdi:webcam",
+		Topic:             topicPrefix + "/camera/webcam",
+		UniqueID:          hostname + "_webcam",
+		AvailabilityTopic: getAvailabilityTopic(),
+		Device:            device,
+	}
+	publishConfig(client, "camera", hostname+"_webcam", webcamCameraConfig)
+
+	webcamButtonConfig := ButtonConfig{
+		Name:              hostname + " Take Webcam Photo",
+		Icon:              "mdi:camera",
+		CommandTopic:      topicPrefix + "/command/webcam",
+		PayloadPress:      "webcam",
+		UniqueID:          hostname + "_take_webcam_photo",
+		AvailabilityTopic: getAvailabilityTopic(),
+		Device:            device,
+	}
+	publishConfig(client, "button", hostname+"_take_webcam_photo", webcamButtonConfig)
+}
+
+func init() {
+	registerSensor(sensorFuncs{
+		name:      "screenshot",
+		discovery: publishScreenshotDiscoveryConfig,
+	})
+
+	registerSensor(sensorFuncs{
+		name:      "webcam",
+		discovery: publishWebcamDiscoveryConfig,
+	})
+
+	registerCommand("screenshot", "screenshot", func(client mqtt.Client, command string, payload string) {
+		if payload == "screenshot" {
+			updateScreenshot(client)
+		}
+	})
+
+	registerCommand("webcam", "webcam", func(client mqtt.Client, command string, payload string) {
+		if imagesnapPath != "" && payload == "webcam" {
+			updateWebcam(client)
+		}
+	})
+}
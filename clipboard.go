@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"os/exec"
+	"strings"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+func getClipboard() (string, error) {
+	return getCommandOutput("/usr/bin/pbpaste")
+}
+
+func setClipboard(text string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), commandTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "/usr/bin/pbcopy")
+	cmd.Stdin = strings.NewReader(text)
+
+	if _, err := cmd.Output(); err != nil {
+		return commandContextError(ctx, "/usr/bin/pbcopy", err)
+	}
+
+	return nil
+}
+
+// The clipboard is published only on PREFIX/command/clipboard, it is never polled
+func updateClipboard(client mqtt.Client) {
+	text, err := getClipboard()
+	if err != nil {
+		reportError(client, "reading clipboard", err)
+		return
+	}
+
+	publishState(client, "clipboard", getTopicPrefix()+"/state/clipboard", text)
+}
+
+func publishClipboardDiscoveryConfig(client mqtt.Client) {
+	if !settings.Clipboard {
+		return
+	}
+
+	topicPrefix := getTopicPrefix()
+	device := getDevice()
+
+	clipboardTextConfig := TextConfig{
+		Name:              hostname + " Clipboard",
+		Icon:              "mdi:clipboard-text-outline",
+		StateTopic:        topicPrefix + "/state/clipboard",
+		CommandTopic:      topicPrefix + "/command/set_clipboard",
+		UniqueID:          hostname + "_clipboard",
+		AvailabilityTopic: getAvailabilityTopic(),
+		Device:            device,
+	}
+	publishConfig(client, "text", hostname+"_clipboard", clipboardTextConfig)
+
+	clipboardButtonConfig := ButtonConfig{
+		Name:              hostname + " Read Clipboard",
+		Icon:              "mdi:clipboard-arrow-down-outline",
+		CommandTopic:      topicPrefix + "/command/clipboard",
+		PayloadPress:      "clipboard",
+		UniqueID:          hostname + "_read_clipboard",
+		AvailabilityTopic: getAvailabilityTopic(),
+		Device:            device,
+	}
+	publishConfig(client, "button", hostname+"_read_clipboard", clipboardButtonConfig)
+}
+
+func init() {
+	registerSensor(sensorFuncs{
+		name:      "clipboard",
+		discovery: publishClipboardDiscoveryConfig,
+	})
+
+	registerCommand("clipboard", "clipboard", func(client mqtt.Client, command string, payload string) {
+		if settings.Clipboard && payload == "clipboard" {
+			updateClipboard(client)
+		}
+	})
+
+	registerCommand("clipboard", "set_clipboard", func(client mqtt.Client, command string, payload string) {
+		if !settings.Clipboard {
+			return
+		}
+
+		if err := setClipboard(payload); err != nil {
+			reportError(client, "setting clipboard", err)
+			return
+		}
+
+		updateClipboard(client)
+	})
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// Latest value of every PREFIX/state/... topic, keyed by the part after
+// "state/", e.g. "volume" or "disk/root". Published together as one JSON
+// document to PREFIX/state when combined_state is on and served by the HTTP
+// API. Unlike stateCache it is never reset
+var combinedState = map[string]string{}
+var combinedStateMutex sync.Mutex
+
+// Last published document, without the time, to send it only on change
+var lastCombinedState string
+
+func recordCombinedState(topic string, value string) {
+	key, found := strings.CutPrefix(topic, getTopicPrefix()+"/state/")
+	if !found {
+		return
+	}
+
+	combinedStateMutex.Lock()
+	combinedState[key] = value
+	combinedStateMutex.Unlock()
+}
+
+// All states as one JSON object
+func getCombinedState() map[string]interface{} {
+	document := map[string]interface{}{}
+
+	combinedStateMutex.Lock()
+	for key, value := range combinedState {
+		// numbers, booleans and JSON objects are put into the document as
+		// they are, everything else as strings
+		if json.Valid([]byte(value)) {
+			document[key] = json.RawMessage(value)
+		} else {
+			document[key] = value
+		}
+	}
+	combinedStateMutex.Unlock()
+
+	return document
+}
+
+func updateCombinedState(client mqtt.Client) {
+	document := getCombinedState()
+
+	payload, err := json.Marshal(document)
+	if err != nil {
+		logErrorf("Error marshaling combined state: %v", err)
+		return
+	}
+
+	stateCacheMutex.Lock()
+	_, refresh := stateCache[getCombinedStateTopic()]
+	stateCacheMutex.Unlock()
+
+	// the cache is reset after connect and every stateRefreshInterval
+	if string(payload) == lastCombinedState && refresh {
+		return
+	}
+	lastCombinedState = string(payload)
+
+	document["updated"] = time.Now().UTC().Format(time.RFC3339)
+	payload, err = json.Marshal(document)
+	if err != nil {
+		logErrorf("Error marshaling combined state: %v", err)
+		return
+	}
+
+	publishState(client, "combined state", getCombinedStateTopic(), payload)
+}
+
+func getCombinedStateTopic() string {
+	return getTopicPrefix() + "/state"
+}
+
+func publishCombinedStateDiscoveryConfig(client mqtt.Client) {
+	if !settings.CombinedState {
+		return
+	}
+
+	// all values are attributes of this sensor
+	lastUpdateConfig := SensorConfig{
+		Name:                hostname + " Last Update",
+		Icon:                "mdi:update",
+		EntityCategory:      "diagnostic",
+		StateTopic:          getCombinedStateTopic(),
+		UniqueID:            hostname + "_last_update",
+		DeviceClass:         "timestamp",
+		ValueTemplate:       "{{ value_json.updated }}",
+		JsonAttributesTopic: getCombinedStateTopic(),
+		AvailabilityTopic:   getAvailabilityTopic(),
+		Device:              getDevice(),
+	}
+	publishConfig(client, "sensor", hostname+"_last_update", lastUpdateConfig)
+}
+
+func init() {
+	registerSensor(sensorFuncs{
+		name:     "combined_state",
+		interval: screenInterval,
+		poll: func(client mqtt.Client) {
+			if settings.CombinedState {
+				updateCombinedState(client)
+			}
+		},
+		discovery: publishCombinedStateDiscoveryConfig,
+	})
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// User defined command from the `commands` section of mac2mqtt.yaml. Only
+// commands from this list can be run, the shell text itself is never taken
+// from MQTT
+func commandRun(name string) error {
+	line := settings.Commands[name]
+
+	if isDryRun("running command " + name + ": " + line) {
+		return nil
+	}
+
+	logDebugf("Running command %s: %s", name, line)
+
+	ctx, cancel := context.WithTimeout(context.Background(), longCommandTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "/bin/sh", "-c", line)
+
+	// stdout is included too, scripts often print their errors there
+	output, err := cmd.CombinedOutput()
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("command %s: killed after %v", name, longCommandTimeout)
+	}
+	if err != nil {
+		return fmt.Errorf("command %s: %v: %s", name, err, strings.TrimSpace(string(output)))
+	}
+
+	return nil
+}
+
+func publishCommandsDiscoveryConfig(client mqtt.Client) {
+	topicPrefix := getTopicPrefix()
+	device := getDevice()
+
+	for name := range settings.Commands {
+		commandButtonConfig := ButtonConfig{
+			Name:              hostname + " " + name,
+			Icon:              "mdi:console",
+			CommandTopic:      topicPrefix + "/command/run/" + name,
+			PayloadPress:      "run",
+			UniqueID:          hostname + "_run_" + name,
+			AvailabilityTopic: getAvailabilityTopic(),
+			Device:            device,
+		}
+		publishConfig(client, "button", hostname+"_run_"+name, commandButtonConfig)
+	}
+}
+
+func init() {
+	registerSensor(sensorFuncs{
+		name:      "commands",
+		discovery: publishCommandsDiscoveryConfig,
+	})
+
+	registerCommand("commands", "run/", func(client mqtt.Client, command string, payload string) {
+		name := strings.TrimPrefix(command, "run/")
+		if _, ok := settings.Commands[name]; !ok || payload != "run" {
+			logWarnf("Unknown command %s", name)
+			return
+		}
+
+		go func() {
+			if err := commandRun(name); err != nil {
+				reportError(client, "running command", err)
+			}
+		}()
+	})
+}
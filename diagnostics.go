@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// Health of mac2mqtt itself, published to PREFIX/state/diagnostics, so
+// Home Assistant can alert when the bridge stops working properly
+var diagnostics struct {
+	published     atomic.Int64
+	publishErrors atomic.Int64
+	errors        atomic.Int64
+	connects      atomic.Int64
+}
+
+var startTime = time.Now()
+
+// Time of the last poll of every sensor
+var lastPolls = map[string]time.Time{}
+var lastPollsMutex sync.Mutex
+
+func recordPoll(name string) {
+	lastPollsMutex.Lock()
+	lastPolls[name] = time.Now()
+	lastPollsMutex.Unlock()
+}
+
+type diagnosticsState struct {
+	Published     int64 `json:"published"`
+	PublishErrors int64 `json:"publish_errors"`
+	Errors        int64 `json:"errors"`
+	// connects after the first one
+	Reconnects int64 `json:"reconnects"`
+	// memory taken from the OS, in megabytes
+	Memory     float64           `json:"memory"`
+	Goroutines int               `json:"goroutines"`
+	Started    string            `json:"started"`
+	LastPolls  map[string]string `json:"last_polls"`
+}
+
+func getDiagnostics() diagnosticsState {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+
+	state := diagnosticsState{
+		Published:     diagnostics.published.Load(),
+		PublishErrors: diagnostics.publishErrors.Load(),
+		Errors:        diagnostics.errors.Load(),
+		Memory:        float64(m.Sys) / 1024 / 1024,
+		Goroutines:    runtime.NumGoroutine(),
+		Started:       startTime.UTC().Format(time.RFC3339),
+		LastPolls:     map[string]string{},
+	}
+
+	if connects := diagnostics.connects.Load(); connects > 1 {
+		state.Reconnects = connects - 1
+	}
+
+	lastPollsMutex.Lock()
+	for name, t := range lastPolls {
+		state.LastPolls[name] = t.UTC().Format(time.RFC3339)
+	}
+	lastPollsMutex.Unlock()
+
+	return state
+}
+
+func updateDiagnostics(client mqtt.Client) {
+	payload, err := json.Marshal(getDiagnostics())
+	if err != nil {
+		logErrorf("Error marshaling diagnostics: %v", err)
+		return
+	}
+
+	publishState(client, "diagnostics", getTopicPrefix()+"/state/diagnostics", payload)
+}
+
+func publishDiagnosticsDiscoveryConfig(client mqtt.Client) {
+	topicPrefix := getTopicPrefix()
+	device := getDevice()
+
+	sensors := []struct {
+		objectId string
+		name     string
+		icon     string
+		key      string
+		unit     string
+	}{
+		{"published", "Messages Published", "mdi:upload-network", "published", ""},
+		{"publish_errors", "Publish Errors", "mdi:upload-off", "publish_errors", ""},
+		{"errors", "Errors", "mdi:alert-circle-outline", "errors", ""},
+		{"mqtt_reconnects", "MQTT Reconnects", "mdi:lan-disconnect", "reconnects", ""},
+		{"memory_usage", "Memory Usage", "mdi:memory", "memory", "MB"},
+	}
+
+	for _, s := range sensors {
+		config := SensorConfig{
+			Name:              hostname + " " + s.name,
+			Icon:              s.icon,
+			EntityCategory:    "diagnostic",
+			StateTopic:        topicPrefix + "/state/diagnostics",
+			UniqueID:          hostname + "_" + s.objectId,
+			UnitOfMeasurement: s.unit,
+			StateClass:        "total_increasing",
+			ValueTemplate:     "{{ value_json." + s.key + " }}",
+			ExpireAfter:       expireAfter(systemInterval),
+			AvailabilityTopic: getAvailabilityTopic(),
+			Device:            device,
+		}
+
+		if s.unit != "" {
+			config.DeviceClass = "data_size"
+			config.StateClass = "measurement"
+			config.SuggestedDisplayPrecision = precision(1)
+		}
+
+		// the poll times are attributes of the errors sensor
+		if s.objectId == "errors" {
+			config.JsonAttributesTopic = topicPrefix + "/state/diagnostics"
+		}
+
+		publishConfig(client, "sensor", hostname+"_"+s.objectId, config)
+	}
+}
+
+func init() {
+	registerSensor(sensorFuncs{
+		name:      "diagnostics",
+		interval:  systemInterval,
+		poll:      updateDiagnostics,
+		discovery: publishDiagnosticsDiscoveryConfig,
+	})
+}
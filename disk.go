@@ -0,0 +1,269 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/bessarabov/mac2mqtt/internal/df"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+type diskConfig struct {
+	// Mount points to report. When empty all local volumes are reported,
+	// except the APFS helper volumes under /System/Volumes/
+	Include []string `yaml:"include"`
+	// Mount points that are never reported
+	Exclude []string `yaml:"exclude"`
+	// Mount points of external drives that get a presence sensor and an
+	// Eject button, e.g. /Volumes/Backup
+	External []string `yaml:"external"`
+}
+
+type diskInfo struct {
+	MountPoint  string  `json:"mount_point"`
+	TotalGB     float64 `json:"total_gb"`
+	FreeGB      float64 `json:"free_gb"`
+	UsedPercent float64 `json:"used_percent"`
+}
+
+// "/" => "root"
+// "/Volumes/Backup Disk" => "volumes_backup_disk"
+func getDiskId(mountPoint string) string {
+	id := getObjectId(mountPoint)
+
+	if id == "" {
+		return "root"
+	}
+
+	return id
+}
+
+func isDiskReported(mountPoint string) bool {
+
+	for _, m := range settings.Disk.Exclude {
+		if m == mountPoint {
+			return false
+		}
+	}
+
+	if len(settings.Disk.Include) > 0 {
+		for _, m := range settings.Disk.Include {
+			if m == mountPoint {
+				return true
+			}
+		}
+		return false
+	}
+
+	return !strings.HasPrefix(mountPoint, "/System/Volumes/")
+}
+
+func getDisks() ([]diskInfo, error) {
+	output, err := getCommandOutput("/bin/df", "-k", "-P", "-l")
+	if err != nil {
+		return nil, err
+	}
+
+	var disks []diskInfo
+
+	for _, fs := range df.Parse(output) {
+		if !isDiskReported(fs.MountPoint) {
+			continue
+		}
+
+		total := fs.Used + fs.Available
+		usedPercent := 0.0
+		if total > 0 {
+			usedPercent = fs.Used / total * 100
+		}
+
+		disks = append(disks, diskInfo{
+			MountPoint:  fs.MountPoint,
+			TotalGB:     round2(total / 1024 / 1024),
+			FreeGB:      round2(fs.Available / 1024 / 1024),
+			UsedPercent: round2(usedPercent),
+		})
+	}
+
+	return disks, nil
+}
+
+func round2(f float64) float64 {
+	return math.Round(f*100) / 100
+}
+
+func getDiskStateTopic(id string) string {
+	return getTopicPrefix() + "/state/disk/" + id
+}
+
+func publishDiskConfig(client mqtt.Client, disk diskInfo) {
+	id := getDiskId(disk.MountPoint)
+
+	diskSensorConfig := SensorConfig{
+		Name:                      hostname + " Disk " + disk.MountPoint,
+		Icon:                      "mdi:harddisk",
+		SuggestedDisplayPrecision: precision(1),
+		StateTopic:                getDiskStateTopic(id),
+		UniqueID:                  hostname + "_disk_" + id,
+		UnitOfMeasurement:         "%",
+		ValueTemplate:             "{{ value_json.used_percent }}",
+		JsonAttributesTopic:       getDiskStateTopic(id),
+		ExpireAfter:               expireAfter(diskInterval),
+		AvailabilityTopic:         getAvailabilityTopic(),
+		Device:                    getDevice(),
+	}
+	publishConfig(client, "sensor", hostname+"_disk_"+id, diskSensorConfig)
+}
+
+// Disks that can't be read now are announced by the next update
+func publishDiskDiscoveryConfig(client mqtt.Client) {
+	disks, err := getDisks()
+	if err != nil {
+		reportError(client, "reading disks", err)
+		return
+	}
+
+	for _, disk := range disks {
+		publishDiskConfig(client, disk)
+	}
+}
+
+func updateDisks(client mqtt.Client) {
+	disks, err := getDisks()
+	if err != nil {
+		reportError(client, "updating disks", err)
+		return
+	}
+
+	for _, disk := range disks {
+		id := getDiskId(disk.MountPoint)
+
+		// volume was mounted after discovery configs were published
+		if !isConfigPublished(hostname + "_disk_" + id) {
+			publishDiskConfig(client, disk)
+		}
+
+		payload, err := json.Marshal(disk)
+		if err != nil {
+			logErrorf("Error marshaling disk info: %v", err)
+			continue
+		}
+
+		publishState(client, "disk "+disk.MountPoint, getDiskStateTopic(id), payload)
+	}
+}
+
+// Presence of the external drives by disk id. A volume is present when its
+// mount point exists, macOS removes it when the drive is ejected
+func getExternalDrives() map[string]bool {
+	drives := map[string]bool{}
+
+	for _, mountPoint := range settings.Disk.External {
+		_, err := os.Stat(mountPoint)
+		drives[getDiskId(mountPoint)] = err == nil
+	}
+
+	return drives
+}
+
+// Returns the mount point from disk.external for a disk id
+func findExternalDrive(id string) (string, bool) {
+	for _, mountPoint := range settings.Disk.External {
+		if getDiskId(mountPoint) == id {
+			return mountPoint, true
+		}
+	}
+	return "", false
+}
+
+// Unmounts all volumes of the drive, so it can be powered off safely
+func commandEject(mountPoint string) error {
+	return runCommand("/usr/sbin/diskutil", "eject", mountPoint)
+}
+
+func updateExternalDrives(client mqtt.Client) {
+	if len(settings.Disk.External) == 0 {
+		return
+	}
+
+	payload, err := json.Marshal(getExternalDrives())
+	if err != nil {
+		logErrorf("Error marshaling external drives: %v", err)
+		return
+	}
+
+	publishState(client, "external drives", getTopicPrefix()+"/state/external_drives", payload)
+}
+
+func publishExternalDrivesDiscoveryConfig(client mqtt.Client) {
+	topicPrefix := getTopicPrefix()
+	device := getDevice()
+
+	for _, mountPoint := range settings.Disk.External {
+		id := getDiskId(mountPoint)
+
+		presenceConfig := BinarySensorConfig{
+			Name:              hostname + " Drive " + mountPoint,
+			Icon:              "mdi:harddisk",
+			StateTopic:        topicPrefix + "/state/external_drives",
+			ValueTemplate:     "{{ 'ON' if value_json['" + id + "'] else 'OFF' }}",
+			DeviceClass:       "plug",
+			UniqueID:          hostname + "_drive_" + id,
+			ExpireAfter:       expireAfter(externalDrivesInterval),
+			AvailabilityTopic: getAvailabilityTopic(),
+			Device:            device,
+		}
+		publishConfig(client, "binary_sensor", hostname+"_drive_"+id, presenceConfig)
+
+		ejectConfig := ButtonConfig{
+			Name:              hostname + " Eject " + mountPoint,
+			Icon:              "mdi:eject",
+			CommandTopic:      topicPrefix + "/command/eject/" + id,
+			PayloadPress:      "eject",
+			UniqueID:          hostname + "_eject_" + id,
+			AvailabilityTopic: getAvailabilityTopic(),
+			Device:            device,
+		}
+		publishConfig(client, "button", hostname+"_eject_"+id, ejectConfig)
+	}
+}
+
+func init() {
+	registerSensor(sensorFuncs{
+		name:      "disks",
+		interval:  diskInterval,
+		poll:      updateDisks,
+		discovery: publishDiskDiscoveryConfig,
+	})
+
+	registerSensor(sensorFuncs{
+		name:      "external_drives",
+		interval:  externalDrivesInterval,
+		poll:      updateExternalDrives,
+		discovery: publishExternalDrivesDiscoveryConfig,
+	})
+
+	registerCommand("external_drives", "eject/", func(client mqtt.Client, command string, payload string) {
+		if payload != "eject" {
+			logWarnf("Incorrect eject value")
+			return
+		}
+
+		mountPoint, ok := findExternalDrive(strings.TrimPrefix(command, "eject/"))
+		if !ok {
+			logWarnf("Unknown drive %s", strings.TrimPrefix(command, "eject/"))
+			return
+		}
+
+		if err := commandEject(mountPoint); err != nil {
+			reportError(client, "ejecting "+mountPoint, err)
+		}
+
+		time.Sleep(1 * time.Second)
+
+		updateExternalDrives(client)
+	})
+}
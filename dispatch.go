@@ -0,0 +1,183 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// A Home Assistant slider sends dozens of values while it is dragged, and
+// every value starts osascript and sleeps a second before reading the state
+// back. Only the last value of such a burst is set
+const commandDebounce = 300 * time.Millisecond
+
+var debouncedCommands = map[string]bool{
+	"volume":             true,
+	"brightness":         true,
+	"keyboard_backlight": true,
+	"charge_limit":       true,
+	"audio_output":       true,
+	"audio_input":        true,
+}
+
+// Shortest time between two runs of a command, repeated messages are ignored.
+// Can be changed with command_intervals in mac2mqtt.yaml
+var defaultCommandIntervals = map[string]int{
+	"sleep":        10,
+	"displaysleep": 10,
+	"shutdown":     10,
+	"restart":      10,
+	"lockscreen":   10,
+	"run":          2,
+	"shortcut":     2,
+	"key":          2,
+	"script":       2,
+	"screenshot":   2,
+	"webcam":       2,
+}
+
+var errCommandTooOften = errors.New("command was sent too often")
+
+type debouncedCommand struct {
+	mutex   sync.Mutex
+	payload string
+	timer   *time.Timer
+	// only one run of a command at a time
+	running sync.Mutex
+}
+
+var debounced = map[string]*debouncedCommand{}
+var lastCommandRuns = map[string]time.Time{}
+var dispatchMutex sync.Mutex
+
+func getCommandInterval(command string) time.Duration {
+	// run/NAME commands are limited one by one with the interval of "run"
+	name, _, _ := strings.Cut(command, "/")
+
+	seconds, found := settings.CommandIntervals[name]
+	if !found {
+		seconds = defaultCommandIntervals[name]
+	}
+
+	return time.Duration(seconds) * time.Second
+}
+
+// Runs a command received from MQTT or the HTTP API with debouncing and rate
+// limits. Debounced commands run later in their own goroutine, their errors
+// are only logged
+func dispatchCommand(client mqtt.Client, command string, payload string) error {
+	if debouncedCommands[command] {
+		debounceCommand(client, command, payload)
+		return nil
+	}
+
+	interval := getCommandInterval(command)
+
+	// The slot is taken before the command runs, so the same command arriving
+	// at once from MQTT and the HTTP API runs only once
+	var last time.Time
+	var found bool
+	now := time.Now()
+	if interval > 0 {
+		dispatchMutex.Lock()
+		last, found = lastCommandRuns[command]
+		if found && now.Sub(last) < interval {
+			dispatchMutex.Unlock()
+			return errCommandTooOften
+		}
+		lastCommandRuns[command] = now
+		dispatchMutex.Unlock()
+	}
+
+	err := handleCommand(client, command, payload)
+
+	// a rejected command, e.g. without a signature, must not block the next
+	// one, the slot is given back unless a later command took it already
+	if err != nil && interval > 0 {
+		dispatchMutex.Lock()
+		if lastCommandRuns[command].Equal(now) {
+			if found {
+				lastCommandRuns[command] = last
+			} else {
+				delete(lastCommandRuns, command)
+			}
+		}
+		dispatchMutex.Unlock()
+	}
+
+	return err
+}
+
+func debounceCommand(client mqtt.Client, command string, payload string) {
+	dispatchMutex.Lock()
+	d, found := debounced[command]
+	if !found {
+		d = &debouncedCommand{}
+		debounced[command] = d
+	}
+	dispatchMutex.Unlock()
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	d.payload = payload
+	if d.timer != nil {
+		return
+	}
+
+	d.timer = time.AfterFunc(commandDebounce, func() {
+		d.mutex.Lock()
+		payload := d.payload
+		d.timer = nil
+		d.mutex.Unlock()
+
+		d.running.Lock()
+		defer d.running.Unlock()
+
+		if err := handleCommand(client, command, payload); err != nil {
+			logWarnf("Command %s is ignored: %v", command, err)
+		}
+	})
+}
+
+// Topics cleared by clearRetainedCommand. The broker sends the empty message
+// back, it must not be taken for a command
+var clearedTopics = map[string]bool{}
+var clearedTopicsMutex sync.Mutex
+
+// A retained "sleep" would put the Mac to sleep again on every reconnect, so
+// the command topic gets an empty retained message, which removes it
+func clearRetainedCommand(client mqtt.Client, topic string) {
+	clearedTopicsMutex.Lock()
+	clearedTopics[topic] = true
+	clearedTopicsMutex.Unlock()
+
+	// waiting for the token in a message handler would block the client
+	token := client.Publish(topic, settings.QoS.Command, true, "")
+	go func() {
+		if !token.WaitTimeout(tokenTimeOut) {
+			logWarnf("Clearing %s timed out after %v", topic, tokenTimeOut)
+		} else if token.Error() != nil {
+			logErrorf("Error clearing %s: %v", topic, token.Error())
+		}
+	}()
+}
+
+func isClearedCommandEcho(topic string, payload string) bool {
+	if payload != "" {
+		return false
+	}
+
+	clearedTopicsMutex.Lock()
+	defer clearedTopicsMutex.Unlock()
+
+	if !clearedTopics[topic] {
+		return false
+	}
+	delete(clearedTopics, topic)
+
+	return true
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// Full path of the docker tool of Docker Desktop or OrbStack, empty when
+// neither is installed
+var dockerPath string
+
+func findDocker() string {
+	if path := findTool("docker"); path != "" {
+		return path
+	}
+
+	paths := []string{"/Applications/Docker.app/Contents/Resources/bin/docker"}
+	if home, err := os.UserHomeDir(); err == nil {
+		paths = append(paths, filepath.Join(home, ".orbstack/bin/docker"))
+	}
+
+	for _, path := range paths {
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+
+	return ""
+}
+
+type dockerState struct {
+	// The Docker engine (Docker Desktop or OrbStack) is running
+	Running bool `json:"running"`
+	// Number of running containers
+	Count int `json:"count"`
+	// Running state of the containers from the containers section of
+	// mac2mqtt.yaml, keys are object ids of the names
+	Containers map[string]bool `json:"containers"`
+}
+
+func getDockerState() (dockerState, error) {
+	state := dockerState{Containers: map[string]bool{}}
+	for _, name := range settings.Containers {
+		state.Containers[getObjectId(name)] = false
+	}
+
+	// $ docker ps --format '{{.Names}}'
+	// homeassistant
+	// mosquitto
+	output, err := getCommandOutput(dockerPath, "ps", "--format", "{{.Names}}")
+	if err != nil {
+		// the app is installed but not started
+		if strings.Contains(err.Error(), "Cannot connect to the Docker daemon") {
+			return state, nil
+		}
+		return dockerState{}, err
+	}
+	state.Running = true
+
+	for _, name := range strings.Split(output, "\n") {
+		if name == "" {
+			continue
+		}
+		state.Count++
+
+		if _, found := findContainer(getObjectId(name)); found {
+			state.Containers[getObjectId(name)] = true
+		}
+	}
+
+	return state, nil
+}
+
+// Returns the container name from the containers section for an object id
+func findContainer(id string) (string, bool) {
+	for _, name := range settings.Containers {
+		if getObjectId(name) == id {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+func updateDocker(client mqtt.Client) {
+	if dockerPath == "" {
+		return
+	}
+
+	state, err := getDockerState()
+	if err != nil {
+		reportError(client, "updating docker", err)
+		return
+	}
+
+	payload, err := json.Marshal(state)
+	if err != nil {
+		logErrorf("Error marshaling docker: %v", err)
+		return
+	}
+
+	publishState(client, "docker", getTopicPrefix()+"/state/docker", payload)
+}
+
+func publishDockerDiscoveryConfig(client mqtt.Client) {
+	if dockerPath == "" {
+		return
+	}
+
+	topicPrefix := getTopicPrefix()
+	device := getDevice()
+
+	dockerRunningConfig := BinarySensorConfig{
+		Name:              hostname + " Docker",
+		Icon:              "mdi:docker",
+		StateTopic:        topicPrefix + "/state/docker",
+		ValueTemplate:     "{{ 'ON' if value_json.running else 'OFF' }}",
+		UniqueID:          hostname + "_docker",
+		DeviceClass:       "running",
+		ExpireAfter:       expireAfter(dockerInterval),
+		AvailabilityTopic: getAvailabilityTopic(),
+		Device:            device,
+	}
+	publishConfig(client, "binary_sensor", hostname+"_docker", dockerRunningConfig)
+
+	dockerCountConfig := SensorConfig{
+		Name:              hostname + " Running Containers",
+		Icon:              "mdi:package-variant",
+		StateTopic:        topicPrefix + "/state/docker",
+		ValueTemplate:     "{{ value_json.count }}",
+		StateClass:        "measurement",
+		UniqueID:          hostname + "_docker_containers",
+		ExpireAfter:       expireAfter(dockerInterval),
+		AvailabilityTopic: getAvailabilityTopic(),
+		Device:            device,
+	}
+	publishConfig(client, "sensor", hostname+"_docker_containers", dockerCountConfig)
+
+	for _, name := range settings.Containers {
+		id := getObjectId(name)
+
+		containerConfig := SwitchConfig{
+			Name:              hostname + " Container " + name,
+			Icon:              "mdi:package-variant-closed",
+			StateTopic:        topicPrefix + "/state/docker",
+			ValueTemplate:     "{{ 'start' if value_json.containers['" + id + "'] else 'stop' }}",
+			CommandTopic:      topicPrefix + "/command/container/" + id,
+			PayloadOn:         "start",
+			PayloadOff:        "stop",
+			UniqueID:          hostname + "_container_" + id,
+			AvailabilityTopic: getAvailabilityTopic(),
+			Device:            device,
+		}
+		publishConfig(client, "switch", hostname+"_container_"+id, containerConfig)
+	}
+}
+
+func init() {
+	registerSensor(sensorFuncs{
+		name:      "docker",
+		interval:  dockerInterval,
+		poll:      updateDocker,
+		discovery: publishDockerDiscoveryConfig,
+	})
+
+	registerCommand("docker", "container/", func(client mqtt.Client, command string, payload string) {
+		name, ok := findContainer(strings.TrimPrefix(command, "container/"))
+		if !ok || dockerPath == "" {
+			logWarnf("Unknown container %s", strings.TrimPrefix(command, "container/"))
+			return
+		}
+
+		if payload != "start" && payload != "stop" {
+			logWarnf("Incorrect container value")
+			return
+		}
+
+		// $ docker start homeassistant
+		if err := runCommand(dockerPath, payload, name); err != nil {
+			reportError(client, "controlling container "+name, err)
+			return
+		}
+
+		time.Sleep(1 * time.Second)
+
+		updateDocker(client)
+	})
+}
@@ -0,0 +1,18 @@
+package main
+
+import (
+	"log"
+
+	"github.com/bessarabov/mac2mqtt/internal/envconfig"
+)
+
+// Overrides config values with MAC2MQTT_* environment variables. The name of
+// a variable is the yaml key in upper case, keys of nested sections are joined
+// with "_": mqtt_password => MAC2MQTT_MQTT_PASSWORD, qos.command =>
+// MAC2MQTT_QOS_COMMAND. Lists are comma separated. Maps (commands,
+// retain_topics, features) and brokers can be set only in mac2mqtt.yaml
+func applyEnvOverrides(c *config) {
+	if err := envconfig.Apply("MAC2MQTT", c); err != nil {
+		log.Fatalf("Incorrect value of %v", err)
+	}
+}
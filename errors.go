@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+type errorState struct {
+	// what was being done, e.g. "updating battery"
+	Action string `json:"action"`
+	Error  string `json:"error"`
+	Time   string `json:"time"`
+}
+
+// Commands run by mac2mqtt fail from time to time, e.g. osascript right after
+// wake or during fast user switching. Such failures must not stop mac2mqtt, so
+// they are logged and the last one is published to PREFIX/state/error
+func reportError(client mqtt.Client, action string, err error) {
+	logErrorf("Error %s: %v", action, err)
+
+	diagnostics.errors.Add(1)
+
+	payload, marshalErr := json.Marshal(errorState{
+		Action: action,
+		Error:  err.Error(),
+		Time:   time.Now().UTC().Format(time.RFC3339),
+	})
+	if marshalErr != nil {
+		logErrorf("Error marshaling error state: %v", marshalErr)
+		return
+	}
+
+	publishState(client, "error", getTopicPrefix()+"/state/error", payload)
+}
+
+func publishErrorDiscoveryConfig(client mqtt.Client) {
+	topicPrefix := getTopicPrefix()
+
+	// Home Assistant states can't be longer than 255 characters
+	lastErrorConfig := SensorConfig{
+		Name:                hostname + " Last Error",
+		Icon:                "mdi:alert-circle-outline",
+		EntityCategory:      "diagnostic",
+		StateTopic:          topicPrefix + "/state/error",
+		UniqueID:            hostname + "_last_error",
+		ValueTemplate:       "{{ (value_json.action ~ ': ' ~ value_json.error)[:255] }}",
+		JsonAttributesTopic: topicPrefix + "/state/error",
+		AvailabilityTopic:   getAvailabilityTopic(),
+		Device:              getDevice(),
+	}
+	publishConfig(client, "sensor", hostname+"_last_error", lastErrorConfig)
+}
+
+func init() {
+	registerSensor(sensorFuncs{
+		name:      "errors",
+		discovery: publishErrorDiscoveryConfig,
+	})
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os/exec"
+	"regexp"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// Events are published once to PREFIX/event/NAME when they happen, they are
+// never retained and not sent again. In Home Assistant every event is a
+// device trigger for automations
+var eventTypes = []string{
+	"user_login",
+	"user_logout",
+	"lid_open",
+	"lid_close",
+	"power_button",
+}
+
+// How often the lid and the logged in users are checked
+const eventPollInterval = 2 * time.Second
+
+// Home Assistant MQTT Discovery config for device triggers
+type DeviceTriggerConfig struct {
+	AutomationType string `json:"automation_type"`
+	Topic          string `json:"topic"`
+	Type           string `json:"type"`
+	Subtype        string `json:"subtype"`
+	Device         Device `json:"device"`
+}
+
+type event struct {
+	Event string `json:"event"`
+	Time  string `json:"time"`
+	// user of user_login and user_logout
+	User string `json:"user,omitempty"`
+}
+
+func publishEvent(client mqtt.Client, e event) {
+	if isPaused() {
+		return
+	}
+
+	e.Time = time.Now().UTC().Format(time.RFC3339)
+	payload, err := json.Marshal(e)
+	if err != nil {
+		logErrorf("Error marshaling event: %v", err)
+		return
+	}
+
+	logInfof("Event %s %s", e.Event, e.User)
+
+	token := client.Publish(getTopicPrefix()+"/event/"+e.Event, settings.QoS.State, false, payload)
+	if !token.WaitTimeout(tokenTimeOut) {
+		diagnostics.publishErrors.Add(1)
+		logWarnf("Publish event %s timed out after %v", e.Event, tokenTimeOut)
+	} else if token.Error() != nil {
+		diagnostics.publishErrors.Add(1)
+		logErrorf("Error publishing event %s: %v", e.Event, token.Error())
+	} else {
+		diagnostics.published.Add(1)
+	}
+}
+
+// nil on Macs without a lid
+func getLidClosed() (*bool, error) {
+	output, err := getCommandOutput("/usr/sbin/ioreg", "-r", "-k", "AppleClamshellState", "-d", "1")
+	if err != nil {
+		return nil, err
+	}
+
+	// $ /usr/sbin/ioreg -r -k AppleClamshellState -d 1
+	// +-o IOPMrootDomain  <class IOPMrootDomain, id 0x100000100, registered, matched, active, busy 0 (0 ms), retain 33>
+	//     {
+	//       ...
+	//       "AppleClamshellState" = No
+	//       ...
+
+	m := regexp.MustCompile(`"AppleClamshellState" = (Yes|No)`).FindStringSubmatch(output)
+	if m == nil {
+		return nil, nil
+	}
+
+	closed := m[1] == "Yes"
+	return &closed, nil
+}
+
+func isUserIn(users []string, user string) bool {
+	for _, u := range users {
+		if u == user {
+			return true
+		}
+	}
+	return false
+}
+
+// Compares the lid and the logged in users with their previous state every
+// eventPollInterval until ctx is done
+func watchLidAndUsers(ctx context.Context, client mqtt.Client) {
+	var lastLidClosed *bool
+	var lastUsers []string
+
+	for {
+		lidClosed, err := getLidClosed()
+		if err != nil {
+			logErrorf("Error reading lid state: %v", err)
+		} else if lidClosed != nil {
+			if lastLidClosed != nil && *lastLidClosed != *lidClosed {
+				if *lidClosed {
+					publishEvent(client, event{Event: "lid_close"})
+				} else {
+					publishEvent(client, event{Event: "lid_open"})
+				}
+			}
+			lastLidClosed = lidClosed
+		}
+
+		users, err := getConsoleUsers()
+		if err != nil {
+			logErrorf("Error reading logged in users: %v", err)
+		} else {
+			if lastUsers != nil {
+				for _, user := range users {
+					if !isUserIn(lastUsers, user) {
+						publishEvent(client, event{Event: "user_login", User: user})
+					}
+				}
+				for _, user := range lastUsers {
+					if !isUserIn(users, user) {
+						publishEvent(client, event{Event: "user_logout", User: user})
+					}
+				}
+			}
+			lastUsers = users
+		}
+
+		if !sleepContext(ctx, eventPollInterval) {
+			return
+		}
+	}
+}
+
+// Follows the system log for presses of the power button (Touch ID button on
+// newer MacBooks). `log stream` is restarted if it exits, and killed when ctx
+// is done
+func watchPowerButton(ctx context.Context, client mqtt.Client) {
+	for {
+		cmd := exec.CommandContext(ctx, "/usr/bin/log", "stream", "--style", "compact",
+			"--predicate", `process == "powerd" AND eventMessage CONTAINS[c] "power button"`)
+
+		stdout, err := cmd.StdoutPipe()
+		if err == nil {
+			err = cmd.Start()
+		}
+		if err != nil {
+			logErrorf("Error starting log stream: %v", err)
+			if !sleepContext(ctx, 10*time.Second) {
+				return
+			}
+			continue
+		}
+
+		scanner := bufio.NewScanner(stdout)
+		// the first line is the "Filtering the log data using ..." header
+		scanner.Scan()
+		for scanner.Scan() {
+			publishEvent(client, event{Event: "power_button"})
+		}
+
+		err = cmd.Wait()
+		if ctx.Err() != nil {
+			return
+		}
+
+		logWarnf("log stream exited: %v. Restarting in 10 seconds...", err)
+		if !sleepContext(ctx, 10*time.Second) {
+			return
+		}
+	}
+}
+
+func watchEvents(ctx context.Context, client mqtt.Client) {
+	go watchPowerButton(ctx, client)
+	watchLidAndUsers(ctx, client)
+}
+
+func publishEventsDiscoveryConfig(client mqtt.Client) {
+	topicPrefix := getTopicPrefix()
+	device := getDevice()
+
+	for _, name := range eventTypes {
+		triggerConfig := DeviceTriggerConfig{
+			AutomationType: "trigger",
+			Topic:          topicPrefix + "/event/" + name,
+			Type:           name,
+			Subtype:        hostname,
+			Device:         device,
+		}
+		publishConfig(client, "device_automation", hostname+"_event_"+name, triggerConfig)
+	}
+}
+
+func init() {
+	// published by watchEvents when they happen
+	registerSensor(sensorFuncs{
+		name:      "events",
+		discovery: publishEventsDiscoveryConfig,
+	})
+}
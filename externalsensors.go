@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// Executable scripts in a directory that print a sensor value, like exec
+// inputs of telegraf. The file name without extension is the sensor name,
+// e.g. sensors.d/room_temperature.sh
+type externalSensorsConfig struct {
+	// sensors.d next to mac2mqtt.yaml by default
+	Dir string `yaml:"dir"`
+	// Seconds between runs of every script, 60 by default
+	Interval int `yaml:"interval"`
+	// Seconds between runs of single scripts, e.g. room_temperature: 300
+	Intervals map[string]int `yaml:"intervals"`
+	// Seconds a script can run before it is killed, 10 by default
+	Timeout int `yaml:"timeout"`
+}
+
+// Output of a script that prints JSON instead of a plain value:
+//
+//	{"value": 21.5, "unit": "°C", "device_class": "temperature", "attributes": {"room": "office"}}
+type externalSensorOutput struct {
+	Value       any            `json:"value"`
+	Unit        string         `json:"unit"`
+	DeviceClass string         `json:"device_class"`
+	Icon        string         `json:"icon"`
+	Attributes  map[string]any `json:"attributes"`
+}
+
+type externalSensor struct {
+	path    string
+	lastRun time.Time
+	// discovery config is published again when unit, class or icon change
+	output externalSensorOutput
+}
+
+var externalSensors = map[string]*externalSensor{}
+var externalSensorsMutex sync.Mutex
+
+// Executable files in the directory by sensor name
+func findExternalSensors(dir string) (map[string]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	paths := map[string]string{}
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || !info.Mode().IsRegular() || info.Mode().Perm()&0111 == 0 {
+			continue
+		}
+
+		name := getObjectId(strings.TrimSuffix(entry.Name(), filepath.Ext(entry.Name())))
+		if name != "" {
+			paths[name] = filepath.Join(dir, entry.Name())
+		}
+	}
+
+	return paths, nil
+}
+
+func runExternalSensor(path string) (externalSensorOutput, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(settings.ExternalSensors.Timeout)*time.Second)
+	defer cancel()
+
+	stdout, err := exec.CommandContext(ctx, path).Output()
+	if err != nil {
+		return externalSensorOutput{}, commandError(path, err)
+	}
+
+	output := strings.TrimSpace(string(stdout))
+
+	var o externalSensorOutput
+	if strings.HasPrefix(output, "{") {
+		if err := json.Unmarshal([]byte(output), &o); err != nil {
+			return externalSensorOutput{}, fmt.Errorf("%s: incorrect JSON: %v", path, err)
+		}
+		if o.Value == nil {
+			return externalSensorOutput{}, fmt.Errorf("%s: no value in JSON", path)
+		}
+		return o, nil
+	}
+
+	return externalSensorOutput{Value: output}, nil
+}
+
+func getExternalSensorInterval(name string) time.Duration {
+	if seconds, found := settings.ExternalSensors.Intervals[name]; found && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+
+	return time.Duration(settings.ExternalSensors.Interval) * time.Second
+}
+
+// Runs the scripts whose interval has passed. New scripts are picked up
+// without restarting mac2mqtt
+func updateExternalSensors(client mqtt.Client) {
+	dir := settings.ExternalSensors.Dir
+	if dir == "" {
+		return
+	}
+
+	paths, err := findExternalSensors(dir)
+	if os.IsNotExist(err) {
+		return
+	}
+	if err != nil {
+		reportError(client, "reading "+dir, err)
+		return
+	}
+
+	externalSensorsMutex.Lock()
+	defer externalSensorsMutex.Unlock()
+
+	// the sensor of a removed script is removed from Home Assistant too
+	for name, s := range externalSensors {
+		if _, found := paths[name]; !found {
+			publishExternalSensorConfig(configRemover{client}, name, s)
+			delete(externalSensors, name)
+		}
+	}
+
+	for name, path := range paths {
+		s, found := externalSensors[name]
+		if !found {
+			s = &externalSensor{path: path}
+			externalSensors[name] = s
+		}
+
+		if time.Since(s.lastRun) < getExternalSensorInterval(name) {
+			continue
+		}
+		s.lastRun = time.Now()
+
+		output, err := runExternalSensor(path)
+		if err != nil {
+			reportError(client, "running sensor "+name, err)
+			continue
+		}
+
+		if !found || output.Unit != s.output.Unit || output.DeviceClass != s.output.DeviceClass || output.Icon != s.output.Icon {
+			s.output = output
+			publishExternalSensorConfig(client, name, s)
+		}
+		s.output = output
+
+		topicPrefix := getTopicPrefix() + "/state/sensors/" + name
+
+		publishState(client, "sensors/"+name, topicPrefix, fmt.Sprint(output.Value))
+
+		if output.Attributes != nil {
+			attributes, err := json.Marshal(output.Attributes)
+			if err != nil {
+				logErrorf("Error marshaling sensor attributes: %v", err)
+				continue
+			}
+			publishState(client, "sensors/"+name+"/attributes", topicPrefix+"/attributes", attributes)
+		}
+	}
+}
+
+func publishExternalSensorConfig(client mqtt.Client, name string, s *externalSensor) {
+	topicPrefix := getTopicPrefix() + "/state/sensors/" + name
+
+	icon := s.output.Icon
+	if icon == "" && s.output.DeviceClass == "" {
+		icon = "mdi:script-text-play-outline"
+	}
+
+	externalSensorConfig := SensorConfig{
+		Name:                hostname + " " + name,
+		Icon:                icon,
+		StateTopic:          topicPrefix,
+		JsonAttributesTopic: topicPrefix + "/attributes",
+		UnitOfMeasurement:   s.output.Unit,
+		DeviceClass:         s.output.DeviceClass,
+		UniqueID:            hostname + "_sensors_" + name,
+		ExpireAfter:         expireAfter(getExternalSensorInterval(name)),
+		AvailabilityTopic:   getAvailabilityTopic(),
+		Device:              getDevice(),
+	}
+	publishConfig(client, "sensor", hostname+"_sensors_"+name, externalSensorConfig)
+}
+
+func publishExternalSensorsDiscoveryConfig(client mqtt.Client) {
+	externalSensorsMutex.Lock()
+	defer externalSensorsMutex.Unlock()
+
+	for name, s := range externalSensors {
+		publishExternalSensorConfig(client, name, s)
+	}
+}
+
+func init() {
+	registerSensor(sensorFuncs{
+		name:      "external_sensors",
+		interval:  externalSensorsInterval,
+		poll:      updateExternalSensors,
+		discovery: publishExternalSensorsDiscoveryConfig,
+	})
+}
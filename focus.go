@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+type focusConfig struct {
+	// Shortcuts.app shortcuts that turn Focus on and off. macOS has no command
+	// line tool for Focus, so the switch is published only when both are set
+	OnShortcut  string `yaml:"on_shortcut"`
+	OffShortcut string `yaml:"off_shortcut"`
+}
+
+func isFocusControlEnabled() bool {
+	return settings.Focus.OnShortcut != "" && settings.Focus.OffShortcut != ""
+}
+
+func getFocusStatus() (bool, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return false, err
+	}
+
+	// $ cat ~/Library/DoNotDisturb/DB/Assertions.json
+	// {"data":[{"storeAssertionRecords":[{"assertionDetails":{"assertionDetailsModeIdentifier":"com.apple.donotdisturb.mode.default",...},...}]}],...}
+	//
+	// storeAssertionRecords is present only while some Focus is turned on manually
+	// or by a shortcut. Reading the file requires Full Disk Access
+	content, err := os.ReadFile(filepath.Join(home, "Library/DoNotDisturb/DB/Assertions.json"))
+	if os.IsNotExist(err) {
+		// Focus was never used
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	var assertions struct {
+		Data []struct {
+			StoreAssertionRecords []json.RawMessage `json:"storeAssertionRecords"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(content, &assertions); err != nil {
+		return false, fmt.Errorf("parsing Assertions.json: %v", err)
+	}
+
+	for _, d := range assertions.Data {
+		if len(d.StoreAssertionRecords) > 0 {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// true - turn Focus on
+// false - turn Focus off
+func setFocus(b bool) error {
+	name := settings.Focus.OffShortcut
+	if b {
+		name = settings.Focus.OnShortcut
+	}
+
+	return commandShortcut(shortcutCommand{Name: name})
+}
+
+func updateFocus(client mqtt.Client) {
+	focus, err := getFocusStatus()
+	if err != nil {
+		reportError(client, "updating focus", err)
+		return
+	}
+
+	publishState(client, "focus", getTopicPrefix()+"/state/focus", strconv.FormatBool(focus))
+}
+
+func publishFocusDiscoveryConfig(client mqtt.Client) {
+	topicPrefix := getTopicPrefix()
+
+	if isFocusControlEnabled() {
+		focusSwitchConfig := SwitchConfig{
+			Name:              hostname + " Focus",
+			Icon:              "mdi:minus-circle",
+			StateTopic:        topicPrefix + "/state/focus",
+			CommandTopic:      topicPrefix + "/command/focus",
+			PayloadOn:         "true",
+			PayloadOff:        "false",
+			UniqueID:          hostname + "_focus",
+			AvailabilityTopic: getAvailabilityTopic(),
+			Device:            getDevice(),
+		}
+		publishConfig(client, "switch", hostname+"_focus", focusSwitchConfig)
+	}
+}
+
+func init() {
+	registerSensor(sensorFuncs{
+		name:     "focus",
+		interval: screenInterval,
+		poll: func(client mqtt.Client) {
+			if isFocusControlEnabled() {
+				updateFocus(client)
+			}
+		},
+		discovery: publishFocusDiscoveryConfig,
+	})
+
+	registerCommand("focus", "focus", func(client mqtt.Client, command string, payload string) {
+		if !isFocusControlEnabled() {
+			return
+		}
+
+		b, err := strconv.ParseBool(payload)
+		if err != nil {
+			logWarnf("Incorrect focus value")
+			return
+		}
+
+		if err := setFocus(b); err != nil {
+			reportError(client, "setting focus", err)
+		}
+
+		time.Sleep(1 * time.Second)
+
+		updateFocus(client)
+	})
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// Local HTTP API for debugging and for callers without MQTT, e.g. curl or
+// Shortcuts:
+//
+//	GET  /status         all current states, like PREFIX/state
+//	POST /command/NAME   the JSON body is sent as if it was sent to PREFIX/command/NAME
+//
+// Every request needs the configured token in "Authorization: Bearer TOKEN".
+// Browsers can't add that header to a request from another site without
+// asking first, and requests with an Origin header are refused anyway
+
+type httpConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// host:port, 127.0.0.1:8470 by default
+	Listen string `yaml:"listen"`
+	// Required when the API is enabled
+	Token string `yaml:"token"`
+}
+
+type httpStatus struct {
+	Connected bool                   `json:"connected"`
+	Paused    bool                   `json:"paused"`
+	States    map[string]interface{} `json:"states"`
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeHTTPError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, map[string]string{"error": err.Error()})
+}
+
+// With the default listen address only local host names are accepted, so
+// a page can't reach the API through DNS rebinding
+func isHTTPHostAllowed(r *http.Request) bool {
+	listenHost, _, err := net.SplitHostPort(settings.HTTP.Listen)
+	if err != nil {
+		return false
+	}
+	if ip := net.ParseIP(listenHost); ip == nil || !ip.IsLoopback() {
+		return true
+	}
+
+	host, _, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		host = r.Host
+	}
+
+	return host == "localhost" || net.ParseIP(strings.Trim(host, "[]")).IsLoopback()
+}
+
+// Refuses requests from web pages and requests without the token
+func checkHTTPRequest(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Origin") != "" {
+			writeHTTPError(w, http.StatusForbidden, errors.New("requests from web pages are not allowed"))
+			return
+		}
+
+		if !isHTTPHostAllowed(r) {
+			writeHTTPError(w, http.StatusForbidden, errors.New("unknown host "+r.Host))
+			return
+		}
+
+		token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !found || subtle.ConstantTimeCompare([]byte(token), []byte(settings.HTTP.Token)) != 1 {
+			writeHTTPError(w, http.StatusUnauthorized, errors.New("wrong or missing token"))
+			return
+		}
+
+		// forms can't send JSON, so a browser would need a preflight for it
+		if r.Method == http.MethodPost {
+			mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+			if mediaType != "application/json" {
+				writeHTTPError(w, http.StatusUnsupportedMediaType, errors.New("content type must be application/json"))
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func getHTTPHandler(client mqtt.Client) http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /status", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, httpStatus{
+			Connected: client.IsConnectionOpen(),
+			Paused:    isPaused(),
+			States:    getCombinedState(),
+		})
+	})
+
+	mux.HandleFunc("POST /command/", func(w http.ResponseWriter, r *http.Request) {
+		command := strings.TrimPrefix(r.URL.Path, "/command/")
+
+		if isPaused() {
+			writeHTTPError(w, http.StatusConflict, errors.New("bridge is paused from the menu bar"))
+			return
+		}
+
+		body, err := io.ReadAll(io.LimitReader(r.Body, 64*1024))
+		if err != nil {
+			writeHTTPError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		// "run" is sent as the payload run, any other JSON value as it is,
+		// e.g. 30 or {"title":"Hi"}
+		body = bytes.TrimSpace(body)
+		if !json.Valid(body) {
+			writeHTTPError(w, http.StatusBadRequest, errors.New("body is not JSON"))
+			return
+		}
+
+		payload := string(body)
+		var s string
+		if json.Unmarshal(body, &s) == nil {
+			payload = s
+		}
+
+		logDebugf("Received HTTP command:  [ %s ] [ %s ]", command, payload)
+
+		err = dispatchCommand(client, command, payload)
+		if errors.Is(err, errUnknownCommand) {
+			writeHTTPError(w, http.StatusNotFound, err)
+			return
+		} else if errors.Is(err, errCommandTooOften) {
+			writeHTTPError(w, http.StatusTooManyRequests, err)
+			return
+		} else if err != nil {
+			writeHTTPError(w, http.StatusForbidden, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	return checkHTTPRequest(mux)
+}
+
+// Serves the HTTP API until ctx is done
+func runHTTPServer(ctx context.Context, client mqtt.Client, wg *sync.WaitGroup) {
+	server := &http.Server{
+		Addr:              settings.HTTP.Listen,
+		Handler:           getHTTPHandler(client),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		logInfof("HTTP API is listening on %s", server.Addr)
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logErrorf("Error running HTTP API: %v", err)
+		}
+	}()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		<-ctx.Done()
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+}
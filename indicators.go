@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os/exec"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// Camera and microphone usage, the same thing that the green and orange dots
+// in the menu bar show
+type avUsage struct {
+	Camera         bool     `json:"camera"`
+	Microphone     bool     `json:"microphone"`
+	CameraApps     []string `json:"camera_apps"`
+	MicrophoneApps []string `json:"microphone_apps"`
+}
+
+var currentAVUsage = avUsage{CameraApps: []string{}, MicrophoneApps: []string{}}
+var currentAVUsageMutex sync.Mutex
+
+var attributionRegexp = regexp.MustCompile(`"(cam|mic):([^"]+)"`)
+
+// Control Center logs every change of the menu bar indicators:
+//
+// Active activity attributions changed to ["cam:us.zoom.xos", "mic:us.zoom.xos"]
+func parseAVUsage(line string) (avUsage, bool) {
+	_, list, found := strings.Cut(line, "Active activity attributions changed to")
+	if !found {
+		return avUsage{}, false
+	}
+
+	usage := avUsage{CameraApps: []string{}, MicrophoneApps: []string{}}
+
+	for _, m := range attributionRegexp.FindAllStringSubmatch(list, -1) {
+		if m[1] == "cam" {
+			usage.Camera = true
+			usage.CameraApps = append(usage.CameraApps, m[2])
+		} else {
+			usage.Microphone = true
+			usage.MicrophoneApps = append(usage.MicrophoneApps, m[2])
+		}
+	}
+
+	return usage, true
+}
+
+func getAVUsage() avUsage {
+	currentAVUsageMutex.Lock()
+	defer currentAVUsageMutex.Unlock()
+
+	return currentAVUsage
+}
+
+// Follows the system log and publishes camera and microphone state as soon as
+// it changes. `log stream` is restarted if it exits, and killed when ctx is done
+func watchAVUsage(ctx context.Context, client mqtt.Client) {
+	for {
+		cmd := exec.CommandContext(ctx, "/usr/bin/log", "stream", "--style", "compact",
+			"--predicate", `subsystem == "com.apple.controlcenter" AND eventMessage CONTAINS "Active activity attributions changed to"`)
+
+		stdout, err := cmd.StdoutPipe()
+		if err == nil {
+			err = cmd.Start()
+		}
+		if err != nil {
+			logErrorf("Error starting log stream: %v", err)
+			if !sleepContext(ctx, 10*time.Second) {
+				return
+			}
+			continue
+		}
+
+		scanner := bufio.NewScanner(stdout)
+		for scanner.Scan() {
+			usage, ok := parseAVUsage(scanner.Text())
+			if !ok {
+				continue
+			}
+
+			currentAVUsageMutex.Lock()
+			currentAVUsage = usage
+			currentAVUsageMutex.Unlock()
+
+			updateAVUsage(client)
+		}
+
+		err = cmd.Wait()
+		if ctx.Err() != nil {
+			return
+		}
+
+		logWarnf("log stream exited: %v. Restarting in 10 seconds...", err)
+		if !sleepContext(ctx, 10*time.Second) {
+			return
+		}
+	}
+}
+
+func updateAVUsage(client mqtt.Client) {
+	payload, err := json.Marshal(getAVUsage())
+	if err != nil {
+		logErrorf("Error marshaling camera and microphone usage: %v", err)
+		return
+	}
+
+	publishState(client, "camera and microphone usage", getTopicPrefix()+"/state/av_usage", payload)
+}
+
+func publishIndicatorsDiscoveryConfig(client mqtt.Client) {
+	topicPrefix := getTopicPrefix()
+	device := getDevice()
+
+	cameraConfig := BinarySensorConfig{
+		Name:                hostname + " Camera In Use",
+		Icon:                "mdi:webcam",
+		StateTopic:          topicPrefix + "/state/av_usage",
+		ValueTemplate:       "{{ 'ON' if value_json.camera else 'OFF' }}",
+		JsonAttributesTopic: topicPrefix + "/state/av_usage",
+		UniqueID:            hostname + "_camera_in_use",
+		AvailabilityTopic:   getAvailabilityTopic(),
+		Device:              device,
+	}
+	publishConfig(client, "binary_sensor", hostname+"_camera_in_use", cameraConfig)
+
+	microphoneConfig := BinarySensorConfig{
+		Name:                hostname + " Microphone In Use",
+		Icon:                "mdi:microphone",
+		StateTopic:          topicPrefix + "/state/av_usage",
+		ValueTemplate:       "{{ 'ON' if value_json.microphone else 'OFF' }}",
+		JsonAttributesTopic: topicPrefix + "/state/av_usage",
+		UniqueID:            hostname + "_microphone_in_use",
+		AvailabilityTopic:   getAvailabilityTopic(),
+		Device:              device,
+	}
+	publishConfig(client, "binary_sensor", hostname+"_microphone_in_use", microphoneConfig)
+}
+
+func init() {
+	// published by watchAVUsage on change
+	registerSensor(sensorFuncs{
+		name:      "av_usage",
+		poll:      updateAVUsage,
+		discovery: publishIndicatorsDiscoveryConfig,
+	})
+}
@@ -0,0 +1,57 @@
+// Package df parses the output of df.
+package df
+
+import (
+	"strconv"
+	"strings"
+)
+
+type Filesystem struct {
+	Device     string
+	MountPoint string
+	// 1024-byte blocks
+	Used      float64
+	Available float64
+}
+
+// Parses the output of `df -k -P`. Only filesystems of devices (/dev/...)
+// are returned, e.g. not devfs or map auto_home
+func Parse(output string) []Filesystem {
+	// $ /bin/df -k -P -l
+	// Filesystem     1024-blocks      Used Available Capacity  Mounted on
+	// /dev/disk3s1s1   482797652  10155404 264011556     4%    /
+	// /dev/disk5s1     976284640 512345678 463938962    53%    /Volumes/Backup Disk
+
+	var filesystems []Filesystem
+
+	lines := strings.Split(output, "\n")
+	if len(lines) > 0 {
+		lines = lines[1:]
+	}
+
+	for _, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) < 6 || !strings.HasPrefix(fields[0], "/dev/") {
+			continue
+		}
+
+		used, err := strconv.ParseFloat(fields[2], 64)
+		if err != nil {
+			continue
+		}
+		available, err := strconv.ParseFloat(fields[3], 64)
+		if err != nil {
+			continue
+		}
+
+		filesystems = append(filesystems, Filesystem{
+			Device: fields[0],
+			// mount point is the only column that can contain spaces
+			MountPoint: strings.Join(fields[5:], " "),
+			Used:       used,
+			Available:  available,
+		})
+	}
+
+	return filesystems
+}
@@ -0,0 +1,52 @@
+package df
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		want   []Filesystem
+	}{
+		{
+			name:   "empty",
+			output: "",
+			want:   nil,
+		},
+		{
+			name:   "header only",
+			output: "Filesystem     1024-blocks      Used Available Capacity  Mounted on",
+			want:   nil,
+		},
+		{
+			name: "volumes",
+			output: `Filesystem     1024-blocks      Used Available Capacity  Mounted on
+/dev/disk3s1s1   482797652  10155404 264011556     4%    /
+devfs                  205       205         0   100%    /dev
+/dev/disk5s1     976284640 512345678 463938962    53%    /Volumes/Backup Disk
+map auto_home            0         0         0   100%    /System/Volumes/Data/home`,
+			want: []Filesystem{
+				{Device: "/dev/disk3s1s1", MountPoint: "/", Used: 10155404, Available: 264011556},
+				{Device: "/dev/disk5s1", MountPoint: "/Volumes/Backup Disk", Used: 512345678, Available: 463938962},
+			},
+		},
+		{
+			name: "not a number",
+			output: `Filesystem     1024-blocks      Used Available Capacity  Mounted on
+/dev/disk4s1             -         -         -     -     /Volumes/Broken`,
+			want: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Parse(tt.output)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Parse() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
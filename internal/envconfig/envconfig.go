@@ -0,0 +1,102 @@
+// Package envconfig overrides fields of a config struct with environment
+// variables named after their yaml keys.
+package envconfig
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Sets the fields of the struct v points to from environment variables. The
+// name of a variable is the prefix and the yaml key in upper case, keys of
+// nested structs are joined with "_": with prefix MAC2MQTT mqtt_password is
+// MAC2MQTT_MQTT_PASSWORD and qos.command is MAC2MQTT_QOS_COMMAND. Lists of
+// strings are comma separated. Maps and other lists are skipped
+func Apply(prefix string, v any) error {
+	return applyToStruct(prefix, reflect.ValueOf(v).Elem())
+}
+
+func applyToStruct(prefix string, v reflect.Value) error {
+	t := v.Type()
+
+	for i := 0; i < t.NumField(); i++ {
+		key, _, _ := strings.Cut(t.Field(i).Tag.Get("yaml"), ",")
+		if key == "" || key == "-" {
+			continue
+		}
+
+		name := prefix + "_" + strings.ToUpper(key)
+		field := v.Field(i)
+
+		if field.Kind() == reflect.Struct {
+			if err := applyToStruct(name, field); err != nil {
+				return err
+			}
+			continue
+		}
+
+		value, found := os.LookupEnv(name)
+		if !found {
+			continue
+		}
+
+		if err := setFromString(field, value); err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+	}
+
+	return nil
+}
+
+func setFromString(field reflect.Value, value string) error {
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+
+	case reflect.Int:
+		i, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(i))
+
+	case reflect.Uint8:
+		i, err := strconv.ParseUint(value, 10, 8)
+		if err != nil {
+			return err
+		}
+		field.SetUint(i)
+
+	case reflect.Pointer:
+		v := reflect.New(field.Type().Elem())
+		if err := setFromString(v.Elem(), value); err != nil {
+			return err
+		}
+		field.Set(v)
+
+	case reflect.Slice:
+		if field.Type().Elem().Kind() != reflect.String {
+			return nil
+		}
+
+		list := []string{}
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		field.Set(reflect.ValueOf(list))
+	}
+
+	return nil
+}
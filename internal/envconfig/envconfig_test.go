@@ -0,0 +1,113 @@
+package envconfig
+
+import (
+	"reflect"
+	"testing"
+)
+
+type qos struct {
+	Command uint8 `yaml:"command"`
+	State   uint8 `yaml:"state"`
+}
+
+type config struct {
+	Password  string            `yaml:"mqtt_password"`
+	Port      int               `yaml:"port"`
+	Retain    bool              `yaml:"retain"`
+	Timeout   *int              `yaml:"timeout"`
+	Include   []string          `yaml:"include,omitempty"`
+	Ports     []int             `yaml:"ports"`
+	Commands  map[string]string `yaml:"commands"`
+	QoS       qos               `yaml:"qos"`
+	NotInYaml string
+	Skipped   string `yaml:"-"`
+}
+
+func intPointer(i int) *int {
+	return &i
+}
+
+func TestApply(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		want    config
+		wantErr bool
+	}{
+		{
+			name: "nothing set",
+			want: config{Password: "from yaml", Port: 1883},
+		},
+		{
+			name: "values",
+			env: map[string]string{
+				"TEST_MQTT_PASSWORD": "secret",
+				"TEST_PORT":          "8883",
+				"TEST_RETAIN":        "true",
+				"TEST_TIMEOUT":       "30",
+			},
+			want: config{Password: "secret", Port: 8883, Retain: true, Timeout: intPointer(30)},
+		},
+		{
+			name: "empty string",
+			env:  map[string]string{"TEST_MQTT_PASSWORD": ""},
+			want: config{Port: 1883},
+		},
+		{
+			name: "list",
+			env:  map[string]string{"TEST_INCLUDE": "/, /Volumes/Backup Disk,,"},
+			want: config{Password: "from yaml", Port: 1883, Include: []string{"/", "/Volumes/Backup Disk"}},
+		},
+		{
+			name: "nested",
+			env:  map[string]string{"TEST_QOS_COMMAND": "2"},
+			want: config{Password: "from yaml", Port: 1883, QoS: qos{Command: 2}},
+		},
+		{
+			name: "not settable",
+			env: map[string]string{
+				"TEST_PORTS":     "1,2",
+				"TEST_COMMANDS":  "a",
+				"TEST_NOTINYAML": "a",
+				"TEST_SKIPPED":   "a",
+				"TEST_-":         "a",
+			},
+			want: config{Password: "from yaml", Port: 1883},
+		},
+		{
+			name:    "not a number",
+			env:     map[string]string{"TEST_PORT": "mqtt"},
+			wantErr: true,
+		},
+		{
+			name:    "not a bool",
+			env:     map[string]string{"TEST_RETAIN": "yes please"},
+			wantErr: true,
+		},
+		{
+			name:    "out of range",
+			env:     map[string]string{"TEST_QOS_STATE": "300"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+
+			got := config{Password: "from yaml", Port: 1883}
+			err := Apply("TEST", &got)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Apply() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Apply() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
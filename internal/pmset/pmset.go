@@ -0,0 +1,86 @@
+// Package pmset parses the output of pmset.
+package pmset
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+type Battery struct {
+	Percent int
+	// Drawing from the power adapter
+	ACPower bool
+	// "charging", "discharging", "full" or "not charging"
+	State string
+	// Minutes until empty (or full when charging), nil while macOS is
+	// still calculating
+	TimeRemaining *int
+}
+
+var (
+	percentRegexp       = regexp.MustCompile(`(\d+)%`)
+	stateRegexp         = regexp.MustCompile(`\d+%; ([^;]+);`)
+	timeRemainingRegexp = regexp.MustCompile(`(\d+):(\d+) remaining`)
+)
+
+// Desktop Macs have no InternalBattery line in `pmset -g batt`, only UPSes
+// connected over USB are listed there
+func HasBattery(output string) bool {
+	return strings.Contains(output, "-InternalBattery-")
+}
+
+// Parses the output of `pmset -g batt`:
+//
+//	Now drawing from 'Battery Power'
+//	 -InternalBattery-0 (id=4653155)        87%; discharging; 5:12 remaining present: true
+//
+// Right after plugging or unplugging the adapter there is "(no estimate)"
+// instead of the time
+func ParseBatt(output string) (Battery, error) {
+	m := percentRegexp.FindStringSubmatch(output)
+	if m == nil {
+		return Battery{}, errors.New("can't find battery percent in the output of pmset -g batt")
+	}
+
+	b := Battery{
+		ACPower: strings.Contains(output, "AC Power"),
+		State:   parseState(output),
+	}
+	b.Percent, _ = strconv.Atoi(m[1])
+
+	if m := timeRemainingRegexp.FindStringSubmatch(output); m != nil {
+		hours, _ := strconv.Atoi(m[1])
+		minutes, _ := strconv.Atoi(m[2])
+		remaining := hours*60 + minutes
+		b.TimeRemaining = &remaining
+	}
+
+	return b, nil
+}
+
+// Status after the percent is one of:
+//
+//	87%; charging; 1:05 remaining
+//	99%; finishing charge; 0:05 remaining
+//	100%; charged; 0:00 remaining
+//	80%; AC attached; not charging present: true
+//	87%; discharging; 5:12 remaining
+func parseState(output string) string {
+	m := stateRegexp.FindStringSubmatch(output)
+	if m == nil {
+		return "not charging"
+	}
+
+	switch m[1] {
+	case "charging", "finishing charge":
+		return "charging"
+	case "discharging":
+		return "discharging"
+	case "charged":
+		return "full"
+	default:
+		return "not charging"
+	}
+}
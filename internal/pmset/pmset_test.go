@@ -0,0 +1,107 @@
+package pmset
+
+import (
+	"reflect"
+	"testing"
+)
+
+func minutes(m int) *int {
+	return &m
+}
+
+func TestHasBattery(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		want   bool
+	}{
+		{
+			name: "MacBook",
+			output: `Now drawing from 'Battery Power'
+ -InternalBattery-0 (id=4653155)	87%; discharging; 5:12 remaining present: true`,
+			want: true,
+		},
+		{
+			name:   "Mac mini",
+			output: `Now drawing from 'AC Power'`,
+			want:   false,
+		},
+		{
+			name: "Mac mini with UPS",
+			output: `Now drawing from 'AC Power'
+ -Back-UPS ES 700 (id=12345)	100%; charging present: true`,
+			want: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := HasBattery(tt.output); got != tt.want {
+				t.Errorf("HasBattery() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseBatt(t *testing.T) {
+	tests := []struct {
+		name    string
+		output  string
+		want    Battery
+		wantErr bool
+	}{
+		{
+			name: "discharging",
+			output: `Now drawing from 'Battery Power'
+ -InternalBattery-0 (id=4653155)	87%; discharging; 5:12 remaining present: true`,
+			want: Battery{Percent: 87, State: "discharging", TimeRemaining: minutes(312)},
+		},
+		{
+			name: "charging",
+			output: `Now drawing from 'AC Power'
+ -InternalBattery-0 (id=4653155)	42%; charging; 1:05 remaining present: true`,
+			want: Battery{Percent: 42, ACPower: true, State: "charging", TimeRemaining: minutes(65)},
+		},
+		{
+			name: "finishing charge",
+			output: `Now drawing from 'AC Power'
+ -InternalBattery-0 (id=4653155)	99%; finishing charge; 0:05 remaining present: true`,
+			want: Battery{Percent: 99, ACPower: true, State: "charging", TimeRemaining: minutes(5)},
+		},
+		{
+			name: "charged",
+			output: `Now drawing from 'AC Power'
+ -InternalBattery-0 (id=4653155)	100%; charged; 0:00 remaining present: true`,
+			want: Battery{Percent: 100, ACPower: true, State: "full", TimeRemaining: minutes(0)},
+		},
+		{
+			name: "not charging",
+			output: `Now drawing from 'AC Power'
+ -InternalBattery-0 (id=4653155)	80%; AC attached; not charging present: true`,
+			want: Battery{Percent: 80, ACPower: true, State: "not charging"},
+		},
+		{
+			name: "no estimate",
+			output: `Now drawing from 'Battery Power'
+ -InternalBattery-0 (id=4653155)	64%; discharging; (no estimate) present: true`,
+			want: Battery{Percent: 64, State: "discharging"},
+		},
+		{
+			name:    "no battery",
+			output:  `Now drawing from 'AC Power'`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseBatt(tt.output)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseBatt() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseBatt() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
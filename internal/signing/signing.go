@@ -0,0 +1,93 @@
+// Package signing signs commands with HMAC-SHA256 and checks the
+// signatures. A signed command is
+//
+//	{"payload":"shutdown","time":1712736000,"signature":"HEX"}
+//
+// where signature is HMAC-SHA256 of "COMMAND\nPAYLOAD\nTIME" with the
+// shared secret, e.g. "shutdown\nshutdown\n1712736000".
+package signing
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type Command struct {
+	Payload   string `json:"payload"`
+	Time      int64  `json:"time"`
+	Signature string `json:"signature"`
+}
+
+func Signature(secret string, command string, payload string, t int64) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(command + "\n" + payload + "\n" + strconv.FormatInt(t, 10)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Signed command for the payload at Unix time t
+func Sign(secret string, command string, payload string, t int64) ([]byte, error) {
+	return json.Marshal(Command{
+		Payload:   payload,
+		Time:      t,
+		Signature: Signature(secret, command, payload, t),
+	})
+}
+
+// Checks signed commands. Signatures that were already used are remembered
+// until they are too old, so a captured message can't be sent again
+type Verifier struct {
+	secret string
+	// seconds a signature is valid
+	maxAge int64
+
+	mutex sync.Mutex
+	used  map[string]int64
+}
+
+func NewVerifier(secret string, maxAge int64) *Verifier {
+	return &Verifier{
+		secret: secret,
+		maxAge: maxAge,
+		used:   map[string]int64{},
+	}
+}
+
+// Returns the payload inside a signed command, now is the current Unix time
+func (v *Verifier) Verify(command string, message string, now int64) (string, error) {
+	var c Command
+	if err := json.Unmarshal([]byte(message), &c); err != nil {
+		return "", errors.New("command must be signed")
+	}
+
+	if c.Time < now-v.maxAge || c.Time > now+v.maxAge {
+		return "", fmt.Errorf("signature is older than %d seconds or the clocks differ", v.maxAge)
+	}
+
+	expected := Signature(v.secret, command, c.Payload, c.Time)
+	if !hmac.Equal([]byte(expected), []byte(strings.ToLower(c.Signature))) {
+		return "", errors.New("wrong signature")
+	}
+
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	for signature, t := range v.used {
+		if t < now-v.maxAge {
+			delete(v.used, signature)
+		}
+	}
+
+	if _, used := v.used[expected]; used {
+		return "", errors.New("signature was already used")
+	}
+	v.used[expected] = c.Time
+
+	return c.Payload, nil
+}
@@ -0,0 +1,136 @@
+package signing
+
+import (
+	"strings"
+	"testing"
+)
+
+const secret = "test secret"
+
+func signed(t *testing.T, command string, payload string, at int64) string {
+	t.Helper()
+
+	message, err := Sign(secret, command, payload, at)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(message)
+}
+
+func TestVerify(t *testing.T) {
+	const now = 1712736000
+
+	tests := []struct {
+		name    string
+		command string
+		message string
+		want    string
+		wantErr string
+	}{
+		{
+			name:    "signed",
+			command: "shutdown",
+			message: signed(t, "shutdown", "shutdown", now),
+			want:    "shutdown",
+		},
+		{
+			name:    "within max age",
+			command: "run/backup",
+			message: signed(t, "run/backup", "run", now-59),
+			want:    "run",
+		},
+		{
+			name:    "clock of the sender ahead",
+			command: "sleep",
+			message: signed(t, "sleep", "sleep", now+30),
+			want:    "sleep",
+		},
+		{
+			name:    "upper case signature",
+			command: "restart",
+			message: `{"payload":"restart","time":1712736000,"signature":"` + strings.ToUpper(Signature(secret, "restart", "restart", now)) + `"}`,
+			want:    "restart",
+		},
+		{
+			name:    "not signed",
+			command: "shutdown",
+			message: "shutdown",
+			wantErr: "command must be signed",
+		},
+		{
+			name:    "too old",
+			command: "shutdown",
+			message: signed(t, "shutdown", "shutdown", now-61),
+			wantErr: "older than 60 seconds",
+		},
+		{
+			name:    "other command",
+			command: "restart",
+			message: signed(t, "shutdown", "shutdown", now),
+			wantErr: "wrong signature",
+		},
+		{
+			name:    "changed payload",
+			command: "run/backup",
+			message: strings.Replace(signed(t, "run/backup", "run", now), `"run"`, `"stop"`, 1),
+			wantErr: "wrong signature",
+		},
+		{
+			name:    "other secret",
+			command: "shutdown",
+			message: `{"payload":"shutdown","time":1712736000,"signature":"` + Signature("other", "shutdown", "shutdown", now) + `"}`,
+			wantErr: "wrong signature",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := NewVerifier(secret, 60)
+
+			got, err := v.Verify(tt.command, tt.message, now)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Verify() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Verify() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Verify() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerifyReplay(t *testing.T) {
+	const now = 1712736000
+
+	v := NewVerifier(secret, 60)
+	message := signed(t, "shutdown", "shutdown", now)
+
+	if _, err := v.Verify("shutdown", message, now); err != nil {
+		t.Fatalf("first Verify() error = %v", err)
+	}
+
+	if _, err := v.Verify("shutdown", message, now+1); err == nil || !strings.Contains(err.Error(), "already used") {
+		t.Fatalf("second Verify() error = %v, want already used", err)
+	}
+
+	// a new signature of the same command is fine
+	if _, err := v.Verify("shutdown", signed(t, "shutdown", "shutdown", now+1), now+1); err != nil {
+		t.Fatalf("Verify() of a new signature error = %v", err)
+	}
+
+	// once the signature is too old it is refused for its age and forgotten
+	if _, err := v.Verify("shutdown", message, now+120); err == nil || !strings.Contains(err.Error(), "older than") {
+		t.Fatalf("Verify() after max age error = %v, want older than", err)
+	}
+	if _, err := v.Verify("shutdown", signed(t, "shutdown", "shutdown", now+120), now+120); err != nil {
+		t.Fatalf("Verify() after max age error = %v", err)
+	}
+	if len(v.used) != 1 {
+		t.Errorf("%d used signatures are kept, want 1", len(v.used))
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"math"
+	"strconv"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// Path to the `mac-brightnessctl` tool (https://github.com/rakalex/mac-brightnessctl),
+// empty if it is not installed and keyboard backlight control is disabled
+var keyboardBacklightPath string
+
+// from 0 to 100
+func getKeyboardBacklight() (int, error) {
+	output, err := getCommandOutput(keyboardBacklightPath)
+	if err != nil {
+		return 0, err
+	}
+
+	// $ mac-brightnessctl
+	// 0.562500
+
+	f, err := strconv.ParseFloat(output, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	return int(math.Round(f * 100)), nil
+}
+
+// from 0 to 100
+func setKeyboardBacklight(i int) error {
+	return runCommand(keyboardBacklightPath, strconv.FormatFloat(float64(i)/100, 'f', 2, 64))
+}
+
+func updateKeyboardBacklight(client mqtt.Client) {
+	backlight, err := getKeyboardBacklight()
+	if err != nil {
+		reportError(client, "updating keyboard backlight", err)
+		return
+	}
+
+	publishState(client, "keyboard backlight", getTopicPrefix()+"/state/keyboard_backlight", strconv.Itoa(backlight))
+}
+
+func publishKeyboardDiscoveryConfig(client mqtt.Client) {
+	topicPrefix := getTopicPrefix()
+
+	if keyboardBacklightPath != "" {
+		keyboardBacklightConfig := NumberConfig{
+			Name:              hostname + " Keyboard Backlight",
+			Icon:              "mdi:keyboard",
+			CommandTopic:      topicPrefix + "/command/keyboard_backlight",
+			StateTopic:        topicPrefix + "/state/keyboard_backlight",
+			UniqueID:          hostname + "_keyboard_backlight",
+			Min:               0,
+			Max:               100,
+			AvailabilityTopic: getAvailabilityTopic(),
+			Device:            getDevice(),
+		}
+		publishConfig(client, "number", hostname+"_keyboard_backlight", keyboardBacklightConfig)
+	}
+}
+
+func init() {
+	registerSensor(sensorFuncs{
+		name:     "keyboard_backlight",
+		interval: screenInterval,
+		poll: func(client mqtt.Client) {
+			if keyboardBacklightPath != "" {
+				updateKeyboardBacklight(client)
+			}
+		},
+		discovery: publishKeyboardDiscoveryConfig,
+	})
+
+	registerCommand("keyboard_backlight", "keyboard_backlight", func(client mqtt.Client, command string, payload string) {
+		if keyboardBacklightPath == "" {
+			return
+		}
+
+		i, err := strconv.Atoi(payload)
+		if err != nil || i < 0 || i > 100 {
+			logWarnf("Incorrect keyboard backlight value")
+			return
+		}
+
+		if err := setKeyboardBacklight(i); err != nil {
+			reportError(client, "setting keyboard backlight", err)
+		}
+
+		time.Sleep(1 * time.Second)
+
+		updateKeyboardBacklight(client)
+	})
+}
@@ -0,0 +1,12 @@
+package main
+
+// Reads a password stored in the login Keychain as a generic password with
+// the given service name:
+//
+//	$ security add-generic-password -s mac2mqtt -a mqtt_user -w
+//
+// macOS asks once to allow mac2mqtt to use the item, "Always Allow" makes it
+// work without a prompt from then on
+func getKeychainPassword(service string) (string, error) {
+	return getCommandOutput("/usr/bin/security", "find-generic-password", "-s", service, "-w")
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// Modifiers of key combinations in the keys section of mac2mqtt.yaml
+var keyModifiers = map[string]string{
+	"cmd":     "command down",
+	"command": "command down",
+	"ctrl":    "control down",
+	"control": "control down",
+	"alt":     "option down",
+	"opt":     "option down",
+	"option":  "option down",
+	"shift":   "shift down",
+	"fn":      "function down",
+}
+
+// Virtual key codes from HIToolbox/Events.h of keys that have no character
+var keyCodes = map[string]int{
+	"return": 36, "enter": 76, "tab": 48, "space": 49, "delete": 51, "esc": 53, "escape": 53,
+	"left": 123, "right": 124, "down": 125, "up": 126,
+	"home": 115, "end": 119, "pageup": 116, "pagedown": 121, "forwarddelete": 117,
+	"f1": 122, "f2": 120, "f3": 99, "f4": 118, "f5": 96, "f6": 97,
+	"f7": 98, "f8": 100, "f9": 101, "f10": 109, "f11": 103, "f12": 111,
+}
+
+// AppleScript that presses a combination like "cmd+shift+4" or "ctrl+up"
+func getKeyComboScript(combo string) (string, error) {
+	parts := strings.Split(strings.ToLower(strings.ReplaceAll(combo, " ", "")), "+")
+	key := parts[len(parts)-1]
+
+	var modifiers []string
+	for _, m := range parts[:len(parts)-1] {
+		modifier, ok := keyModifiers[m]
+		if !ok {
+			return "", fmt.Errorf("unknown modifier %q", m)
+		}
+		modifiers = append(modifiers, modifier)
+	}
+
+	var press string
+	if code, ok := keyCodes[key]; ok {
+		press = "key code " + strconv.Itoa(code)
+	} else if len([]rune(key)) == 1 {
+		press = "keystroke " + strconv.Quote(key)
+	} else {
+		return "", fmt.Errorf("unknown key %q", key)
+	}
+
+	if len(modifiers) > 0 {
+		press += " using {" + strings.Join(modifiers, ", ") + "}"
+	}
+
+	return `tell application "System Events" to ` + press, nil
+}
+
+// Fails on key combinations that can't be pressed, called when the config is read
+func checkKeyCombos(keys map[string]string) {
+	for name, combo := range keys {
+		if getObjectId(name) != name {
+			log.Fatalf("Key name %q in mac2mqtt.yaml can contain only a-z, 0-9 and _", name)
+		}
+		if _, err := getKeyComboScript(combo); err != nil {
+			log.Fatalf("Incorrect key combination %q of %s in mac2mqtt.yaml: %v", combo, name, err)
+		}
+	}
+}
+
+// Sending keystrokes requires mac2mqtt to be allowed in
+// Privacy & Security > Accessibility. They go to the frontmost app
+func commandKey(name string) error {
+	combo := settings.Keys[name]
+
+	if isDryRun("pressing " + name + ": " + combo) {
+		return nil
+	}
+
+	script, err := getKeyComboScript(combo)
+	if err != nil {
+		return err
+	}
+
+	return runCommand("/usr/bin/osascript", "-e", script)
+}
+
+func publishKeysDiscoveryConfig(client mqtt.Client) {
+	topicPrefix := getTopicPrefix()
+	device := getDevice()
+
+	for name := range settings.Keys {
+		keyButtonConfig := ButtonConfig{
+			Name:              hostname + " " + name,
+			Icon:              "mdi:keyboard-outline",
+			CommandTopic:      topicPrefix + "/command/key/" + name,
+			PayloadPress:      "press",
+			UniqueID:          hostname + "_key_" + name,
+			AvailabilityTopic: getAvailabilityTopic(),
+			Device:            device,
+		}
+		publishConfig(client, "button", hostname+"_key_"+name, keyButtonConfig)
+	}
+}
+
+func init() {
+	registerSensor(sensorFuncs{
+		name:      "keys",
+		discovery: publishKeysDiscoveryConfig,
+	})
+
+	registerCommand("keys", "key/", func(client mqtt.Client, command string, payload string) {
+		name := strings.TrimPrefix(command, "key/")
+		if _, ok := settings.Keys[name]; !ok || payload != "press" {
+			logWarnf("Unknown key combination %s", name)
+			return
+		}
+
+		if err := commandKey(name); err != nil {
+			reportError(client, "pressing keys", err)
+		}
+	})
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+)
+
+type logConfig struct {
+	// debug, info, warn or error
+	Level string `yaml:"level"`
+	// text or json
+	Format string `yaml:"format"`
+	// Written to stderr when empty
+	File string `yaml:"file"`
+	// Size in megabytes after which the file is rotated
+	MaxSize int `yaml:"max_size"`
+	// Number of rotated files to keep, mac2mqtt.log.1 is the newest
+	MaxBackups int `yaml:"max_backups"`
+}
+
+func parseLogLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(s)); err != nil {
+		return level, fmt.Errorf("unknown log level %q, must be debug, info, warn or error", s)
+	}
+	return level, nil
+}
+
+// Sends log and slog output to the configured file and format. Text output
+// keeps the format of the log package with the level after the time:
+// 2021/04/12 10:37:29 INFO Connected to MQTT
+func setupLogging(c logConfig) error {
+	level, err := parseLogLevel(c.Level)
+	if err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stderr
+	if c.File != "" {
+		f, err := openRotatingFile(c.File, c.MaxSize, c.MaxBackups)
+		if err != nil {
+			return err
+		}
+		w = f
+	}
+
+	switch c.Format {
+	case "text":
+		log.SetOutput(w)
+		slog.SetLogLoggerLevel(level)
+	case "json":
+		slog.SetDefault(slog.New(slog.NewJSONHandler(w, &slog.HandlerOptions{Level: level})))
+	default:
+		return fmt.Errorf("unknown log format %q, must be text or json", c.Format)
+	}
+
+	return nil
+}
+
+func logf(level slog.Level, format string, args ...interface{}) {
+	ctx := context.Background()
+	if !slog.Default().Enabled(ctx, level) {
+		return
+	}
+
+	slog.Log(ctx, level, strings.TrimSuffix(fmt.Sprintf(format, args...), "\n"))
+}
+
+func logDebugf(format string, args ...interface{}) {
+	logf(slog.LevelDebug, format, args...)
+}
+
+func logInfof(format string, args ...interface{}) {
+	logf(slog.LevelInfo, format, args...)
+}
+
+func logWarnf(format string, args ...interface{}) {
+	logf(slog.LevelWarn, format, args...)
+}
+
+func logErrorf(format string, args ...interface{}) {
+	logf(slog.LevelError, format, args...)
+}
+
+// Log file that is renamed to PATH.1 when it grows over maxSize megabytes,
+// PATH.1 to PATH.2 and so on up to maxBackups
+type rotatingFile struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+
+	mutex sync.Mutex
+	file  *os.File
+	size  int64
+}
+
+func openRotatingFile(path string, maxSize int, maxBackups int) (*rotatingFile, error) {
+	r := &rotatingFile{
+		path:       path,
+		maxSize:    int64(maxSize) * 1024 * 1024,
+		maxBackups: maxBackups,
+	}
+
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+func (r *rotatingFile) open() error {
+	f, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	r.file = f
+	r.size = info.Size()
+
+	return nil
+}
+
+func (r *rotatingFile) rotate() error {
+	r.file.Close()
+
+	for i := r.maxBackups - 1; i >= 1; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", r.path, i), fmt.Sprintf("%s.%d", r.path, i+1))
+	}
+
+	if r.maxBackups > 0 {
+		os.Rename(r.path, r.path+".1")
+	} else {
+		os.Remove(r.path)
+	}
+
+	return r.open()
+}
+
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.maxSize > 0 && r.size > 0 && r.size+int64(len(p)) > r.maxSize {
+		if err := r.rotate(); err != nil {
+			// keep logging to stderr rather than losing the messages
+			return os.Stderr.Write(p)
+		}
+	}
+
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+
+	return n, err
+}
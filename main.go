@@ -1,111 +1,581 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"gopkg.in/yaml.v2"
 	"io/ioutil"
 	"log"
+	"net/url"
 	"os"
 	"os/exec"
+	"os/signal"
+	"path/filepath"
 	"regexp"
 	"strconv"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	mqtt "github.com/eclipse/paho.mqtt.golang"
 )
 
+var settings config
 var hostname string
-var model string
+var baseTopic string
+var hardware hardwareInfo
+
+// Set by the release build with -ldflags "-X main.version=..."
+var version = "dev"
+
+// Hardware UUID, empty if it can't be read
+var platformUUID string
 var tokenTimeOut time.Duration = 5 * time.Second
 
+// How often states are read
+const (
+	batteryInterval    = 60 * time.Second
+	diskInterval       = 60 * time.Second
+	networkInterval    = 60 * time.Second
+	bluetoothInterval  = 10 * time.Second
+	systemInterval     = 60 * time.Second
+	screenInterval     = 5 * time.Second
+	mediaInterval      = 5 * time.Second
+	thermalInterval    = 60 * time.Second
+	powerUsageInterval = 30 * time.Second
+	scriptsInterval    = 30 * time.Second
+	dockerInterval     = 30 * time.Second
+	printersInterval   = 60 * time.Second
+	nightShiftInterval = 10 * time.Second
+	// asking System Events starts osascript, so not every few seconds
+	screenSaverInterval = 60 * time.Second
+	// device lists rarely change, every poll runs SwitchAudioSource 4 times
+	audioDevicesInterval = 60 * time.Second
+	// drives are checked often, an automation can wait for the eject
+	externalDrivesInterval = 10 * time.Second
+	// how often scripts in sensors.d are checked, each runs on its own interval
+	externalSensorsInterval = 10 * time.Second
+	// public IP is asked from an outside service, not too often
+	publicIPInterval = 5 * time.Minute
+	// SSD wear changes slowly
+	smartInterval = 1 * time.Hour
+	// softwareupdate asks Apple's servers
+	softwareUpdateInterval = 6 * time.Hour
+
+	// all states are sent again after this time even if they didn't change
+	stateRefreshInterval = 10 * time.Minute
+)
+
+// Seconds after which Home Assistant marks a sensor that is read every
+// interval as unavailable. Unchanged values are sent only every
+// stateRefreshInterval, two missed reads on top of that mean mac2mqtt is gone
+func expireAfter(interval time.Duration) int {
+	return int((stateRefreshInterval + 2*interval).Seconds())
+}
+
 // Home Assistant device information
 type Device struct {
-	Identifiers  []string `json:"identifiers"`
-	Name         string   `json:"name"`
-	Manufacturer string   `json:"manufacturer"`
-	Model        string   `json:"model"`
+	Identifiers      []string `json:"identifiers"`
+	Name             string   `json:"name"`
+	Manufacturer     string   `json:"manufacturer"`
+	Model            string   `json:"model,omitempty"`
+	ModelID          string   `json:"model_id,omitempty"`
+	SerialNumber     string   `json:"serial_number,omitempty"`
+	SwVersion        string   `json:"sw_version,omitempty"`
+	ConfigurationURL string   `json:"configuration_url,omitempty"`
 }
 
 // Home Assistant MQTT Discovery config for sensors
 type SensorConfig struct {
+	Name                      string   `json:"name"`
+	StateTopic                string   `json:"state_topic"`
+	UniqueID                  string   `json:"unique_id"`
+	UnitOfMeasurement         string   `json:"unit_of_measurement,omitempty"`
+	DeviceClass               string   `json:"device_class,omitempty"`
+	StateClass                string   `json:"state_class,omitempty"`
+	Options                   []string `json:"options,omitempty"`
+	ValueTemplate             string   `json:"value_template,omitempty"`
+	JsonAttributesTopic       string   `json:"json_attributes_topic,omitempty"`
+	Icon                      string   `json:"icon,omitempty"`
+	EntityCategory            string   `json:"entity_category,omitempty"`
+	SuggestedDisplayPrecision *int     `json:"suggested_display_precision,omitempty"`
+	ExpireAfter               int      `json:"expire_after,omitempty"`
+	AvailabilityTopic         string   `json:"availability_topic,omitempty"`
+	Device                    Device   `json:"device"`
+}
+
+// Home Assistant MQTT Discovery config for binary sensors
+type BinarySensorConfig struct {
+	Name                string `json:"name"`
+	StateTopic          string `json:"state_topic"`
+	UniqueID            string `json:"unique_id"`
+	DeviceClass         string `json:"device_class,omitempty"`
+	PayloadOn           string `json:"payload_on,omitempty"`
+	PayloadOff          string `json:"payload_off,omitempty"`
+	ValueTemplate       string `json:"value_template,omitempty"`
+	JsonAttributesTopic string `json:"json_attributes_topic,omitempty"`
+	Icon                string `json:"icon,omitempty"`
+	EntityCategory      string `json:"entity_category,omitempty"`
+	ExpireAfter         int    `json:"expire_after,omitempty"`
+	AvailabilityTopic   string `json:"availability_topic,omitempty"`
+	Device              Device `json:"device"`
+}
+
+// Home Assistant MQTT Discovery config for buttons (one-shot commands)
+type ButtonConfig struct {
 	Name              string `json:"name"`
-	StateTopic        string `json:"state_topic"`
+	CommandTopic      string `json:"command_topic"`
+	PayloadPress      string `json:"payload_press,omitempty"`
 	UniqueID          string `json:"unique_id"`
-	UnitOfMeasurement string `json:"unit_of_measurement,omitempty"`
 	DeviceClass       string `json:"device_class,omitempty"`
+	Icon              string `json:"icon,omitempty"`
+	EntityCategory    string `json:"entity_category,omitempty"`
+	AvailabilityTopic string `json:"availability_topic,omitempty"`
+	Device            Device `json:"device"`
+}
+
+// Home Assistant MQTT Discovery config for number entities (volume control)
+type NumberConfig struct {
+	Name              string `json:"name"`
+	CommandTopic      string `json:"command_topic"`
+	StateTopic        string `json:"state_topic"`
 	ValueTemplate     string `json:"value_template,omitempty"`
+	UniqueID          string `json:"unique_id"`
+	Min               int    `json:"min"`
+	Max               int    `json:"max"`
+	Icon              string `json:"icon,omitempty"`
+	EntityCategory    string `json:"entity_category,omitempty"`
+	AvailabilityTopic string `json:"availability_topic,omitempty"`
 	Device            Device `json:"device"`
 }
 
-// Home Assistant MQTT Discovery config for binary sensors
-type BinarySensorConfig struct {
-	Name         string `json:"name"`
-	StateTopic   string `json:"state_topic"`
-	UniqueID     string `json:"unique_id"`
-	DeviceClass  string `json:"device_class,omitempty"`
-	PayloadOn    string `json:"payload_on,omitempty"`
-	PayloadOff   string `json:"payload_off,omitempty"`
-	Device       Device `json:"device"`
+// Home Assistant MQTT Discovery config for switches
+type SwitchConfig struct {
+	Name              string `json:"name"`
+	StateTopic        string `json:"state_topic,omitempty"`
+	CommandTopic      string `json:"command_topic"`
+	PayloadOn         string `json:"payload_on,omitempty"`
+	PayloadOff        string `json:"payload_off,omitempty"`
+	ValueTemplate     string `json:"value_template,omitempty"`
+	UniqueID          string `json:"unique_id"`
+	Icon              string `json:"icon,omitempty"`
+	EntityCategory    string `json:"entity_category,omitempty"`
+	AvailabilityTopic string `json:"availability_topic,omitempty"`
+	Device            Device `json:"device"`
 }
 
+// Home Assistant MQTT Discovery config for select entities (one of several options)
+type SelectConfig struct {
+	Name              string   `json:"name"`
+	CommandTopic      string   `json:"command_topic"`
+	StateTopic        string   `json:"state_topic"`
+	Options           []string `json:"options"`
+	UniqueID          string   `json:"unique_id"`
+	Icon              string   `json:"icon,omitempty"`
+	EntityCategory    string   `json:"entity_category,omitempty"`
+	AvailabilityTopic string   `json:"availability_topic,omitempty"`
+	Device            Device   `json:"device"`
+}
 
-type ButtonConfig struct {
+// Home Assistant MQTT Discovery config for text entities (free form input)
+type TextConfig struct {
 	Name              string `json:"name"`
 	CommandTopic      string `json:"command_topic"`
-	PayloadPress	  string `json:"payload_press,omitempty"`
+	StateTopic        string `json:"state_topic,omitempty"`
 	UniqueID          string `json:"unique_id"`
+	Icon              string `json:"icon,omitempty"`
+	EntityCategory    string `json:"entity_category,omitempty"`
+	AvailabilityTopic string `json:"availability_topic,omitempty"`
 	Device            Device `json:"device"`
 }
 
-// Home Assistant MQTT Discovery config for number entities (volume control)
-type NumberConfig struct {
-	Name         string `json:"name"`
-	CommandTopic string `json:"command_topic"`
-	StateTopic   string `json:"state_topic"`
-	UniqueID     string `json:"unique_id"`
-	Min          int    `json:"min"`
-	Max          int    `json:"max"`
-	Device       Device `json:"device"`
+// Home Assistant MQTT Discovery config for cameras (JPEG images published to a topic)
+type CameraConfig struct {
+	Name              string `json:"name"`
+	Topic             string `json:"topic"`
+	UniqueID          string `json:"unique_id"`
+	Icon              string `json:"icon,omitempty"`
+	EntityCategory    string `json:"entity_category,omitempty"`
+	AvailabilityTopic string `json:"availability_topic,omitempty"`
+	Device            Device `json:"device"`
+}
+
+// Home Assistant MQTT Discovery config for images shown from a URL
+type ImageConfig struct {
+	Name              string `json:"name"`
+	URLTopic          string `json:"url_topic"`
+	URLTemplate       string `json:"url_template,omitempty"`
+	UniqueID          string `json:"unique_id"`
+	Icon              string `json:"icon,omitempty"`
+	AvailabilityTopic string `json:"availability_topic,omitempty"`
+	Device            Device `json:"device"`
+}
+
+// Home Assistant MQTT Discovery config for notify entities (text sent to the Mac)
+type NotifyConfig struct {
+	Name              string `json:"name"`
+	CommandTopic      string `json:"command_topic"`
+	UniqueID          string `json:"unique_id"`
+	Icon              string `json:"icon,omitempty"`
+	EntityCategory    string `json:"entity_category,omitempty"`
+	AvailabilityTopic string `json:"availability_topic,omitempty"`
+	Device            Device `json:"device"`
+}
+
+// Home Assistant MQTT Discovery config for update entities. The state is JSON
+// with installed_version and latest_version
+type UpdateConfig struct {
+	Name              string `json:"name"`
+	StateTopic        string `json:"state_topic"`
+	UniqueID          string `json:"unique_id"`
+	DeviceClass       string `json:"device_class,omitempty"`
+	Icon              string `json:"icon,omitempty"`
+	EntityCategory    string `json:"entity_category,omitempty"`
+	AvailabilityTopic string `json:"availability_topic,omitempty"`
+	Device            Device `json:"device"`
+}
+
+// For SuggestedDisplayPrecision, 0 is a valid precision so the field is a pointer
+func precision(digits int) *int {
+	return &digits
 }
 
 type config struct {
-	Ip       string `yaml:"mqtt_ip"`
-	Port     string `yaml:"mqtt_port"`
-	User     string `yaml:"mqtt_user"`
-	Password string `yaml:"mqtt_password"`
+	Ip          string `yaml:"mqtt_ip"`
+	Port        string `yaml:"mqtt_port"`
+	User        string `yaml:"mqtt_user"`
+	Password    string `yaml:"mqtt_password"`
+	Hostname    string `yaml:"hostname"`
+	TopicPrefix string `yaml:"topic_prefix"`
+
+	// Keychain service name of the MQTT password, used instead of mqtt_password
+	PasswordKeychain string `yaml:"mqtt_password_keychain"`
+
+	// Broker URL, used instead of mqtt_ip and mqtt_port, e.g.
+	// wss://ha.example.com:443/mqtt for a broker behind an HTTP reverse proxy
+	URL string `yaml:"mqtt_url"`
+
+	// Additional brokers that get states too
+	Brokers []brokerConfig `yaml:"brokers"`
+
+	// Defaults to "mac2mqtt_" + hostname
+	ClientID string `yaml:"mqtt_client_id"`
+
+	// With false the broker keeps the subscription while mac2mqtt is
+	// disconnected and queues QoS 1 and 2 commands. True by default
+	CleanSession *bool `yaml:"clean_session"`
+
+	Disk diskConfig `yaml:"disk"`
+
+	// Shortcuts.app shortcuts published as Home Assistant buttons
+	Shortcuts []string `yaml:"shortcuts"`
+
+	// Applications with a running binary sensor and launch and quit buttons
+	Apps []string `yaml:"apps"`
+
+	// Process names of conferencing apps besides Zoom, Teams, Webex, Slack
+	// and FaceTime, e.g. "Google Chrome" for Meet in the browser
+	MeetingApps []string `yaml:"meeting_apps"`
+
+	// Docker or OrbStack containers that get a switch, e.g. homeassistant
+	Containers []string `yaml:"containers"`
+
+	// Named shell commands that can be run with PREFIX/command/run/NAME
+	Commands map[string]string `yaml:"commands"`
+
+	// Named key combinations that can be pressed with PREFIX/command/key/NAME,
+	// e.g. mission_control: ctrl+up
+	Keys map[string]string `yaml:"keys"`
+
+	// Named AppleScript buttons, switches and sensors
+	Scripts map[string]scriptConfig `yaml:"scripts"`
+
+	ExternalSensors externalSensorsConfig `yaml:"external_sensors"`
+
+	Focus focusConfig `yaml:"focus"`
+
+	Network networkConfig `yaml:"network"`
+
+	// Seconds without keyboard or mouse activity after which the user is not active
+	UserActiveThreshold int `yaml:"user_active_threshold"`
+
+	Occupancy occupancyConfig `yaml:"occupancy"`
+
+	// Default step of PREFIX/command/volume_up and volume_down
+	VolumeStep int `yaml:"volume_step"`
+
+	// Longest side of screenshots in pixels
+	ScreenshotMaxSize int `yaml:"screenshot_max_size"`
+
+	// Allow taking photos with the built-in camera. Off by default for privacy
+	Webcam bool `yaml:"webcam"`
+
+	// Allow reading and setting the clipboard. Off by default for privacy
+	Clipboard bool `yaml:"clipboard"`
+
+	// Write the status file read by the SwiftBar or xbar plugin. Off by default
+	MenuBar bool `yaml:"menu_bar"`
+
+	// "Music" or "Spotify", the app controlled with AppleScript. Off by default
+	MusicPlayer string `yaml:"music_player"`
+
+	// Publish state topics with the MQTT retain flag
+	Retain bool `yaml:"retain"`
+
+	// Retain flag of single topics, overrides Retain. Keys are topics
+	// without the prefix, e.g. "state/volume"
+	RetainTopics map[string]bool `yaml:"retain_topics"`
+
+	QoS qosConfig `yaml:"qos"`
+
+	// Also publish all states as one JSON document to PREFIX/state
+	CombinedState bool `yaml:"combined_state"`
+
+	// Also publish sleep, display sleep, shutdown and restart as switches,
+	// for automations made when they were switches instead of buttons
+	LegacySwitches bool `yaml:"legacy_switches"`
+
+	// Features that are turned off, e.g. shutdown: false. Names are the
+	// names of the sensors, everything is on by default
+	Features map[string]bool `yaml:"features"`
+
+	Log logConfig `yaml:"log"`
+
+	HTTP httpConfig `yaml:"http"`
+
+	CommandSigning commandSigningConfig `yaml:"command_signing"`
+
+	// Send an empty retained message to every command topic after a command,
+	// so a command sent with the retain flag is not run again. True by default
+	ClearRetainedCommands bool `yaml:"clear_retained_commands"`
+
+	// Shortest time in seconds between two runs of a command, e.g.
+	// shutdown: 60. 0 turns the limit off
+	CommandIntervals map[string]int `yaml:"command_intervals"`
 }
 
-func (c *config) getConfig() *config {
+// MQTT QoS levels, 0, 1 or 2
+type qosConfig struct {
+	// State messages and the availability topic
+	State byte `yaml:"state"`
+	// Subscription to PREFIX/command/#
+	Command byte `yaml:"command"`
+	// Home Assistant discovery configs
+	Discovery byte `yaml:"discovery"`
+}
 
-	configContent, err := ioutil.ReadFile("mac2mqtt.yaml")
-	if err != nil {
-		log.Fatal(err)
+// Places where mac2mqtt.yaml is looked for when there is no -config flag. The
+// working directory comes first, launchd starts programs in / so the other
+// places are needed for running in the background
+func getConfigPaths() []string {
+	paths := []string{"mac2mqtt.yaml"}
+
+	if home, err := os.UserHomeDir(); err == nil {
+		paths = append(paths, filepath.Join(home, ".config/mac2mqtt/mac2mqtt.yaml"))
 	}
 
-	err = yaml.Unmarshal(configContent, c)
-	if err != nil {
-		log.Fatal(err)
+	return append(paths, "/usr/local/etc/mac2mqtt.yaml")
+}
+
+// Returns empty string if there is no config file in any of the places
+func findConfigFile() string {
+	for _, path := range getConfigPaths() {
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
 	}
 
-	if c.Ip == "" {
-		log.Fatal("Must specify mqtt_ip in mac2mqtt.yaml")
+	return ""
+}
+
+func (c *config) getConfig(path string) *config {
+
+	if path == "" {
+		path = findConfigFile()
 	}
 
-	if c.Port == "" {
-		log.Fatal("Must specify mqtt_port in mac2mqtt.yaml")
+	// defaults that are true, the file and environment variables can turn them off
+	c.ClearRetainedCommands = true
+
+	// Everything can be set with environment variables, so the file is optional
+	if path != "" {
+		logInfof("Using config %s", path)
+
+		configContent, err := ioutil.ReadFile(path)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		err = yaml.Unmarshal(configContent, c)
+		if err != nil {
+			log.Fatal(err)
+		}
+	} else {
+		logInfof("Can't find mac2mqtt.yaml in %s, using only environment variables", strings.Join(getConfigPaths(), ", "))
+	}
+
+	applyEnvOverrides(c)
+
+	if c.URL != "" {
+		checkBrokerURL("mqtt_url", c.URL)
+	} else {
+		if c.Ip == "" {
+			log.Fatal("Must specify mqtt_ip or mqtt_url in mac2mqtt.yaml or MAC2MQTT_MQTT_IP")
+		}
+
+		if c.Port == "" {
+			log.Fatal("Must specify mqtt_port in mac2mqtt.yaml or MAC2MQTT_MQTT_PORT")
+		}
 	}
 
 	if c.User == "" {
-		log.Fatal("Must specify mqtt_user in mac2mqtt.yaml")
+		log.Fatal("Must specify mqtt_user in mac2mqtt.yaml or MAC2MQTT_MQTT_USER")
+	}
+
+	if c.Password == "" && c.PasswordKeychain != "" {
+		password, err := getKeychainPassword(c.PasswordKeychain)
+		if err != nil {
+			log.Fatalf("Can't read MQTT password from Keychain item %q: %v", c.PasswordKeychain, err)
+		}
+		c.Password = password
 	}
 
 	if c.Password == "" {
-		log.Fatal("Must specify mqtt_password in mac2mqtt.yaml")
+		log.Fatal("Must specify mqtt_password or mqtt_password_keychain in mac2mqtt.yaml or MAC2MQTT_MQTT_PASSWORD")
 	}
 
+	for i := range c.Brokers {
+		b := &c.Brokers[i]
+
+		if b.URL == "" {
+			log.Fatal("Must specify url of every broker in brokers in mac2mqtt.yaml")
+		}
+		checkBrokerURL("url of broker "+b.URL, b.URL)
+
+		if b.Password == "" && b.PasswordKeychain != "" {
+			password, err := getKeychainPassword(b.PasswordKeychain)
+			if err != nil {
+				log.Fatalf("Can't read MQTT password from Keychain item %q: %v", b.PasswordKeychain, err)
+			}
+			b.Password = password
+		}
+	}
+
+	if c.Hostname == "" {
+		c.Hostname = getHostname()
+	}
+
+	if c.TopicPrefix == "" {
+		c.TopicPrefix = "homeassistant/" + c.Hostname
+	}
+
+	// brokers disconnect a client when another one connects with the same id,
+	// so every Mac needs its own
+	if c.ClientID == "" {
+		c.ClientID = "mac2mqtt_" + c.Hostname
+	}
+	c.TopicPrefix = strings.TrimSuffix(c.TopicPrefix, "/")
+
+	if c.UserActiveThreshold <= 0 {
+		c.UserActiveThreshold = 300
+	}
+
+	if c.ExternalSensors.Dir == "" && path != "" {
+		c.ExternalSensors.Dir = filepath.Join(filepath.Dir(path), "sensors.d")
+	}
+
+	if c.ExternalSensors.Interval <= 0 {
+		c.ExternalSensors.Interval = 60
+	}
+
+	if c.ExternalSensors.Timeout <= 0 {
+		c.ExternalSensors.Timeout = 10
+	}
+
+	if c.Occupancy.IdleThreshold <= 0 {
+		c.Occupancy.IdleThreshold = c.UserActiveThreshold
+	}
+
+	if c.VolumeStep <= 0 {
+		c.VolumeStep = 5
+	}
+
+	if c.ScreenshotMaxSize <= 0 {
+		c.ScreenshotMaxSize = 1280
+	}
+
+	if c.CommandSigning.Secret == "" && c.CommandSigning.SecretKeychain != "" {
+		secret, err := getKeychainPassword(c.CommandSigning.SecretKeychain)
+		if err != nil {
+			log.Fatalf("Can't read command signing secret from Keychain item %q: %v", c.CommandSigning.SecretKeychain, err)
+		}
+		c.CommandSigning.Secret = secret
+	}
+
+	if len(c.CommandSigning.Commands) == 0 {
+		c.CommandSigning.Commands = []string{"sleep", "displaysleep", "shutdown", "restart", "lockscreen", "run", "shortcut", "key", "script"}
+	}
+
+	if c.CommandSigning.MaxAge <= 0 {
+		c.CommandSigning.MaxAge = 60
+	}
+
+	if c.HTTP.Enabled && c.HTTP.Token == "" {
+		log.Fatal("Must specify http.token in mac2mqtt.yaml or MAC2MQTT_HTTP_TOKEN when the HTTP API is enabled")
+	}
+
+	if c.HTTP.Listen == "" {
+		c.HTTP.Listen = "127.0.0.1:8470"
+	}
+
+	if c.Log.Level == "" {
+		c.Log.Level = "info"
+	}
+
+	if c.Log.Format == "" {
+		c.Log.Format = "text"
+	}
+
+	if c.Log.MaxSize <= 0 {
+		c.Log.MaxSize = 10
+	}
+
+	if c.Log.MaxBackups <= 0 {
+		c.Log.MaxBackups = 3
+	}
+
+	if c.QoS.State > 2 || c.QoS.Command > 2 || c.QoS.Discovery > 2 {
+		log.Fatal("QoS in mac2mqtt.yaml can be only 0, 1 or 2")
+	}
+
+	// the answer is trusted as the public IP, it must not come over plain HTTP
+	if c.Network.PublicIPURL != "" && !strings.HasPrefix(c.Network.PublicIPURL, "https://") {
+		log.Fatal("network.public_ip_url in mac2mqtt.yaml must be an https:// address")
+	}
+
+	if _, found := musicPlayers[c.MusicPlayer]; c.MusicPlayer != "" && !found {
+		log.Fatal("music_player in mac2mqtt.yaml can be only Music or Spotify")
+	}
+
+	for name := range c.Features {
+		if !isFeature(name) {
+			log.Fatalf("Unknown feature %q in mac2mqtt.yaml", name)
+		}
+	}
+
+	for name := range c.Commands {
+		if getObjectId(name) != name {
+			log.Fatalf("Command name %q in mac2mqtt.yaml can contain only a-z, 0-9 and _", name)
+		}
+	}
+
+	checkKeyCombos(c.Keys)
+	checkScripts(c.Scripts)
+
 	return c
 }
 
@@ -130,124 +600,282 @@ func getHostname() string {
 	return firstPart
 }
 
-func getCommandOutput(name string, arg ...string) string {
-	cmd := exec.Command(name, arg...)
+var objectIdRegexp = regexp.MustCompile("[^a-z0-9]+")
+
+// Turns user provided names into strings usable in topics and unique ids
+// "Good Morning!" => "good_morning"
+func getObjectId(name string) string {
+	id := objectIdRegexp.ReplaceAllString(strings.ToLower(name), "_")
+	return strings.Trim(id, "_")
+}
+
+// Commands are killed when they run longer, so a hung tool can't hold back
+// its sensor forever
+const commandTimeout = 30 * time.Second
+
+// For tools that can take a while: softwareupdate asks Apple's servers,
+// Shortcuts and commands from mac2mqtt.yaml can do anything, say reads
+// long texts
+const longCommandTimeout = 10 * time.Minute
+
+func getCommandOutput(name string, arg ...string) (string, error) {
+	return getCommandOutputTimeout(commandTimeout, name, arg...)
+}
+
+func getCommandOutputTimeout(timeout time.Duration, name string, arg ...string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, name, arg...)
 
 	stdout, err := cmd.Output()
 	if err != nil {
-		log.Fatal(err)
+		return "", commandContextError(ctx, name, err)
 	}
 
 	stdoutStr := string(stdout)
 	stdoutStr = strings.TrimSuffix(stdoutStr, "\n")
 
-	return stdoutStr
+	return stdoutStr, nil
 }
 
-func getMuteStatus() bool {
-	output := getCommandOutput("/usr/bin/osascript", "-e", "output muted of (get volume settings)")
+// Adds the command name and its stderr to the error, "exit status 1" alone
+// doesn't tell much
+func commandError(name string, err error) error {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && len(exitErr.Stderr) > 0 {
+		return fmt.Errorf("%s: %v: %s", name, err, strings.TrimSpace(string(exitErr.Stderr)))
+	}
+
+	return fmt.Errorf("%s: %v", name, err)
+}
 
-	b, err := strconv.ParseBool(output)
-	if err != nil {
-		log.Fatal(err)
+// Like commandError, but tells that the command was killed after its timeout
+func commandContextError(ctx context.Context, name string, err error) error {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("%s: killed, it ran too long", name)
 	}
 
-	return b
+	return commandError(name, err)
 }
 
-func getCurrentVolume() int {
-	output := getCommandOutput("/usr/bin/osascript", "-e", "output volume of (get volume settings)")
+// Waits for d, returns false if ctx was done earlier
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
 
-	i, err := strconv.Atoi(output)
-	if err != nil {
-		log.Fatal(err)
+// Returns the full path of an optional helper tool, or empty string if it is
+// not installed. Homebrew locations are checked explicitly because launchd
+// starts mac2mqtt with a minimal PATH
+func findTool(name string) string {
+	path, err := exec.LookPath(name)
+	if err == nil {
+		return path
 	}
 
-	return i
+	for _, dir := range []string{"/opt/homebrew/bin", "/usr/local/bin"} {
+		path, err = exec.LookPath(dir + "/" + name)
+		if err == nil {
+			return path
+		}
+	}
+
+	return ""
 }
 
-func runCommand(name string, arg ...string) {
-	cmd := exec.Command(name, arg...)
+func getMuteStatus() (bool, error) {
+	output, err := getCommandOutput("/usr/bin/osascript", "-e", "output muted of (get volume settings)")
+	if err != nil {
+		return false, err
+	}
 
-	_, err := cmd.Output()
+	return strconv.ParseBool(output)
+}
+
+func getCurrentVolume() (int, error) {
+	output, err := getCommandOutput("/usr/bin/osascript", "-e", "output volume of (get volume settings)")
 	if err != nil {
-		log.Fatal(err)
+		return 0, err
 	}
+
+	return strconv.Atoi(output)
+}
+
+func runCommand(name string, arg ...string) error {
+	return runCommandTimeout(commandTimeout, name, arg...)
 }
 
-// Combined function to get both battery percentage and charging status
-func getBatteryInfo() (percent string, isCharging bool) {
-	output := getCommandOutput("/usr/bin/pmset", "-g", "batt")
+func runCommandTimeout(timeout time.Duration, name string, arg ...string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
 
-	// $ /usr/bin/pmset -g batt
-	// Now drawing from 'Battery Power'
-	//  -InternalBattery-0 (id=4653155)        100%; discharging; 20:00 remaining present: true
-	
-	// Extract battery percentage
-	r := regexp.MustCompile(`(\d+)%`)
-	percent = r.FindStringSubmatch(output)[1]
-	
-	// Check if drawing power from AC Power source
-	isCharging = strings.Contains(output, "AC Power")
-	
-	return percent, isCharging
+	cmd := exec.CommandContext(ctx, name, arg...)
+
+	_, err := cmd.Output()
+	if err != nil {
+		return commandContextError(ctx, name, err)
+	}
+
+	return nil
 }
 
 // from 0 to 100
-func setVolume(i int) {
-	runCommand("/usr/bin/osascript", "-e", "set volume output volume "+strconv.Itoa(i))
+func setVolume(i int) error {
+	return runCommand("/usr/bin/osascript", "-e", "set volume output volume "+strconv.Itoa(i))
+}
+
+// Changes volume by step (negative to turn it down), keeping it within 0..100
+func changeVolume(step int) error {
+	volume, err := getCurrentVolume()
+	if err != nil {
+		return err
+	}
+
+	i := volume + step
+
+	if i < 0 {
+		i = 0
+	} else if i > 100 {
+		i = 100
+	}
+
+	return setVolume(i)
 }
 
 // true - turn mute on
 // false - turn mute off
-func setMute(b bool) {
-	runCommand("/usr/bin/osascript", "-e", "set volume output muted "+strconv.FormatBool(b))
+func setMute(b bool) error {
+	return runCommand("/usr/bin/osascript", "-e", "set volume output muted "+strconv.FormatBool(b))
 }
 
-func commandSleep() {
-	runCommand("pmset", "sleepnow")
+// With -dry-run commands that take the Mac away or run user code are only
+// logged, so automations can be tried without consequences
+var dryRun bool
+
+func isDryRun(action string) bool {
+	if dryRun {
+		logInfof("Dry run, not %s", action)
+	}
+	return dryRun
 }
 
-func commandDisplaySleep() {
-	runCommand("pmset", "displaysleepnow")
+func commandSleep() error {
+	if isDryRun("putting the computer to sleep") {
+		return nil
+	}
+
+	return runCommand("pmset", "sleepnow")
 }
 
-func commandShutdown() {
+func commandDisplaySleep() error {
+	if isDryRun("putting displays to sleep") {
+		return nil
+	}
+
+	return runCommand("pmset", "displaysleepnow")
+}
+
+func commandShutdown() error {
+	if isDryRun("shutting down") {
+		return nil
+	}
 
 	if os.Getuid() == 0 {
 		// if the program is run by root user we are doing the most powerfull shutdown - that always shuts down the computer
-		runCommand("shutdown", "-h", "now")
-	} else {
-		// if the program is run by ordinary user we are trying to shutdown, but it may fail if the other user is logged in
-		runCommand("/usr/bin/osascript", "-e", "tell app \"System Events\" to shut down")
+		return runCommand("shutdown", "-h", "now")
 	}
+
+	// if the program is run by ordinary user we are trying to shutdown, but it may fail if the other user is logged in
+	return runCommand("/usr/bin/osascript", "-e", "tell app \"System Events\" to shut down")
+}
+
+func commandRestart() error {
+	if isDryRun("restarting") {
+		return nil
+	}
+
+	if os.Getuid() == 0 {
+		// same as with shutdown, root can always restart the computer
+		return runCommand("shutdown", "-r", "now")
+	}
+
+	// may fail if the other user is logged in
+	return runCommand("/usr/bin/osascript", "-e", "tell app \"System Events\" to restart")
 }
 
 var messagePubHandler mqtt.MessageHandler = func(client mqtt.Client, msg mqtt.Message) {
-	log.Printf("Received message: %s from topic: %s\n", msg.Payload(), msg.Topic())
+	logDebugf("Received message: %s from topic: %s", msg.Payload(), msg.Topic())
 }
 
 var connectHandler mqtt.OnConnectHandler = func(client mqtt.Client) {
-	log.Println("Connected to MQTT")
+	logInfof("Connected to MQTT")
+
+	diagnostics.connects.Add(1)
+
+	client = withBrokers(client)
+
+	updateAvailability(client, true)
+
+	resetStateCache()
 
 	publishHADiscoveryConfig(client)
 
+	// volume, camera and microphone are published only on change, so send the current state
+	updateEventStates(client)
+
 	listen(client, getTopicPrefix()+"/command/#")
+	listenHAStatus(client)
+}
+
+// Home Assistant sends "online" to homeassistant/status when it starts. After
+// a restart it may have lost discovery configs and states that were not
+// retained, so everything is published again
+func listenHAStatus(client mqtt.Client) {
+
+	token := client.Subscribe("homeassistant/status", settings.QoS.Command, func(client mqtt.Client, msg mqtt.Message) {
+
+		// the retained message arrives on every subscribe, connectHandler already published everything
+		if msg.Retained() || string(msg.Payload()) != "online" {
+			return
+		}
+
+		logInfof("Home Assistant is online, publishing discovery configs and states")
+
+		client = withBrokers(client)
+
+		// the handler must not block the MQTT client for long
+		go func() {
+			resetStateCache()
+			publishHADiscoveryConfig(client)
+			updateAllStates(client)
+		}()
+	})
+
+	if !token.WaitTimeout(tokenTimeOut) {
+		logWarnf("Subscribe timed out after %v", tokenTimeOut)
+	} else if token.Error() != nil {
+		logErrorf("Token error: %s", token.Error())
+	}
 }
 
 var connectLostHandler mqtt.ConnectionLostHandler = func(client mqtt.Client, err error) {
-	log.Printf("Disconnected from MQTT: %v", err)
-	
+	logWarnf("Disconnected from MQTT: %v", err)
+
 	// Attempt to reconnect
 	go func() {
 		for {
-			log.Println("Attempting to reconnect to MQTT...")
+			logInfof("Attempting to reconnect to MQTT...")
 			token := client.Connect()
 			if token.WaitTimeout(5*time.Second) && token.Error() == nil {
-				log.Println("Reconnected to MQTT successfully")
+				logInfof("Reconnected to MQTT successfully")
 				break
 			} else {
-				log.Printf("Failed to reconnect: %v. Retrying in 5 seconds...", token.Error())
+				logWarnf("Failed to reconnect: %v. Retrying in 5 seconds...", token.Error())
 				time.Sleep(5 * time.Second)
 			}
 		}
@@ -256,27 +884,63 @@ var connectLostHandler mqtt.ConnectionLostHandler = func(client mqtt.Client, err
 
 var client mqtt.Client
 
-func getMQTTClient(ip, port, user, password string) mqtt.Client {
+func getBrokerURL() string {
+	if settings.URL != "" {
+		return settings.URL
+	}
+
+	return fmt.Sprintf("tcp://%s:%s", settings.Ip, settings.Port)
+}
+
+func checkBrokerURL(name string, broker string) {
+	u, err := url.Parse(broker)
+	if err != nil {
+		log.Fatalf("Incorrect %s in mac2mqtt.yaml: %v", name, err)
+	}
+
+	switch u.Scheme {
+	case "tcp", "mqtt", "ssl", "tls", "mqtts", "ws", "wss":
+	default:
+		log.Fatalf("%s in mac2mqtt.yaml must start with tcp://, ssl://, ws:// or wss://", name)
+	}
+}
+
+func getMQTTOptions(broker, user, password string) *mqtt.ClientOptions {
 
 	opts := mqtt.NewClientOptions()
-	opts.AddBroker(fmt.Sprintf("tcp://%s:%s", ip, port))
+	opts.AddBroker(broker)
 	opts.SetUsername(user)
 	opts.SetPassword(password)
-	opts.SetClientID("mac2mqtt") 
-	opts.SetAutoReconnect(true)           // Enable auto-reconnect
-	opts.SetConnectRetry(true)            // Enable connect retry
+	opts.SetClientID(settings.ClientID)
+	if settings.CleanSession != nil {
+		opts.SetCleanSession(*settings.CleanSession)
+	}
+	opts.SetAutoReconnect(true)                   // Enable auto-reconnect
+	opts.SetConnectRetry(true)                    // Enable connect retry
 	opts.SetConnectRetryInterval(5 * time.Second) // Set retry interval
+	// the default of 10 minutes would keep mac2mqtt offline long after the
+	// Mac wakes up
+	opts.SetMaxReconnectInterval(30 * time.Second)
 
+	// Broker publishes "offline" on our behalf if the connection drops without a clean disconnect
+	opts.SetWill(getAvailabilityTopic(), "offline", settings.QoS.State, true)
+
+	return opts
+}
+
+func getMQTTClient(broker, user, password string) mqtt.Client {
+
+	opts := getMQTTOptions(broker, user, password)
 	opts.OnConnect = connectHandler
 	opts.OnConnectionLost = connectLostHandler
 
 	client = mqtt.NewClient(opts)
-	token := client.Connect();
-	if !token.WaitTimeout(5*time.Second) {
-		log.Printf("MQTT connection timed out")
+	token := client.Connect()
+	if !token.WaitTimeout(5 * time.Second) {
+		logWarnf("MQTT connection timed out")
 		panic("MQTT connection timed out")
 	} else if token.Error() != nil {
-		log.Printf("MQTT connection error: %v", token.Error())
+		logErrorf("MQTT connection error: %v", token.Error())
 		panic(token.Error())
 	}
 
@@ -284,259 +948,746 @@ func getMQTTClient(ip, port, user, password string) mqtt.Client {
 }
 
 func getTopicPrefix() string {
-	return "homeassistant/" + hostname
+	return baseTopic
 }
 
-func listen(client mqtt.Client, topic string) {
+func getAvailabilityTopic() string {
+	return getTopicPrefix() + "/status"
+}
+
+func init() {
+	// volume and mute are published by watchVolume on change
+	registerSensor(sensorFuncs{
+		name: "volume",
+		poll: func(client mqtt.Client) {
+			updateVolume(client)
+			updateMute(client)
+		},
+		discovery: publishVolumeDiscoveryConfig,
+	})
 
-	token := client.Subscribe(topic, 0, func(client mqtt.Client, msg mqtt.Message) {
+	registerSensor(sensorFuncs{
+		name:      "sleep",
+		discovery: publishSleepDiscoveryConfig,
+	})
 
-		topicPrefix := getTopicPrefix()
+	registerSensor(sensorFuncs{
+		name:      "shutdown",
+		discovery: publishShutdownDiscoveryConfig,
+	})
 
-		topic := string(msg.Topic())
-		commd := string(msg.Payload())
+	registerSensor(sensorFuncs{
+		name:      "restart",
+		discovery: publishRestartDiscoveryConfig,
+	})
 
-		log.Printf("Received command:  [ %s ] [ %s ]", topic, commd)
+	registerCommand("volume", "volume", func(client mqtt.Client, command string, payload string) {
+		i, err := strconv.Atoi(payload)
+		if err != nil || i < 0 || i > 100 {
+			logWarnf("Incorrect volume value")
+			return
+		}
 
-		if topic == topicPrefix+"/command/volume" {
+		if err := setVolume(i); err != nil {
+			reportError(client, "setting volume", err)
+		}
 
-			i, err := strconv.Atoi(commd)
-			if err == nil && i >= 0 && i <= 100 {
+		time.Sleep(1 * time.Second)
 
-				setVolume(i)
+		updateVolume(client)
+		updateMute(client)
+	})
 
-				time.Sleep(1 * time.Second)
+	changeVolumeCommand := func(client mqtt.Client, command string, payload string) {
+		// payload is an optional step, anything else (e.g. "PRESS" from a button) uses the configured one
+		step, err := strconv.Atoi(payload)
+		if err != nil || step <= 0 || step > 100 {
+			step = settings.VolumeStep
+		}
 
-				updateVolume(client)
-				updateMute(client)
+		if command == "volume_down" {
+			step = -step
+		}
 
-			} else {
-				log.Println("Incorrect volume value")
-			}
+		if err := changeVolume(step); err != nil {
+			reportError(client, "changing volume", err)
+		}
 
-		} else if topic == topicPrefix+"/command/mute" {
+		time.Sleep(1 * time.Second)
 
-			b, err := strconv.ParseBool(commd)
-			if err == nil {
-				setMute(b)
+		updateVolume(client)
+		updateMute(client)
+	}
+	registerCommand("volume", "volume_up", changeVolumeCommand)
+	registerCommand("volume", "volume_down", changeVolumeCommand)
+
+	registerCommand("volume", "mute", func(client mqtt.Client, command string, payload string) {
+		b, err := strconv.ParseBool(payload)
+		if err != nil {
+			logWarnf("Incorrect mute value")
+			return
+		}
 
-				time.Sleep(1 * time.Second)
+		if err := setMute(b); err != nil {
+			reportError(client, "setting mute", err)
+		}
 
-				updateVolume(client)
-				updateMute(client)
+		time.Sleep(1 * time.Second)
 
-			} else {
-				log.Println("Incorrect mute value")
-			}
+		updateVolume(client)
+		updateMute(client)
+	})
 
-		} else if topic == topicPrefix+"/command/sleep" {
+	registerCommand("sleep", "sleep", func(client mqtt.Client, command string, payload string) {
+		if payload != "sleep" {
+			return
+		}
 
-			if string(msg.Payload()) == "sleep" {
-				
-				commandSleep()
-			}
+		if err := commandSleep(); err != nil {
+			reportError(client, "putting the computer to sleep", err)
+		}
+	})
 
-		} else if topic == topicPrefix+"/command/displaysleep" {
+	registerCommand("sleep", "displaysleep", func(client mqtt.Client, command string, payload string) {
+		if payload != "displaysleep" {
+			return
+		}
 
-			if string(msg.Payload()) == "displaysleep" {
-				
-				commandDisplaySleep()
-			}
+		if err := commandDisplaySleep(); err != nil {
+			reportError(client, "putting displays to sleep", err)
+		}
+	})
+
+	registerCommand("shutdown", "shutdown", func(client mqtt.Client, command string, payload string) {
+		if payload != "shutdown" {
+			return
+		}
+
+		if err := commandShutdown(); err != nil {
+			reportError(client, "shutting down", err)
+		}
+	})
+
+	registerCommand("restart", "restart", func(client mqtt.Client, command string, payload string) {
+		if payload != "restart" {
+			return
+		}
+
+		// let Home Assistant know the command was accepted before the connection is gone
+		publishState(client, "restart", getTopicPrefix()+"/state/restart", "restarting")
+
+		if err := commandRestart(); err != nil {
+			reportError(client, "restarting", err)
+		}
+	})
+}
+
+func listen(client mqtt.Client, topic string) {
+
+	token := client.Subscribe(topic, settings.QoS.Command, func(client mqtt.Client, msg mqtt.Message) {
 
-		} else if topic == topicPrefix+"/command/shutdown" {
+		topic := string(msg.Topic())
+		commd := string(msg.Payload())
+
+		if isClearedCommandEcho(topic, commd) {
+			return
+		}
 
-			if string(msg.Payload()) == "shutdown" {
-				
-				commandShutdown()
+		logDebugf("Received command:  [ %s ] [ %s ]", topic, commd)
+
+		command, isCommand := strings.CutPrefix(topic, getTopicPrefix()+"/command/")
+
+		if settings.ClearRetainedCommands {
+			// sliders send many value commands that are not retained,
+			// clearing each of them would double the traffic
+			if msg.Retained() || !debouncedCommands[command] {
+				clearRetainedCommand(client, topic)
+			}
+
+			// a retained command was sent before mac2mqtt connected, maybe
+			// long ago, e.g. "shutdown" before the Mac was turned on again
+			if msg.Retained() {
+				logWarnf("Retained command %s is ignored", topic)
+				return
 			}
+		}
+
+		client = withBrokers(client)
 
+		if isPaused() {
+			logInfof("Bridge is paused from the menu bar, command is ignored")
+			return
+		}
+
+		if isCommand {
+			if err := dispatchCommand(client, command, commd); err != nil {
+				logWarnf("Command %s is ignored: %v", command, err)
+			}
 		}
 
 	})
 
 	if !token.WaitTimeout(tokenTimeOut) {
-		log.Printf("Subscribe timed out after %v", tokenTimeOut)
+		logWarnf("Subscribe timed out after %v", tokenTimeOut)
 	} else if token.Error() != nil {
-		log.Printf("Token error: %s\n", token.Error())
+		logErrorf("Token error: %s", token.Error())
 	}
 }
 
-func updateVolume(client mqtt.Client) {
-	token := client.Publish(getTopicPrefix()+"/state/volume", 0, false, strconv.Itoa(getCurrentVolume()))
+// true - "online"
+// false - "offline"
+func updateAvailability(client mqtt.Client, online bool) {
+	payload := "offline"
+	if online {
+		payload = "online"
+	}
+
+	token := client.Publish(getAvailabilityTopic(), settings.QoS.State, true, payload)
 	if !token.WaitTimeout(tokenTimeOut) {
-		log.Printf("Update volume timed out after %v", tokenTimeOut)
+		logWarnf("Update availability timed out after %v", tokenTimeOut)
 	} else if token.Error() != nil {
-		log.Printf("Error updating volume: %v", token.Error())
+		logErrorf("Error updating availability: %v", token.Error())
 	}
 }
 
-func updateMute(client mqtt.Client) {
-	token := client.Publish(getTopicPrefix()+"/state/mute", 0, false, strconv.FormatBool(getMuteStatus()))
-	if !token.WaitTimeout(tokenTimeOut) {
-		log.Printf("Update mute timed out after %v", tokenTimeOut)
-	} else if token.Error() != nil {
-		log.Printf("Error updating mute: %v", token.Error())
+// Last payload published to every state topic. States are published only
+// when they differ from the cached value, which removes most of the MQTT
+// traffic because values like battery or disk space rarely change
+var stateCache = map[string]string{}
+var stateCacheMutex sync.Mutex
+
+// Forgets all published values, so that every state is published again on the
+// next update. Done after (re)connect and periodically, so that subscribers
+// which missed the last message get the value too
+func resetStateCache() {
+	stateCacheMutex.Lock()
+	stateCache = map[string]string{}
+	stateCacheMutex.Unlock()
+}
+
+func isRetained(topic string) bool {
+	if retain, ok := settings.RetainTopics[strings.TrimPrefix(topic, getTopicPrefix()+"/")]; ok {
+		return retain
 	}
+
+	return settings.Retain
 }
 
-func updateBattery(client mqtt.Client) {
-	percent, isCharging := getBatteryInfo()
-	token := client.Publish(getTopicPrefix()+"/state/battery", 0, false, percent)
-	if !token.WaitTimeout(tokenTimeOut) {
-		log.Printf("Update battery timed out after %v", tokenTimeOut)
-	} else if token.Error() != nil {
-		log.Printf("Error updating battery: %v", token.Error())
+func publishState(client mqtt.Client, name string, topic string, payload interface{}) {
+	var value string
+	switch p := payload.(type) {
+	case string:
+		value = p
+	case []byte:
+		value = string(p)
+	default:
+		value = fmt.Sprint(p)
+	}
+
+	if isPaused() || isAsleep() {
+		return
 	}
-	
-	// Also publish charging status
-	token = client.Publish(getTopicPrefix()+"/state/power_adapter", 0, false, strconv.FormatBool(isCharging))
+
+	recordCombinedState(topic, value)
+
+	stateCacheMutex.Lock()
+	cached, found := stateCache[topic]
+	stateCacheMutex.Unlock()
+
+	if found && cached == value {
+		return
+	}
+
+	token := client.Publish(topic, settings.QoS.State, isRetained(topic), payload)
 	if !token.WaitTimeout(tokenTimeOut) {
-		log.Printf("Update power adapter timed out after %v", tokenTimeOut)
+		diagnostics.publishErrors.Add(1)
+		logWarnf("Update %s timed out after %v", name, tokenTimeOut)
 	} else if token.Error() != nil {
-		log.Printf("Error updating power adapter: %v", token.Error())
+		diagnostics.publishErrors.Add(1)
+		logErrorf("Error updating %s: %v", name, token.Error())
+	} else {
+		diagnostics.published.Add(1)
+
+		stateCacheMutex.Lock()
+		stateCache[topic] = value
+		stateCacheMutex.Unlock()
 	}
 }
 
+func updateVolume(client mqtt.Client) {
+	volume, err := getCurrentVolume()
+	if err != nil {
+		reportError(client, "updating volume", err)
+		return
+	}
 
-func publishHADiscoveryConfig(client mqtt.Client) {
-	topicPrefix := getTopicPrefix()
-	
-	device := Device{
-		Identifiers:  []string{hostname},
-		Name:         hostname,
-		Manufacturer: "Apple",
-		Model:        model,
-	}
-
-	// Battery sensor
-	batteryConfig := SensorConfig{
-		Name:              hostname + " Battery Level",
-		StateTopic:        topicPrefix + "/state/battery",
-		UniqueID:          hostname + "_battery",
-		UnitOfMeasurement: "%",
-		DeviceClass:       "battery",
-		Device:            device,
+	publishState(client, "volume", getTopicPrefix()+"/state/volume", strconv.Itoa(volume))
+}
+
+func updateMute(client mqtt.Client) {
+	muted, err := getMuteStatus()
+	if err != nil {
+		reportError(client, "updating mute", err)
+		return
 	}
-	publishConfig(client, "sensor", hostname+"_battery", batteryConfig)
 
-	// Power adapter binary sensor
-	powerAdapterConfig := BinarySensorConfig{
-		Name:        hostname + " Power Adapter",
-		StateTopic:  topicPrefix + "/state/power_adapter",
-  		PayloadOn:   "true",
-  		PayloadOff:  "false",
-		UniqueID:    hostname + "_power_adapter",
-		DeviceClass: "plug",
-		Device:      device,
+	publishState(client, "mute", getTopicPrefix()+"/state/mute", strconv.FormatBool(muted))
+}
+
+func getDevice() Device {
+	identifiers := []string{hostname}
+	if platformUUID != "" {
+		// unlike the hostname, it can't be the same on two Macs
+		identifiers = append(identifiers, platformUUID)
 	}
-	publishConfig(client, "binary_sensor", hostname+"_power_adapter", powerAdapterConfig)
+
+	swVersion := "mac2mqtt " + version
+	if hardware.OSVersion != "" {
+		swVersion = "macOS " + hardware.OSVersion + ", " + swVersion
+	}
+
+	return Device{
+		Identifiers:      identifiers,
+		Name:             hostname,
+		Manufacturer:     "Apple",
+		Model:            hardware.Model,
+		ModelID:          hardware.ModelID,
+		SerialNumber:     hardware.SerialNumber,
+		SwVersion:        swVersion,
+		ConfigurationURL: "https://github.com/bessarabov/mac2mqtt",
+	}
+}
+
+func publishHADiscoveryConfig(client mqtt.Client) {
+	publishSensorsDiscoveryConfig(client)
+}
+
+func publishVolumeDiscoveryConfig(client mqtt.Client) {
+	topicPrefix := getTopicPrefix()
+	availabilityTopic := getAvailabilityTopic()
+
+	device := getDevice()
 
 	// Volume control (number entity) - includes state feedback
 	volumeNumberConfig := NumberConfig{
-		Name:         hostname + " Volume",
-		CommandTopic: topicPrefix + "/command/volume",
-		StateTopic:   topicPrefix + "/state/volume",
-		UniqueID:     hostname + "_volume",
-		Min:          0,
-		Max:          100,
-		Device:       device,
+		Name:              hostname + " Volume",
+		Icon:              "mdi:volume-high",
+		CommandTopic:      topicPrefix + "/command/volume",
+		StateTopic:        topicPrefix + "/state/volume",
+		UniqueID:          hostname + "_volume",
+		Min:               0,
+		Max:               100,
+		AvailabilityTopic: availabilityTopic,
+		Device:            device,
 	}
 	publishConfig(client, "number", hostname+"_volume", volumeNumberConfig)
 
-	// Mute Button with state feedback
+	// Volume step buttons
+	volumeUpButtonConfig := ButtonConfig{
+		Name:              hostname + " Volume Up",
+		Icon:              "mdi:volume-plus",
+		CommandTopic:      topicPrefix + "/command/volume_up",
+		UniqueID:          hostname + "_volume_up",
+		AvailabilityTopic: availabilityTopic,
+		Device:            device,
+	}
+	publishConfig(client, "button", hostname+"_volume_up", volumeUpButtonConfig)
+
+	volumeDownButtonConfig := ButtonConfig{
+		Name:              hostname + " Volume Down",
+		Icon:              "mdi:volume-minus",
+		CommandTopic:      topicPrefix + "/command/volume_down",
+		UniqueID:          hostname + "_volume_down",
+		AvailabilityTopic: availabilityTopic,
+		Device:            device,
+	}
+	publishConfig(client, "button", hostname+"_volume_down", volumeDownButtonConfig)
+
+	// Mute Button, it only turns mute on. The current state is sent to PREFIX/state/mute
 	muteButtonConfig := ButtonConfig{
-		Name:         hostname + " Mute",
-		CommandTopic: topicPrefix + "/command/mute",
-		PayloadPress: "true",
-		UniqueID:     hostname + "_mute",
-		Device:       device,
+		Name:              hostname + " Mute",
+		Icon:              "mdi:volume-off",
+		CommandTopic:      topicPrefix + "/command/mute",
+		PayloadPress:      "true",
+		UniqueID:          hostname + "_mute",
+		AvailabilityTopic: availabilityTopic,
+		Device:            device,
 	}
 	publishConfig(client, "button", hostname+"_mute", muteButtonConfig)
+}
+
+func publishSleepDiscoveryConfig(client mqtt.Client) {
+	topicPrefix := getTopicPrefix()
+	availabilityTopic := getAvailabilityTopic()
 
-	// Sleep command Button with state feedback
+	device := getDevice()
+
+	// Sleep, display sleep, shutdown and restart are one-shot commands, so they
+	// are buttons: a switch would stay "on" forever because there is no state
+	// to report back
 	sleepButtonConfig := ButtonConfig{
-		Name:         hostname + " Sleep",
-		CommandTopic: topicPrefix + "/command/sleep",
-  		PayloadPress: "sleep",
-		UniqueID:     hostname + "_sleep",
-		Device:       device,
+		Name:              hostname + " Sleep",
+		Icon:              "mdi:sleep",
+		CommandTopic:      topicPrefix + "/command/sleep",
+		PayloadPress:      "sleep",
+		UniqueID:          hostname + "_sleep",
+		AvailabilityTopic: availabilityTopic,
+		Device:            device,
 	}
 	publishConfig(client, "button", hostname+"_sleep", sleepButtonConfig)
 
-	// Display sleep command Button with state feedback
 	displaySleepButtonConfig := ButtonConfig{
-		Name:         hostname + " Display Sleep",
-		CommandTopic: topicPrefix + "/command/displaysleep",
-  		PayloadPress: "displaysleep",
-		UniqueID:     hostname + "_display_sleep",
-		Device:       device,
+		Name:              hostname + " Display Sleep",
+		Icon:              "mdi:monitor-off",
+		CommandTopic:      topicPrefix + "/command/displaysleep",
+		PayloadPress:      "displaysleep",
+		UniqueID:          hostname + "_display_sleep",
+		AvailabilityTopic: availabilityTopic,
+		Device:            device,
 	}
 	publishConfig(client, "button", hostname+"_display_sleep", displaySleepButtonConfig)
 
-	// Shutdown command Button with state feedback
+	publishLegacySwitchConfig(client, "Sleep", "sleep", "mdi:sleep", hostname+"_sleep")
+	publishLegacySwitchConfig(client, "Display Sleep", "displaysleep", "mdi:monitor-off", hostname+"_display_sleep")
+}
+
+// Switch with the same topic and payload as a one-shot command button. It has
+// no state, so Home Assistant shows it as on after it is turned on, like the
+// old switches did. Without legacy_switches the configs are removed
+func publishLegacySwitchConfig(client mqtt.Client, name string, command string, icon string, objectId string) {
+	if !settings.LegacySwitches {
+		client = configRemover{client}
+	}
+
+	legacySwitchConfig := SwitchConfig{
+		Name:              hostname + " " + name,
+		Icon:              icon,
+		CommandTopic:      getTopicPrefix() + "/command/" + command,
+		PayloadOn:         command,
+		UniqueID:          objectId,
+		AvailabilityTopic: getAvailabilityTopic(),
+		Device:            getDevice(),
+	}
+	publishConfig(client, "switch", objectId, legacySwitchConfig)
+}
+
+func publishShutdownDiscoveryConfig(client mqtt.Client) {
+	topicPrefix := getTopicPrefix()
+	availabilityTopic := getAvailabilityTopic()
+
+	device := getDevice()
+
 	shutdownButtonConfig := ButtonConfig{
-		Name:         hostname + " Shutdown",
-		CommandTopic: topicPrefix + "/command/shutdown",
-  		PayloadPress: "shutdown",
-		UniqueID:     hostname + "_shutdown",
-		Device:       device,
+		Name:              hostname + " Shutdown",
+		Icon:              "mdi:power",
+		CommandTopic:      topicPrefix + "/command/shutdown",
+		PayloadPress:      "shutdown",
+		UniqueID:          hostname + "_shutdown",
+		AvailabilityTopic: availabilityTopic,
+		Device:            device,
 	}
 	publishConfig(client, "button", hostname+"_shutdown", shutdownButtonConfig)
 
-	
+	publishLegacySwitchConfig(client, "Shutdown", "shutdown", "mdi:power", hostname+"_shutdown")
+}
+
+func publishRestartDiscoveryConfig(client mqtt.Client) {
+	topicPrefix := getTopicPrefix()
+	availabilityTopic := getAvailabilityTopic()
+
+	device := getDevice()
+
+	restartButtonConfig := ButtonConfig{
+		Name:              hostname + " Restart",
+		Icon:              "mdi:restart",
+		CommandTopic:      topicPrefix + "/command/restart",
+		PayloadPress:      "restart",
+		UniqueID:          hostname + "_restart",
+		DeviceClass:       "restart",
+		AvailabilityTopic: availabilityTopic,
+		Device:            device,
+	}
+	publishConfig(client, "button", hostname+"_restart", restartButtonConfig)
+
+	publishLegacySwitchConfig(client, "Restart", "restart", "mdi:restart", hostname+"_restart")
+}
+
+// Object ids that already have a discovery config published, so that
+// entities which appear after start (new volumes, Bluetooth devices) get
+// announced on their first update
+var publishedConfigs = map[string]bool{}
+var publishedConfigsMutex sync.Mutex
+
+func isConfigPublished(objectId string) bool {
+	publishedConfigsMutex.Lock()
+	defer publishedConfigsMutex.Unlock()
+
+	return publishedConfigs[objectId]
 }
 
+// Discovery topics have the node_id part, so configs of different Macs are in
+// different subtrees: homeassistant/COMPONENT/HOSTNAME/OBJECT_ID/config
 func publishConfig(client mqtt.Client, component string, objectId string, config interface{}) {
-	configTopic := fmt.Sprintf("homeassistant/%s/%s/config", component, objectId)
+	configTopic := fmt.Sprintf("homeassistant/%s/%s/%s/config", component, hostname, objectId)
 	configBytes, err := json.Marshal(config)
 	if err != nil {
-		log.Printf("Error marshaling config: %v", err)
+		logErrorf("Error marshaling config: %v", err)
 		return
 	}
 
-	token := client.Publish(configTopic, 0, true, configBytes)
+	// Older versions published configs without node_id. The retained config
+	// there is removed, otherwise Home Assistant sees two configs with the same unique_id
+	legacyTopic := fmt.Sprintf("homeassistant/%s/%s/config", component, objectId)
+	token := client.Publish(legacyTopic, settings.QoS.Discovery, true, "")
 	if !token.WaitTimeout(tokenTimeOut) {
-		log.Printf("Publish config timed out after %v", tokenTimeOut)
+		logWarnf("Removing config timed out after %v", tokenTimeOut)
 	} else if token.Error() != nil {
-		log.Printf("Error publishing config: %v", token.Error())
+		logErrorf("Error removing config: %v", token.Error())
+	}
+
+	// an empty config removes the entity of a turned off feature
+	if _, remove := client.(configRemover); remove {
+		token = client.Publish(configTopic, settings.QoS.Discovery, true, "")
+		if !token.WaitTimeout(tokenTimeOut) {
+			logWarnf("Removing config timed out after %v", tokenTimeOut)
+		} else if token.Error() != nil {
+			logErrorf("Error removing config: %v", token.Error())
+		}
+		return
+	}
+
+	publishedConfigsMutex.Lock()
+	publishedConfigs[objectId] = true
+	publishedConfigsMutex.Unlock()
+
+	token = client.Publish(configTopic, settings.QoS.Discovery, true, configBytes)
+	if !token.WaitTimeout(tokenTimeOut) {
+		logWarnf("Publish config timed out after %v", tokenTimeOut)
+	} else if token.Error() != nil {
+		logErrorf("Error publishing config: %v", token.Error())
 	} else {
-		log.Printf("Published %s config to %s", component, configTopic)
+		logDebugf("Published %s config to %s", component, configTopic)
+	}
+}
+
+// Reads the Mac's hardware info and looks for the optional tools
+func setupHost(c *config) {
+	hostname = c.Hostname
+	baseTopic = c.TopicPrefix
+
+	hw, err := getHardwareInfo()
+	if err != nil {
+		logErrorf("Error reading hardware info: %v", err)
+	}
+	hardware = hw
+
+	uuid, err := getPlatformUUID()
+	if err != nil {
+		logErrorf("Error reading hardware UUID: %v", err)
+	}
+	platformUUID = uuid
+
+	brightnessPath = findTool("brightness")
+	if brightnessPath == "" {
+		logInfof("brightness tool is not installed, display brightness control is disabled")
+	}
+
+	keyboardBacklightPath = findTool("mac-brightnessctl")
+	if keyboardBacklightPath == "" {
+		logInfof("mac-brightnessctl tool is not installed, keyboard backlight control is disabled")
+	}
+
+	nowPlayingPath = findTool("nowplaying-cli")
+	if nowPlayingPath == "" {
+		logInfof("nowplaying-cli tool is not installed, now playing sensors are disabled")
+	}
+
+	blueutilPath = findTool("blueutil")
+	if blueutilPath == "" {
+		logInfof("blueutil tool is not installed, Bluetooth entities are disabled")
+	}
+
+	hasBattery = isBatteryPresent()
+	if !hasBattery {
+		logInfof("This Mac has no battery, battery sensors are disabled")
+	}
+
+	bclmPath = findTool("bclm")
+	if bclmPath == "" {
+		logInfof("bclm tool is not installed, charge limit control is disabled")
+	}
+
+	switchAudioSourcePath = findTool("SwitchAudioSource")
+	if switchAudioSourcePath == "" {
+		logInfof("SwitchAudioSource tool is not installed, audio device selection is disabled")
+	}
+
+	nightlightPath = findTool("nightlight")
+	if nightlightPath == "" {
+		logInfof("nightlight tool is not installed, Night Shift control is disabled")
+	}
+
+	smartctlPath = findTool("smartctl")
+	if smartctlPath == "" {
+		logInfof("smartctl tool is not installed, only SMART status of the SSD is published")
+	}
+
+	dockerPath = findDocker()
+	if dockerPath == "" {
+		logInfof("Docker and OrbStack are not installed, docker sensors are disabled")
+	}
+
+	if !isPowerUsageAvailable() {
+		logInfof("mac2mqtt is not run as root, power usage sensor is disabled")
+	}
+
+	if c.Webcam {
+		imagesnapPath = findTool("imagesnap")
+		if imagesnapPath == "" {
+			logInfof("imagesnap tool is not installed, webcam camera is disabled")
+		}
 	}
 }
 
 func main() {
 
-	log.Println("Started")
+	// mac2mqtt [install-service | uninstall-service | menubar | validate | sign] [-config PATH] [ARGS]
+	args := os.Args[1:]
+	subcommand := ""
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		subcommand, args = args[0], args[1:]
+	}
 
-	var c config
-	c.getConfig()
+	configPath := flag.String("config", "", "path to mac2mqtt.yaml")
+	logLevel := flag.String("log-level", "", "debug, info, warn or error, overrides log.level in mac2mqtt.yaml")
+	flag.BoolVar(&dryRun, "dry-run", false, "log sleep, shutdown, restart, lock screen, shortcuts, commands and keys instead of running them")
+	flag.CommandLine.Parse(args)
+
+	switch subcommand {
+	case "":
+	case "install-service":
+		if err := installService(*configPath); err != nil {
+			log.Fatal(err)
+		}
+		return
+	case "uninstall-service":
+		if err := uninstallService(); err != nil {
+			log.Fatal(err)
+		}
+		return
+	case "menubar":
+		if err := runMenuBar(flag.Arg(0), *configPath); err != nil {
+			log.Fatal(err)
+		}
+		return
+	case "validate":
+		if err := validate(*configPath); err != nil {
+			log.Fatal(err)
+		}
+		return
+	case "sign":
+		settings.getConfig(*configPath)
+		payload, err := signCommand(flag.Arg(0), flag.Arg(1))
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(string(payload))
+		return
+	default:
+		log.Fatalf("Unknown command %q, known commands are install-service, uninstall-service, menubar, validate and sign", subcommand)
+	}
+
+	c := settings.getConfig(*configPath)
+
+	if *logLevel != "" {
+		c.Log.Level = *logLevel
+	}
+	if err := setupLogging(c.Log); err != nil {
+		log.Fatalf("Incorrect log settings: %v", err)
+	}
+
+	logInfof("Started")
+	if dryRun {
+		logInfof("Dry run, sleep, shutdown, restart, lock screen, shortcuts, commands and keys are only logged")
+	}
 
 	var wg sync.WaitGroup
 
-	hostname = "MacBookPRO_M2"
+	setupHost(c)
+
+	// SIGINT is Ctrl+C, SIGTERM is sent by launchd when the job is unloaded
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	connectBrokers(c.Brokers)
+	mqttClient := withBrokers(getMQTTClient(getBrokerURL(), c.User, c.Password))
+
+	stateRefreshTicker := time.NewTicker(stateRefreshInterval)
 
-	model = hostname
+	if isFeatureEnabled("volume") {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			watchVolume(ctx, mqttClient)
+		}()
+	}
+
+	if isFeatureEnabled("av_usage") {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			watchAVUsage(ctx, mqttClient)
+		}()
+	}
 
-	mqttClient := getMQTTClient(c.Ip, c.Port, c.User, c.Password)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		watchSleepWake(ctx, mqttClient)
+	}()
 
-	volumeTicker := time.NewTicker(2 * time.Second)
-	batteryTicker := time.NewTicker(60 * time.Second)
+	if isFeatureEnabled("events") {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			watchEvents(ctx, mqttClient)
+		}()
+	}
 
 	wg.Add(1)
 	go func() {
+		defer wg.Done()
+
 		for {
 			select {
-			case _ = <-volumeTicker.C:
-				updateVolume(mqttClient)
-				updateMute(mqttClient)
+			case <-ctx.Done():
+				stateRefreshTicker.Stop()
+				return
 
-			case _ = <-batteryTicker.C:
-				updateBattery(mqttClient)
-				// Power adapter status is now published together with battery info
+			case _ = <-stateRefreshTicker.C:
+				resetStateCache()
 			}
 		}
 	}()
 
+	runSensors(ctx, mqttClient, &wg)
+
+	if settings.HTTP.Enabled {
+		runHTTPServer(ctx, mqttClient, &wg)
+	}
+
+	<-ctx.Done()
+	logInfof("Stopping")
+
 	wg.Wait()
 
+	if err := setCaffeinate(false); err != nil {
+		logErrorf("Error %v", err)
+	}
+
+	// the broker sends the Last Will only when the connection drops, not on a clean disconnect
+	updateAvailability(mqttClient, false)
+	mqttClient.Disconnect(250)
+
+	logInfof("Stopped")
 }
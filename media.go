@@ -0,0 +1,281 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// Path to the `nowplaying-cli` tool (https://github.com/kirtan-shah/nowplaying-cli),
+// empty if it is not installed and now playing sensors are disabled
+var nowPlayingPath string
+
+type nowPlaying struct {
+	// "playing", "paused" or "idle" when nothing is loaded
+	State  string `json:"state"`
+	Title  string `json:"title"`
+	Artist string `json:"artist"`
+	Album  string `json:"album"`
+	// App that plays the media, e.g. "Spotify" and "com.spotify.client"
+	App         string `json:"app"`
+	AppBundleID string `json:"app_bundle_id"`
+}
+
+// App names by bundle id, apps are looked up once. It is used by polls and
+// by command handlers, so it is guarded by appNamesMutex
+var appNames = map[string]string{}
+var appNamesMutex sync.Mutex
+
+// Name of the app with the bundle id, the bundle id itself if the app
+// can't be found
+func getAppName(bundleID string) string {
+	appNamesMutex.Lock()
+	name, found := appNames[bundleID]
+	appNamesMutex.Unlock()
+
+	if found {
+		return name
+	}
+
+	name = bundleID
+
+	// $ mdfind "kMDItemCFBundleIdentifier == 'com.spotify.client'"
+	// /Applications/Spotify.app
+	output, err := getCommandOutput("/usr/bin/mdfind", "kMDItemCFBundleIdentifier == '"+strings.ReplaceAll(bundleID, "'", "")+"'")
+	if err == nil && output != "" {
+		path, _, _ := strings.Cut(output, "\n")
+		name = strings.TrimSuffix(filepath.Base(path), ".app")
+	}
+
+	appNamesMutex.Lock()
+	appNames[bundleID] = name
+	appNamesMutex.Unlock()
+
+	return name
+}
+
+// Payloads accepted by PREFIX/command/nowplaying mapped to nowplaying-cli commands
+var nowPlayingCommands = map[string]string{
+	"play":      "play",
+	"pause":     "pause",
+	"playpause": "togglePlayPause",
+	"next":      "next",
+	"previous":  "previous",
+}
+
+func getNowPlaying() (nowPlaying, error) {
+	output, err := getCommandOutput(nowPlayingPath, "get", "title", "artist", "album", "playbackRate", "clientBundleIdentifier")
+	if err != nil {
+		return nowPlaying{}, err
+	}
+
+	// $ nowplaying-cli get title artist album playbackRate clientBundleIdentifier
+	// Bohemian Rhapsody
+	// Queen
+	// A Night at the Opera
+	// 1
+	// com.spotify.client
+	//
+	// Every missing value is printed as "null"
+
+	values := strings.Split(output, "\n")
+	for len(values) < 5 {
+		values = append(values, "null")
+	}
+	for i, v := range values {
+		if v == "null" {
+			values[i] = ""
+		}
+	}
+
+	np := nowPlaying{
+		Title:  values[0],
+		Artist: values[1],
+		Album:  values[2],
+	}
+
+	if values[4] != "" {
+		np.AppBundleID = values[4]
+		np.App = getAppName(values[4])
+	}
+
+	switch {
+	case values[3] == "":
+		np.State = "idle"
+	case values[3] == "0":
+		np.State = "paused"
+	default:
+		np.State = "playing"
+	}
+
+	return np, nil
+}
+
+func commandNowPlaying(command string) error {
+	return runCommand(nowPlayingPath, nowPlayingCommands[command])
+}
+
+// Media keys from IOKit/hidsystem/ev_keymap.h
+var mediaKeys = map[string]int{
+	"playpause": 16, // NX_KEYTYPE_PLAY
+	"next":      17, // NX_KEYTYPE_NEXT
+	"previous":  18, // NX_KEYTYPE_PREVIOUS
+}
+
+// Media keys are not regular key codes, so System Events "key code" can't press
+// them. Instead a system defined NSEvent is posted in the same way as the
+// keyboard does it. This requires mac2mqtt to be allowed in
+// Privacy & Security > Accessibility
+const mediaKeyScript = `ObjC.import('Cocoa');
+ObjC.import('CoreGraphics');
+function post(down) {
+	var flags = down ? 0xa00 : 0xb00;
+	var ev = $.NSEvent.otherEventWithTypeLocationModifierFlagsTimestampWindowNumberContextSubtypeData1Data2(
+		14, $.NSMakePoint(0, 0), flags, 0, 0, null, 8, (%d << 16) | flags, -1);
+	$.CGEventPost(0, ev.CGEvent);
+}
+post(true);
+post(false);`
+
+func commandMediaKey(key string) error {
+	return runCommand("/usr/bin/osascript", "-l", "JavaScript", "-e", fmt.Sprintf(mediaKeyScript, mediaKeys[key]))
+}
+
+func updateNowPlaying(client mqtt.Client) {
+	np, err := getNowPlaying()
+	if err != nil {
+		reportError(client, "updating now playing", err)
+		return
+	}
+
+	payload, err := json.Marshal(np)
+	if err != nil {
+		logErrorf("Error marshaling now playing: %v", err)
+		return
+	}
+
+	publishState(client, "now playing", getTopicPrefix()+"/state/now_playing", payload)
+}
+
+func publishMediaDiscoveryConfig(client mqtt.Client) {
+	topicPrefix := getTopicPrefix()
+	device := getDevice()
+
+	// With nowplaying-cli the same buttons come from the nowplaying command below,
+	// so the media key buttons are shown only without it
+	mediaKeyClient := client
+	if nowPlayingPath != "" {
+		mediaKeyClient = configRemover{client}
+	}
+
+	for _, b := range []struct{ key, name, icon string }{
+		{"playpause", "Media Play/Pause", "mdi:play-pause"},
+		{"next", "Media Next", "mdi:skip-next"},
+		{"previous", "Media Previous", "mdi:skip-previous"},
+	} {
+		mediaKeyButtonConfig := ButtonConfig{
+			Name:              hostname + " " + b.name,
+			Icon:              b.icon,
+			CommandTopic:      topicPrefix + "/command/" + b.key,
+			PayloadPress:      b.key,
+			UniqueID:          hostname + "_media_" + b.key,
+			AvailabilityTopic: getAvailabilityTopic(),
+			Device:            device,
+		}
+		publishConfig(mediaKeyClient, "button", hostname+"_media_"+b.key, mediaKeyButtonConfig)
+	}
+
+	if nowPlayingPath != "" {
+		nowPlayingConfig := SensorConfig{
+			Name:                hostname + " Now Playing",
+			Icon:                "mdi:music",
+			StateTopic:          topicPrefix + "/state/now_playing",
+			UniqueID:            hostname + "_now_playing",
+			ValueTemplate:       "{{ value_json.title }}",
+			JsonAttributesTopic: topicPrefix + "/state/now_playing",
+			ExpireAfter:         expireAfter(mediaInterval),
+			AvailabilityTopic:   getAvailabilityTopic(),
+			Device:              device,
+		}
+		publishConfig(client, "sensor", hostname+"_now_playing", nowPlayingConfig)
+
+		playbackStateConfig := SensorConfig{
+			Name:              hostname + " Playback State",
+			Icon:              "mdi:play-circle",
+			StateTopic:        topicPrefix + "/state/now_playing",
+			UniqueID:          hostname + "_playback_state",
+			ValueTemplate:     "{{ value_json.state }}",
+			ExpireAfter:       expireAfter(mediaInterval),
+			AvailabilityTopic: getAvailabilityTopic(),
+			Device:            device,
+		}
+		publishConfig(client, "sensor", hostname+"_playback_state", playbackStateConfig)
+
+		for _, b := range []struct{ payload, name, icon string }{
+			{"playpause", "Play/Pause", "mdi:play-pause"},
+			{"next", "Next Track", "mdi:skip-next"},
+			{"previous", "Previous Track", "mdi:skip-previous"},
+		} {
+			buttonConfig := ButtonConfig{
+				Name:              hostname + " " + b.name,
+				Icon:              b.icon,
+				CommandTopic:      topicPrefix + "/command/nowplaying",
+				PayloadPress:      b.payload,
+				UniqueID:          hostname + "_nowplaying_" + b.payload,
+				AvailabilityTopic: getAvailabilityTopic(),
+				Device:            device,
+			}
+			publishConfig(client, "button", hostname+"_nowplaying_"+b.payload, buttonConfig)
+		}
+	}
+}
+
+func init() {
+	registerSensor(sensorFuncs{
+		name:     "media",
+		interval: mediaInterval,
+		poll: func(client mqtt.Client) {
+			if nowPlayingPath != "" {
+				updateNowPlaying(client)
+			}
+		},
+		discovery: publishMediaDiscoveryConfig,
+	})
+
+	registerCommand("media", "nowplaying", func(client mqtt.Client, command string, payload string) {
+		if nowPlayingPath == "" {
+			return
+		}
+
+		if _, ok := nowPlayingCommands[payload]; !ok {
+			logWarnf("Incorrect now playing command")
+			return
+		}
+
+		if err := commandNowPlaying(payload); err != nil {
+			reportError(client, "sending now playing command", err)
+		}
+
+		time.Sleep(1 * time.Second)
+
+		updateNowPlaying(client)
+	})
+
+	mediaKeyCommand := func(client mqtt.Client, command string, payload string) {
+		if payload != command {
+			return
+		}
+
+		if err := commandMediaKey(command); err != nil {
+			reportError(client, "pressing media key", err)
+		}
+	}
+	registerCommand("media", "playpause", mediaKeyCommand)
+	registerCommand("media", "next", mediaKeyCommand)
+	registerCommand("media", "previous", mediaKeyCommand)
+}
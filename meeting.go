@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// Conferencing apps by the names of their processes in `ps -c`. Zoom starts
+// CptHost only for the time of a meeting
+var meetingApps = []struct {
+	name      string
+	processes []string
+	// the process runs only during a meeting
+	inMeeting bool
+}{
+	{"Zoom", []string{"CptHost"}, true},
+	{"Zoom", []string{"zoom.us"}, false},
+	{"Microsoft Teams", []string{"MSTeams", "Microsoft Teams", "Microsoft Teams (work or school)"}, false},
+	{"Webex", []string{"Webex", "Cisco Webex Meetings"}, false},
+	{"Slack", []string{"Slack"}, false},
+	{"FaceTime", []string{"FaceTime"}, false},
+}
+
+type meetingState struct {
+	InMeeting bool `json:"in_meeting"`
+	// Name of the conferencing app, empty when there is no meeting
+	App string `json:"app"`
+}
+
+func getRunningProcesses() (map[string]bool, error) {
+	output, err := getCommandOutput("/bin/ps", "-Aco", "comm=")
+	if err != nil {
+		return nil, err
+	}
+
+	// $ /bin/ps -Aco comm=
+	// launchd
+	// zoom.us
+	// CptHost
+
+	processes := map[string]bool{}
+	for _, line := range strings.Split(output, "\n") {
+		processes[strings.TrimSpace(line)] = true
+	}
+
+	return processes, nil
+}
+
+// A meeting is a conferencing app running while the camera or the
+// microphone is in use, the app itself doesn't tell it
+func getMeetingState() (meetingState, error) {
+	processes, err := getRunningProcesses()
+	if err != nil {
+		return meetingState{}, err
+	}
+
+	usage := getAVUsage()
+	avInUse := usage.Camera || usage.Microphone
+
+	for _, app := range meetingApps {
+		for _, process := range app.processes {
+			if processes[process] && (app.inMeeting || avInUse) {
+				return meetingState{InMeeting: true, App: app.name}, nil
+			}
+		}
+	}
+
+	for _, process := range settings.MeetingApps {
+		if processes[process] && avInUse {
+			return meetingState{InMeeting: true, App: process}, nil
+		}
+	}
+
+	return meetingState{}, nil
+}
+
+func updateMeeting(client mqtt.Client) {
+	state, err := getMeetingState()
+	if err != nil {
+		reportError(client, "updating meeting", err)
+		return
+	}
+
+	payload, err := json.Marshal(state)
+	if err != nil {
+		logErrorf("Error marshaling meeting: %v", err)
+		return
+	}
+
+	publishState(client, "meeting", getTopicPrefix()+"/state/meeting", payload)
+}
+
+func publishMeetingDiscoveryConfig(client mqtt.Client) {
+	inMeetingConfig := BinarySensorConfig{
+		Name:                hostname + " In Meeting",
+		Icon:                "mdi:account-voice",
+		StateTopic:          getTopicPrefix() + "/state/meeting",
+		ValueTemplate:       "{{ 'ON' if value_json.in_meeting else 'OFF' }}",
+		JsonAttributesTopic: getTopicPrefix() + "/state/meeting",
+		UniqueID:            hostname + "_in_meeting",
+		ExpireAfter:         expireAfter(screenInterval),
+		AvailabilityTopic:   getAvailabilityTopic(),
+		Device:              getDevice(),
+	}
+	publishConfig(client, "binary_sensor", hostname+"_in_meeting", inMeetingConfig)
+}
+
+func init() {
+	registerSensor(sensorFuncs{
+		name:      "meeting",
+		interval:  screenInterval,
+		poll:      updateMeeting,
+		discovery: publishMeetingDiscoveryConfig,
+	})
+}
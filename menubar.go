@@ -0,0 +1,238 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// The menu bar is shown by SwiftBar (https://swiftbar.app) or xbar
+// (https://xbarapp.com) which run `mac2mqtt menubar` every few seconds. A
+// native status item would need cgo, so the running daemon writes its status
+// to a file that the plugin reads, and the plugin pauses the daemon by
+// creating another file
+
+type menuBarStatus struct {
+	Connected bool   `json:"connected"`
+	Updated   string `json:"updated"`
+	// Last published value of every state topic, without the topic prefix
+	States map[string]string `json:"states"`
+}
+
+func getMenuBarDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+
+	return filepath.Join(dir, "mac2mqtt")
+}
+
+func getMenuBarStatusPath() string {
+	return filepath.Join(getMenuBarDir(), "status.json")
+}
+
+func getPausePath() string {
+	return filepath.Join(getMenuBarDir(), "paused")
+}
+
+// While the bridge is paused states are not published and commands are ignored
+func isPaused() bool {
+	_, err := os.Stat(getPausePath())
+	return err == nil
+}
+
+func setPaused(b bool) error {
+	if !b {
+		err := os.Remove(getPausePath())
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	if err := os.MkdirAll(getMenuBarDir(), 0755); err != nil {
+		return err
+	}
+
+	return os.WriteFile(getPausePath(), nil, 0644)
+}
+
+// The file is written only with menu_bar: true, without the plugin nobody reads it
+func writeMenuBarStatus(client mqtt.Client) {
+	if !settings.MenuBar {
+		return
+	}
+
+	status := menuBarStatus{
+		Connected: client.IsConnectionOpen(),
+		Updated:   time.Now().UTC().Format(time.RFC3339),
+		States:    map[string]string{},
+	}
+
+	topicPrefix := getTopicPrefix() + "/"
+
+	stateCacheMutex.Lock()
+	for topic, value := range stateCache {
+		// images are of no use in a menu
+		if strings.HasPrefix(topic, topicPrefix+"camera/") {
+			continue
+		}
+		status.States[strings.TrimPrefix(topic, topicPrefix)] = value
+	}
+	stateCacheMutex.Unlock()
+
+	payload, err := json.Marshal(status)
+	if err != nil {
+		logErrorf("Error marshaling menu bar status: %v", err)
+		return
+	}
+
+	if err := os.MkdirAll(getMenuBarDir(), 0755); err != nil {
+		logErrorf("Error writing menu bar status: %v", err)
+		return
+	}
+
+	if err := os.WriteFile(getMenuBarStatusPath(), payload, 0644); err != nil {
+		logErrorf("Error writing menu bar status: %v", err)
+	}
+}
+
+// Menu items of SwiftBar and xbar can't contain "|", it starts item parameters
+func menuBarText(s string) string {
+	s = strings.ReplaceAll(s, "|", "/")
+	if r := []rune(s); len(r) > 60 {
+		s = string(r[:60]) + "…"
+	}
+	return s
+}
+
+// Prints the menu in the SwiftBar and xbar plugin format
+func printMenuBar(configPath string) {
+	var status menuBarStatus
+
+	content, err := os.ReadFile(getMenuBarStatusPath())
+	if err == nil {
+		err = json.Unmarshal(content, &status)
+	}
+
+	updated, _ := time.Parse(time.RFC3339, status.Updated)
+	// the daemon writes the file every 5 seconds
+	running := err == nil && time.Since(updated) < 30*time.Second
+
+	switch {
+	case !running:
+		fmt.Println("mac2mqtt ○")
+		fmt.Println("---")
+		fmt.Println("mac2mqtt is not running or menu_bar is off in mac2mqtt.yaml")
+	case isPaused():
+		fmt.Println("mac2mqtt ◐")
+		fmt.Println("---")
+		fmt.Println("Paused")
+	case status.Connected:
+		fmt.Println("mac2mqtt ●")
+		fmt.Println("---")
+		fmt.Println("Connected to MQTT")
+	default:
+		fmt.Println("mac2mqtt ○")
+		fmt.Println("---")
+		fmt.Println("Disconnected from MQTT")
+	}
+
+	if running && len(status.States) > 0 {
+		fmt.Println("---")
+
+		topics := make([]string, 0, len(status.States))
+		for topic := range status.States {
+			topics = append(topics, topic)
+		}
+		sort.Strings(topics)
+
+		for _, topic := range topics {
+			fmt.Printf("%s: %s | font=Menlo size=11\n", menuBarText(topic), menuBarText(status.States[topic]))
+		}
+	}
+
+	executable, err := os.Executable()
+	if err != nil {
+		return
+	}
+
+	action := func(title string, name string) {
+		args := []string{"menubar"}
+		if configPath != "" {
+			path, _ := filepath.Abs(configPath)
+			args = append(args, "-config", path)
+		}
+		args = append(args, name)
+
+		params := ""
+		for i, a := range args {
+			params += fmt.Sprintf(" param%d=%s", i+1, strconv.Quote(a))
+		}
+
+		fmt.Printf("%s | bash=%s%s terminal=false refresh=true\n", title, strconv.Quote(executable), params)
+	}
+
+	fmt.Println("---")
+	action("Toggle Mute", "toggle-mute")
+	action("Toggle Focus", "toggle-focus")
+	if isPaused() {
+		action("Resume Bridge", "resume")
+	} else {
+		action("Pause Bridge", "pause")
+	}
+}
+
+// mac2mqtt menubar [-config PATH] [toggle-mute | toggle-focus | pause | resume]
+func runMenuBar(action string, configPath string) error {
+	switch action {
+	case "":
+		printMenuBar(configPath)
+
+	case "toggle-mute":
+		muted, err := getMuteStatus()
+		if err != nil {
+			return err
+		}
+		return setMute(!muted)
+
+	case "toggle-focus":
+		settings.getConfig(configPath)
+		if !isFocusControlEnabled() {
+			return fmt.Errorf("focus shortcuts are not set in mac2mqtt.yaml")
+		}
+
+		focus, err := getFocusStatus()
+		if err != nil {
+			return err
+		}
+		return setFocus(!focus)
+
+	case "pause":
+		return setPaused(true)
+
+	case "resume":
+		return setPaused(false)
+
+	default:
+		return fmt.Errorf("unknown menubar action %q", action)
+	}
+
+	return nil
+}
+
+func init() {
+	registerSensor(sensorFuncs{
+		name:     "menu_bar",
+		interval: screenInterval,
+		poll:     writeMenuBarStatus,
+	})
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+const missionControlApp = "/System/Applications/Mission Control.app/Contents/MacOS/Mission Control"
+
+// Payloads of PREFIX/command/mission_control. The Mission Control app takes
+// an argument for its other views: 1 is Show Desktop and 2 is App Exposé
+var missionControlActions = []struct {
+	payload, name, icon string
+	command             []string
+}{
+	{"mission_control", "Mission Control", "mdi:view-dashboard-variant", []string{missionControlApp}},
+	{"show_desktop", "Show Desktop", "mdi:monitor", []string{missionControlApp, "1"}},
+	{"app_windows", "App Windows", "mdi:view-grid", []string{missionControlApp, "2"}},
+	{"launchpad", "Launchpad", "mdi:apps", []string{"/usr/bin/open", "-a", "Launchpad"}},
+}
+
+func publishMissionControlDiscoveryConfig(client mqtt.Client) {
+	topicPrefix := getTopicPrefix()
+	device := getDevice()
+
+	for _, action := range missionControlActions {
+		buttonConfig := ButtonConfig{
+			Name:              hostname + " " + action.name,
+			Icon:              action.icon,
+			CommandTopic:      topicPrefix + "/command/mission_control",
+			PayloadPress:      action.payload,
+			UniqueID:          hostname + "_" + action.payload,
+			AvailabilityTopic: getAvailabilityTopic(),
+			Device:            device,
+		}
+		publishConfig(client, "button", hostname+"_"+action.payload, buttonConfig)
+	}
+}
+
+func init() {
+	registerSensor(sensorFuncs{
+		name:      "mission_control",
+		discovery: publishMissionControlDiscoveryConfig,
+	})
+
+	registerCommand("mission_control", "mission_control", func(client mqtt.Client, command string, payload string) {
+		for _, action := range missionControlActions {
+			if action.payload != payload {
+				continue
+			}
+
+			// the app returns at once, the view stays until it is closed
+			if err := runCommand(action.command[0], action.command[1:]...); err != nil {
+				reportError(client, "opening "+action.name, err)
+			}
+			return
+		}
+
+		logWarnf("Incorrect mission control value")
+	})
+}
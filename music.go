@@ -0,0 +1,354 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// Player controlled with AppleScript, set with music_player in mac2mqtt.yaml.
+// Unlike nowplaying-cli and media keys it works with one app only, but it
+// can also shuffle, set the player volume and start playlists
+type musicPlayer struct {
+	App string
+	// AppleScript expressions for the current track t, they differ between
+	// the apps
+	DurationSeconds string
+	ArtworkURL      string
+	Shuffle         string
+	// Command that starts a playlist from the payload p
+	PlayPlaylist string
+}
+
+var musicPlayers = map[string]musicPlayer{
+	"Music": {
+		App:             "Music",
+		DurationSeconds: "duration of t",
+		// Music.app has only the image data of the artwork
+		ArtworkURL:   `""`,
+		Shuffle:      "shuffle enabled",
+		PlayPlaylist: "play playlist p",
+	},
+	"Spotify": {
+		App:             "Spotify",
+		DurationSeconds: "(duration of t) / 1000",
+		ArtworkURL:      "artwork url of t",
+		Shuffle:         "shuffling",
+		// Spotify can't find playlists by name, p is a Spotify URI
+		PlayPlaylist: "play track p",
+	},
+}
+
+// The app is not started when it is not running, "tell" inside the "if"
+// doesn't launch it
+const musicStateScript = `if application "%[1]s" is running then
+	tell application "%[1]s"
+		set s to player state as string
+		if s is "stopped" then return s
+		set t to current track
+		return s & linefeed & (name of t) & linefeed & (artist of t) & linefeed & (album of t) & linefeed & (%[2]s) & linefeed & player position & linefeed & (%[3]s) & linefeed & sound volume & linefeed & (%[4]s)
+	end tell
+end if
+return "closed"`
+
+type musicState struct {
+	Player string `json:"player"`
+	// "playing", "paused", "stopped" or "closed" when the app is not running
+	State  string `json:"state"`
+	Title  string `json:"title"`
+	Artist string `json:"artist"`
+	Album  string `json:"album"`
+	// seconds
+	Duration   int    `json:"duration"`
+	Position   int    `json:"position"`
+	Shuffle    bool   `json:"shuffle"`
+	Volume     int    `json:"volume"`
+	ArtworkURL string `json:"artwork_url"`
+}
+
+func getMusicPlayer() musicPlayer {
+	return musicPlayers[settings.MusicPlayer]
+}
+
+// AppleScript prints reals with the decimal separator of the user's locale
+func parseMusicSeconds(s string) int {
+	f, _ := strconv.ParseFloat(strings.Replace(s, ",", ".", 1), 64)
+	return int(math.Round(f))
+}
+
+func getMusicState() (musicState, error) {
+	p := getMusicPlayer()
+
+	script := fmt.Sprintf(musicStateScript, p.App, p.DurationSeconds, p.Shuffle, p.ArtworkURL)
+	output, err := getCommandOutput("/usr/bin/osascript", "-e", script)
+	if err != nil {
+		return musicState{}, err
+	}
+
+	// $ osascript -e '...'
+	// playing
+	// Bohemian Rhapsody
+	// Queen
+	// A Night at the Opera
+	// 354.32
+	// 42.5
+	// false
+	// 80
+	// https://i.scdn.co/image/ab67616d0000b273...
+
+	values := strings.Split(output, "\n")
+	state := musicState{Player: p.App, State: values[0]}
+	if len(values) < 9 {
+		return state, nil
+	}
+
+	state.Title = values[1]
+	state.Artist = values[2]
+	state.Album = values[3]
+	state.Duration = parseMusicSeconds(values[4])
+	state.Position = parseMusicSeconds(values[5])
+	state.Shuffle = values[6] == "true"
+	state.Volume, _ = strconv.Atoi(values[7])
+	state.ArtworkURL = values[8]
+
+	return state, nil
+}
+
+// Payloads accepted by PREFIX/command/music mapped to AppleScript commands
+var musicCommands = map[string]string{
+	"play":      "play",
+	"pause":     "pause",
+	"playpause": "playpause",
+	"next":      "next track",
+	"previous":  "previous track",
+}
+
+func runMusicScript(script string, arg ...string) error {
+	args := []string{"-e", "on run argv", "-e", "tell application " + strconv.Quote(getMusicPlayer().App), "-e", script, "-e", "end tell", "-e", "end run"}
+	return runCommand("/usr/bin/osascript", append(args, arg...)...)
+}
+
+func commandMusic(command string) error {
+	return runMusicScript(musicCommands[command])
+}
+
+func setMusicShuffle(b bool) error {
+	return runMusicScript(fmt.Sprintf("set %s to %t", getMusicPlayer().Shuffle, b))
+}
+
+// from 0 to 100
+func setMusicVolume(i int) error {
+	return runMusicScript("set sound volume to " + strconv.Itoa(i))
+}
+
+func playMusicPlaylist(name string) error {
+	// the name is passed as an argument, so it can't break the script
+	return runMusicScript("set p to item 1 of argv\n"+getMusicPlayer().PlayPlaylist, name)
+}
+
+func updateMusic(client mqtt.Client) {
+	if settings.MusicPlayer == "" {
+		return
+	}
+
+	state, err := getMusicState()
+	if err != nil {
+		reportError(client, "updating music", err)
+		return
+	}
+
+	payload, err := json.Marshal(state)
+	if err != nil {
+		logErrorf("Error marshaling music state: %v", err)
+		return
+	}
+
+	publishState(client, "music", getTopicPrefix()+"/state/music", payload)
+}
+
+func publishMusicDiscoveryConfig(client mqtt.Client) {
+	if settings.MusicPlayer == "" {
+		return
+	}
+
+	topicPrefix := getTopicPrefix()
+	device := getDevice()
+	app := getMusicPlayer().App
+
+	trackConfig := SensorConfig{
+		Name:                hostname + " " + app + " Track",
+		Icon:                "mdi:music",
+		StateTopic:          topicPrefix + "/state/music",
+		UniqueID:            hostname + "_music_track",
+		ValueTemplate:       "{{ value_json.title }}",
+		JsonAttributesTopic: topicPrefix + "/state/music",
+		ExpireAfter:         expireAfter(mediaInterval),
+		AvailabilityTopic:   getAvailabilityTopic(),
+		Device:              device,
+	}
+	publishConfig(client, "sensor", hostname+"_music_track", trackConfig)
+
+	stateConfig := SensorConfig{
+		Name:              hostname + " " + app + " State",
+		Icon:              "mdi:play-circle",
+		StateTopic:        topicPrefix + "/state/music",
+		UniqueID:          hostname + "_music_state",
+		DeviceClass:       "enum",
+		Options:           []string{"playing", "paused", "stopped", "closed"},
+		ValueTemplate:     "{{ value_json.state }}",
+		ExpireAfter:       expireAfter(mediaInterval),
+		AvailabilityTopic: getAvailabilityTopic(),
+		Device:            device,
+	}
+	publishConfig(client, "sensor", hostname+"_music_state", stateConfig)
+
+	for _, b := range []struct{ payload, name, icon string }{
+		{"playpause", "Play/Pause", "mdi:play-pause"},
+		{"next", "Next", "mdi:skip-next"},
+		{"previous", "Previous", "mdi:skip-previous"},
+	} {
+		buttonConfig := ButtonConfig{
+			Name:              hostname + " " + app + " " + b.name,
+			Icon:              b.icon,
+			CommandTopic:      topicPrefix + "/command/music",
+			PayloadPress:      b.payload,
+			UniqueID:          hostname + "_music_" + b.payload,
+			AvailabilityTopic: getAvailabilityTopic(),
+			Device:            device,
+		}
+		publishConfig(client, "button", hostname+"_music_"+b.payload, buttonConfig)
+	}
+
+	shuffleConfig := SwitchConfig{
+		Name:              hostname + " " + app + " Shuffle",
+		Icon:              "mdi:shuffle-variant",
+		StateTopic:        topicPrefix + "/state/music",
+		ValueTemplate:     "{{ 'true' if value_json.shuffle else 'false' }}",
+		CommandTopic:      topicPrefix + "/command/music_shuffle",
+		PayloadOn:         "true",
+		PayloadOff:        "false",
+		UniqueID:          hostname + "_music_shuffle",
+		AvailabilityTopic: getAvailabilityTopic(),
+		Device:            device,
+	}
+	publishConfig(client, "switch", hostname+"_music_shuffle", shuffleConfig)
+
+	volumeConfig := NumberConfig{
+		Name:              hostname + " " + app + " Volume",
+		Icon:              "mdi:volume-high",
+		CommandTopic:      topicPrefix + "/command/music_volume",
+		StateTopic:        topicPrefix + "/state/music",
+		ValueTemplate:     "{{ value_json.volume }}",
+		UniqueID:          hostname + "_music_volume",
+		Min:               0,
+		Max:               100,
+		AvailabilityTopic: getAvailabilityTopic(),
+		Device:            device,
+	}
+	publishConfig(client, "number", hostname+"_music_volume", volumeConfig)
+
+	playlistConfig := TextConfig{
+		Name:              hostname + " " + app + " Playlist",
+		Icon:              "mdi:playlist-music",
+		CommandTopic:      topicPrefix + "/command/music_playlist",
+		UniqueID:          hostname + "_music_playlist",
+		AvailabilityTopic: getAvailabilityTopic(),
+		Device:            device,
+	}
+	publishConfig(client, "text", hostname+"_music_playlist", playlistConfig)
+
+	if settings.MusicPlayer == "Spotify" {
+		artworkConfig := ImageConfig{
+			Name:              hostname + " " + app + " Artwork",
+			URLTopic:          topicPrefix + "/state/music",
+			URLTemplate:       "{{ value_json.artwork_url }}",
+			UniqueID:          hostname + "_music_artwork",
+			AvailabilityTopic: getAvailabilityTopic(),
+			Device:            device,
+		}
+		publishConfig(client, "image", hostname+"_music_artwork", artworkConfig)
+	}
+}
+
+func init() {
+	registerSensor(sensorFuncs{
+		name:      "music",
+		interval:  mediaInterval,
+		poll:      updateMusic,
+		discovery: publishMusicDiscoveryConfig,
+	})
+
+	registerCommand("music", "music", func(client mqtt.Client, command string, payload string) {
+		if settings.MusicPlayer == "" {
+			return
+		}
+
+		if _, ok := musicCommands[payload]; !ok {
+			logWarnf("Incorrect music command")
+			return
+		}
+
+		if err := commandMusic(payload); err != nil {
+			reportError(client, "sending music command", err)
+		}
+
+		time.Sleep(1 * time.Second)
+
+		updateMusic(client)
+	})
+
+	registerCommand("music", "music_shuffle", func(client mqtt.Client, command string, payload string) {
+		if settings.MusicPlayer == "" {
+			return
+		}
+
+		b, err := strconv.ParseBool(payload)
+		if err != nil {
+			logWarnf("Incorrect music shuffle value")
+			return
+		}
+
+		if err := setMusicShuffle(b); err != nil {
+			reportError(client, "setting music shuffle", err)
+		}
+
+		updateMusic(client)
+	})
+
+	registerCommand("music", "music_volume", func(client mqtt.Client, command string, payload string) {
+		if settings.MusicPlayer == "" {
+			return
+		}
+
+		i, err := strconv.Atoi(payload)
+		if err != nil || i < 0 || i > 100 {
+			logWarnf("Incorrect music volume value")
+			return
+		}
+
+		if err := setMusicVolume(i); err != nil {
+			reportError(client, "setting music volume", err)
+		}
+
+		updateMusic(client)
+	})
+
+	registerCommand("music", "music_playlist", func(client mqtt.Client, command string, payload string) {
+		if settings.MusicPlayer == "" || payload == "" {
+			return
+		}
+
+		if err := playMusicPlaylist(payload); err != nil {
+			reportError(client, "playing playlist", err)
+		}
+
+		time.Sleep(1 * time.Second)
+
+		updateMusic(client)
+	})
+}
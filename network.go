@@ -0,0 +1,357 @@
+package main
+
+import (
+	"encoding/json"
+	"regexp"
+	"strconv"
+	"strings"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+type wifiInfo struct {
+	Interface string `json:"interface"`
+	// empty when not connected. macOS 14.4+ shows "<redacted>" unless
+	// mac2mqtt is allowed to use Location Services
+	SSID    string `json:"ssid"`
+	RSSI    int    `json:"rssi"`
+	Channel string `json:"channel"`
+	IP      string `json:"ip"`
+}
+
+// Returns device name of the Wi-Fi interface, usually "en0"
+func getWifiInterface() (string, error) {
+	output, err := getCommandOutput("/usr/sbin/networksetup", "-listallhardwareports")
+	if err != nil {
+		return "", err
+	}
+
+	// $ /usr/sbin/networksetup -listallhardwareports
+	//
+	// Hardware Port: Wi-Fi
+	// Device: en0
+	// Ethernet Address: 3c:22:fb:00:00:00
+
+	r := regexp.MustCompile(`Hardware Port: (?:Wi-Fi|AirPort)\nDevice: (\S+)`)
+	m := r.FindStringSubmatch(output)
+	if m == nil {
+		return "", nil
+	}
+
+	return m[1], nil
+}
+
+// Returns IPv4 address of the interface or empty string if it has none
+func getInterfaceIP(iface string) string {
+	// ipconfig exits with error when there is no address, that is not a failure for us
+	output, err := getCommandOutput("/usr/sbin/ipconfig", "getifaddr", iface)
+	if err != nil {
+		return ""
+	}
+
+	return strings.TrimSpace(output)
+}
+
+type macAddresses struct {
+	// Address of the first Ethernet port, or of Wi-Fi if there is no Ethernet.
+	// Wake on LAN works best over Ethernet
+	Primary    string            `json:"primary"`
+	Interfaces map[string]string `json:"interfaces"`
+}
+
+func getMacAddresses() (macAddresses, error) {
+	output, err := getCommandOutput("/usr/sbin/networksetup", "-listallhardwareports")
+	if err != nil {
+		return macAddresses{}, err
+	}
+
+	// $ /usr/sbin/networksetup -listallhardwareports
+	//
+	// Hardware Port: Ethernet
+	// Device: en0
+	// Ethernet Address: 3c:22:fb:00:00:01
+	//
+	// Hardware Port: Wi-Fi
+	// Device: en1
+	// Ethernet Address: 3c:22:fb:00:00:02
+
+	r := regexp.MustCompile(`Hardware Port: (.+)\nDevice: (\S+)\nEthernet Address: ([0-9a-f:]{17})`)
+
+	addresses := macAddresses{Interfaces: map[string]string{}}
+	wifi := ""
+
+	for _, m := range r.FindAllStringSubmatch(output, -1) {
+		port, device, address := m[1], m[2], m[3]
+		addresses.Interfaces[device] = address
+
+		if strings.HasPrefix(port, "Ethernet") && addresses.Primary == "" {
+			addresses.Primary = address
+		}
+		if port == "Wi-Fi" {
+			wifi = address
+		}
+	}
+
+	if addresses.Primary == "" {
+		addresses.Primary = wifi
+	}
+
+	return addresses, nil
+}
+
+func updateMacAddresses(client mqtt.Client) {
+	addresses, err := getMacAddresses()
+	if err != nil {
+		reportError(client, "updating mac addresses", err)
+		return
+	}
+
+	payload, err := json.Marshal(addresses)
+	if err != nil {
+		logErrorf("Error marshaling mac addresses: %v", err)
+		return
+	}
+
+	publishState(client, "mac addresses", getTopicPrefix()+"/state/mac_addresses", payload)
+}
+
+// Hardware ports that are not a network link of their own
+var ignoredHardwarePorts = map[string]bool{
+	"Thunderbolt Bridge": true,
+	"Bluetooth PAN":      true,
+	"iPhone USB":         true,
+}
+
+// Interfaces with a link binary sensor, network.interfaces from
+// mac2mqtt.yaml or all Ethernet and Wi-Fi ports
+func getLinkInterfaces() ([]string, error) {
+	if len(settings.Network.Interfaces) > 0 {
+		return settings.Network.Interfaces, nil
+	}
+
+	output, err := getCommandOutput("/usr/sbin/networksetup", "-listallhardwareports")
+	if err != nil {
+		return nil, err
+	}
+
+	// $ /usr/sbin/networksetup -listallhardwareports
+	//
+	// Hardware Port: USB 10/100/1000 LAN
+	// Device: en5
+	// Ethernet Address: 3c:22:fb:00:00:03
+
+	r := regexp.MustCompile(`Hardware Port: (.+)\nDevice: (en\d+)`)
+
+	ifaces := []string{}
+	for _, m := range r.FindAllStringSubmatch(output, -1) {
+		if !ignoredHardwarePorts[m[1]] {
+			ifaces = append(ifaces, m[2])
+		}
+	}
+
+	return ifaces, nil
+}
+
+// true when the interface has a link, e.g. the Ethernet cable is plugged in
+func getInterfaceLink(iface string) bool {
+	// ifconfig exits with error when the interface doesn't exist, e.g. a USB
+	// adapter of an unplugged dock, that is no link
+	output, err := getCommandOutput("/sbin/ifconfig", iface)
+	if err != nil {
+		return false
+	}
+
+	// $ /sbin/ifconfig en5
+	// en5: flags=8863<UP,BROADCAST,SMART,RUNNING,SIMPLEX,MULTICAST> mtu 1500
+	// ...
+	// 	status: active
+
+	return strings.Contains(output, "status: active")
+}
+
+func updateLinks(client mqtt.Client) {
+	ifaces, err := getLinkInterfaces()
+	if err != nil {
+		reportError(client, "updating links", err)
+		return
+	}
+
+	links := map[string]bool{}
+	for _, iface := range ifaces {
+		links[iface] = getInterfaceLink(iface)
+	}
+
+	payload, err := json.Marshal(links)
+	if err != nil {
+		logErrorf("Error marshaling links: %v", err)
+		return
+	}
+
+	publishState(client, "links", getTopicPrefix()+"/state/links", payload)
+}
+
+func getWifiInfo() (wifiInfo, error) {
+	iface, err := getWifiInterface()
+	if err != nil {
+		return wifiInfo{}, err
+	}
+
+	info := wifiInfo{Interface: iface}
+	if info.Interface == "" {
+		return info, nil
+	}
+
+	info.IP = getInterfaceIP(info.Interface)
+
+	output, err := getCommandOutput("/usr/sbin/system_profiler", "SPAirPortDataType")
+	if err != nil {
+		return wifiInfo{}, err
+	}
+
+	// $ /usr/sbin/system_profiler SPAirPortDataType
+	// ...
+	//           Current Network Information:
+	//             MyNetwork:
+	//               PHY Mode: 802.11ax
+	//               Channel: 149 (5GHz, 80MHz)
+	//               Signal / Noise: -55 dBm / -92 dBm
+	//           Other Local Wi-Fi Networks:
+	// ...
+
+	_, current, found := strings.Cut(output, "Current Network Information:")
+	if !found {
+		return info, nil
+	}
+	current, _, _ = strings.Cut(current, "Other Local Wi-Fi Networks:")
+
+	lines := strings.Split(strings.TrimSpace(current), "\n")
+	info.SSID = strings.TrimSuffix(strings.TrimSpace(lines[0]), ":")
+
+	for _, line := range lines[1:] {
+		key, value, found := strings.Cut(strings.TrimSpace(line), ": ")
+		if !found {
+			continue
+		}
+
+		switch key {
+		case "Channel":
+			info.Channel = value
+		case "Signal / Noise":
+			rssi, err := strconv.Atoi(strings.Fields(value)[0])
+			if err == nil {
+				info.RSSI = rssi
+			}
+		}
+	}
+
+	return info, nil
+}
+
+func updateWifi(client mqtt.Client) {
+	info, err := getWifiInfo()
+	if err != nil {
+		reportError(client, "updating wifi", err)
+		return
+	}
+
+	payload, err := json.Marshal(info)
+	if err != nil {
+		logErrorf("Error marshaling wifi info: %v", err)
+		return
+	}
+
+	publishState(client, "wifi", getTopicPrefix()+"/state/wifi", payload)
+}
+
+func publishNetworkDiscoveryConfig(client mqtt.Client) {
+	topicPrefix := getTopicPrefix()
+	device := getDevice()
+
+	ssidConfig := SensorConfig{
+		Name:                hostname + " Wi-Fi SSID",
+		Icon:                "mdi:wifi",
+		StateTopic:          topicPrefix + "/state/wifi",
+		UniqueID:            hostname + "_wifi_ssid",
+		ValueTemplate:       "{{ value_json.ssid }}",
+		JsonAttributesTopic: topicPrefix + "/state/wifi",
+		ExpireAfter:         expireAfter(networkInterval),
+		AvailabilityTopic:   getAvailabilityTopic(),
+		Device:              device,
+	}
+	publishConfig(client, "sensor", hostname+"_wifi_ssid", ssidConfig)
+
+	rssiConfig := SensorConfig{
+		Name:              hostname + " Wi-Fi Signal",
+		EntityCategory:    "diagnostic",
+		StateTopic:        topicPrefix + "/state/wifi",
+		UniqueID:          hostname + "_wifi_rssi",
+		UnitOfMeasurement: "dBm",
+		DeviceClass:       "signal_strength",
+		ValueTemplate:     "{{ value_json.rssi }}",
+		ExpireAfter:       expireAfter(networkInterval),
+		AvailabilityTopic: getAvailabilityTopic(),
+		Device:            device,
+	}
+	publishConfig(client, "sensor", hostname+"_wifi_rssi", rssiConfig)
+
+	ipConfig := SensorConfig{
+		Name:              hostname + " IP Address",
+		Icon:              "mdi:ip-network",
+		EntityCategory:    "diagnostic",
+		StateTopic:        topicPrefix + "/state/wifi",
+		UniqueID:          hostname + "_wifi_ip",
+		ValueTemplate:     "{{ value_json.ip }}",
+		ExpireAfter:       expireAfter(networkInterval),
+		AvailabilityTopic: getAvailabilityTopic(),
+		Device:            device,
+	}
+	publishConfig(client, "sensor", hostname+"_wifi_ip", ipConfig)
+
+	macAddressConfig := SensorConfig{
+		Name:                hostname + " MAC Address",
+		Icon:                "mdi:network",
+		EntityCategory:      "diagnostic",
+		StateTopic:          topicPrefix + "/state/mac_addresses",
+		UniqueID:            hostname + "_mac_address",
+		ValueTemplate:       "{{ value_json.primary }}",
+		JsonAttributesTopic: topicPrefix + "/state/mac_addresses",
+		ExpireAfter:         expireAfter(networkInterval),
+		AvailabilityTopic:   getAvailabilityTopic(),
+		Device:              device,
+	}
+	publishConfig(client, "sensor", hostname+"_mac_address", macAddressConfig)
+
+	ifaces, err := getLinkInterfaces()
+	if err != nil {
+		logErrorf("Error reading network interfaces: %v", err)
+		return
+	}
+
+	for _, iface := range ifaces {
+		linkConfig := BinarySensorConfig{
+			Name:              hostname + " " + iface + " Link",
+			Icon:              "mdi:ethernet",
+			StateTopic:        topicPrefix + "/state/links",
+			ValueTemplate:     "{{ 'ON' if value_json['" + iface + "'] else 'OFF' }}",
+			UniqueID:          hostname + "_link_" + iface,
+			DeviceClass:       "connectivity",
+			ExpireAfter:       expireAfter(networkInterval),
+			AvailabilityTopic: getAvailabilityTopic(),
+			Device:            device,
+		}
+		publishConfig(client, "binary_sensor", hostname+"_link_"+iface, linkConfig)
+	}
+}
+
+func init() {
+	registerSensor(sensorFuncs{
+		name:     "network",
+		interval: networkInterval,
+		poll: func(client mqtt.Client) {
+			updateWifi(client)
+			updateMacAddresses(client)
+			updateLinks(client)
+		},
+		discovery: publishNetworkDiscoveryConfig,
+	})
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// Full path of the nightlight tool, empty when it is not installed. Night
+// Shift has no public API, nightlight calls CoreBrightness for us
+var nightlightPath string
+
+type nightShiftState struct {
+	Enabled bool `json:"enabled"`
+	// Color temperature from 0 (least warm) to 100 (warmest)
+	Strength int `json:"strength"`
+}
+
+func getNightShift() (nightShiftState, error) {
+	// $ nightlight status
+	// on
+	status, err := getCommandOutput(nightlightPath, "status")
+	if err != nil {
+		return nightShiftState{}, err
+	}
+
+	// $ nightlight temp
+	// 50
+	temp, err := getCommandOutput(nightlightPath, "temp")
+	if err != nil {
+		return nightShiftState{}, err
+	}
+
+	strength, err := strconv.Atoi(strings.TrimSpace(temp))
+	if err != nil {
+		return nightShiftState{}, fmt.Errorf("unexpected nightlight temp output: %s", temp)
+	}
+
+	return nightShiftState{Enabled: strings.HasPrefix(strings.TrimSpace(status), "on"), Strength: strength}, nil
+}
+
+func setNightShift(b bool) error {
+	if b {
+		return runCommand(nightlightPath, "on")
+	}
+	return runCommand(nightlightPath, "off")
+}
+
+func setNightShiftStrength(i int) error {
+	return runCommand(nightlightPath, "temp", strconv.Itoa(i))
+}
+
+// True Tone is read and set with CBTrueToneClient of the private
+// CoreBrightness framework through the JavaScript Cocoa bridge
+const trueToneScript = `ObjC.import("Foundation");
+function run(argv) {
+	$.NSBundle.bundleWithPath("/System/Library/PrivateFrameworks/CoreBrightness.framework").load;
+	var client = $.CBTrueToneClient.alloc.init;
+	if (argv.length > 0 && client.supported && client.available) {
+		client.setEnabled(argv[0] === "true");
+	}
+	return JSON.stringify({supported: client.supported && client.available, enabled: client.enabled});
+}`
+
+type trueToneState struct {
+	Supported bool `json:"supported"`
+	Enabled   bool `json:"enabled"`
+}
+
+func runTrueToneScript(arg ...string) (trueToneState, error) {
+	output, err := getCommandOutput("/usr/bin/osascript", append([]string{"-l", "JavaScript", "-e", trueToneScript}, arg...)...)
+	if err != nil {
+		return trueToneState{}, err
+	}
+
+	// $ osascript -l JavaScript -e '...'
+	// {"supported":true,"enabled":true}
+
+	var state trueToneState
+	if err := json.Unmarshal([]byte(output), &state); err != nil {
+		return trueToneState{}, fmt.Errorf("unexpected osascript output: %s", output)
+	}
+
+	return state, nil
+}
+
+func updateNightShift(client mqtt.Client) {
+	if nightlightPath == "" {
+		return
+	}
+
+	state, err := getNightShift()
+	if err != nil {
+		reportError(client, "updating night shift", err)
+		return
+	}
+
+	payload, err := json.Marshal(state)
+	if err != nil {
+		logErrorf("Error marshaling night shift: %v", err)
+		return
+	}
+
+	publishState(client, "night shift", getTopicPrefix()+"/state/night_shift", payload)
+}
+
+// Macs without True Tone displays publish nothing
+func updateTrueTone(client mqtt.Client) {
+	state, err := runTrueToneScript()
+	if err != nil {
+		reportError(client, "updating true tone", err)
+		return
+	}
+
+	if !state.Supported {
+		return
+	}
+
+	publishState(client, "true tone", getTopicPrefix()+"/state/true_tone", strconv.FormatBool(state.Enabled))
+}
+
+func publishNightShiftDiscoveryConfig(client mqtt.Client) {
+	if nightlightPath == "" {
+		return
+	}
+
+	topicPrefix := getTopicPrefix()
+	device := getDevice()
+
+	nightShiftSwitchConfig := SwitchConfig{
+		Name:              hostname + " Night Shift",
+		Icon:              "mdi:weather-night",
+		StateTopic:        topicPrefix + "/state/night_shift",
+		ValueTemplate:     "{{ 'true' if value_json.enabled else 'false' }}",
+		CommandTopic:      topicPrefix + "/command/night_shift",
+		PayloadOn:         "true",
+		PayloadOff:        "false",
+		UniqueID:          hostname + "_night_shift",
+		AvailabilityTopic: getAvailabilityTopic(),
+		Device:            device,
+	}
+	publishConfig(client, "switch", hostname+"_night_shift", nightShiftSwitchConfig)
+
+	nightShiftStrengthConfig := NumberConfig{
+		Name:              hostname + " Night Shift Strength",
+		Icon:              "mdi:thermometer",
+		StateTopic:        topicPrefix + "/state/night_shift",
+		ValueTemplate:     "{{ value_json.strength }}",
+		CommandTopic:      topicPrefix + "/command/night_shift_strength",
+		Min:               0,
+		Max:               100,
+		UniqueID:          hostname + "_night_shift_strength",
+		AvailabilityTopic: getAvailabilityTopic(),
+		Device:            device,
+	}
+	publishConfig(client, "number", hostname+"_night_shift_strength", nightShiftStrengthConfig)
+}
+
+func publishTrueToneDiscoveryConfig(client mqtt.Client) {
+	state, err := runTrueToneScript()
+	if err != nil || !state.Supported {
+		return
+	}
+
+	trueToneSwitchConfig := SwitchConfig{
+		Name:              hostname + " True Tone",
+		Icon:              "mdi:white-balance-auto",
+		StateTopic:        getTopicPrefix() + "/state/true_tone",
+		CommandTopic:      getTopicPrefix() + "/command/true_tone",
+		PayloadOn:         "true",
+		PayloadOff:        "false",
+		UniqueID:          hostname + "_true_tone",
+		AvailabilityTopic: getAvailabilityTopic(),
+		Device:            getDevice(),
+	}
+	publishConfig(client, "switch", hostname+"_true_tone", trueToneSwitchConfig)
+}
+
+func init() {
+	registerSensor(sensorFuncs{
+		name:      "night_shift",
+		interval:  nightShiftInterval,
+		poll:      updateNightShift,
+		discovery: publishNightShiftDiscoveryConfig,
+	})
+
+	registerSensor(sensorFuncs{
+		name:      "true_tone",
+		interval:  nightShiftInterval,
+		poll:      updateTrueTone,
+		discovery: publishTrueToneDiscoveryConfig,
+	})
+
+	registerCommand("night_shift", "night_shift", func(client mqtt.Client, command string, payload string) {
+		b, err := strconv.ParseBool(payload)
+		if err != nil || nightlightPath == "" {
+			logWarnf("Incorrect night shift value")
+			return
+		}
+
+		if err := setNightShift(b); err != nil {
+			reportError(client, "setting night shift", err)
+		}
+
+		updateNightShift(client)
+	})
+
+	registerCommand("night_shift", "night_shift_strength", func(client mqtt.Client, command string, payload string) {
+		i, err := strconv.Atoi(payload)
+		if err != nil || i < 0 || i > 100 || nightlightPath == "" {
+			logWarnf("Incorrect night shift strength value")
+			return
+		}
+
+		if err := setNightShiftStrength(i); err != nil {
+			reportError(client, "setting night shift strength", err)
+		}
+
+		updateNightShift(client)
+	})
+
+	registerCommand("true_tone", "true_tone", func(client mqtt.Client, command string, payload string) {
+		b, err := strconv.ParseBool(payload)
+		if err != nil {
+			logWarnf("Incorrect true tone value")
+			return
+		}
+
+		if _, err := runTrueToneScript(strconv.FormatBool(b)); err != nil {
+			reportError(client, "setting true tone", err)
+		}
+
+		updateTrueTone(client)
+	})
+}
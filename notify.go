@@ -0,0 +1,313 @@
+package main
+
+import (
+	"encoding/json"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// JSON payload of PREFIX/command/say. A payload that is not JSON is spoken as is
+type sayCommand struct {
+	Text  string `json:"text"`
+	Voice string `json:"voice"`
+	// words per minute
+	Rate int `json:"rate"`
+}
+
+func parseSayCommand(payload string) sayCommand {
+	var c sayCommand
+
+	if strings.HasPrefix(strings.TrimSpace(payload), "{") && json.Unmarshal([]byte(payload), &c) == nil {
+		return c
+	}
+
+	return sayCommand{Text: payload}
+}
+
+func commandSay(c sayCommand) error {
+	args := []string{}
+
+	if c.Voice != "" {
+		args = append(args, "-v", c.Voice)
+	}
+
+	if c.Rate > 0 {
+		args = append(args, "-r", strconv.Itoa(c.Rate))
+	}
+
+	// "--" so that text starting with "-" is not taken as an option
+	args = append(args, "--", c.Text)
+
+	return runCommandTimeout(longCommandTimeout, "/usr/bin/say", args...)
+}
+
+// JSON payload of PREFIX/command/notify. A payload that is not JSON is shown as the message
+type notifyCommand struct {
+	Title   string `json:"title"`
+	Message string `json:"message"`
+	// name of a sound from /System/Library/Sounds, e.g. "Glass"
+	Sound string `json:"sound"`
+}
+
+func parseNotifyCommand(payload string) notifyCommand {
+	var c notifyCommand
+
+	if strings.HasPrefix(strings.TrimSpace(payload), "{") && json.Unmarshal([]byte(payload), &c) == nil {
+		return c
+	}
+
+	return notifyCommand{Message: payload}
+}
+
+func commandNotify(c notifyCommand) error {
+	if c.Title == "" {
+		c.Title = "mac2mqtt"
+	}
+
+	// Texts are passed as arguments, not pasted into the script, so quotes
+	// in the message can't break the AppleScript
+	script := "display notification (item 1 of argv) with title (item 2 of argv)"
+	if c.Sound != "" {
+		script += " sound name (item 3 of argv)"
+	}
+
+	return runCommand("/usr/bin/osascript", "-e", "on run argv", "-e", script, "-e", "end run", c.Message, c.Title, c.Sound)
+}
+
+// JSON payload of PREFIX/command/message. A payload that is not JSON is shown as the message
+type messageCommand struct {
+	Title   string `json:"title"`
+	Message string `json:"message"`
+	// seconds until the message closes by itself, 0 keeps it until OK is clicked
+	Timeout int `json:"timeout"`
+}
+
+func parseMessageCommand(payload string) messageCommand {
+	var c messageCommand
+
+	if strings.HasPrefix(strings.TrimSpace(payload), "{") && json.Unmarshal([]byte(payload), &c) == nil {
+		return c
+	}
+
+	return messageCommand{Message: payload}
+}
+
+// osascript showing the current message, nil when there is none. The alert
+// belongs to that process, killing it closes the alert
+var messageCmd *exec.Cmd
+var messageMutex sync.Mutex
+
+// Closes the message on the screen, if there is one
+func closeMessage() {
+	messageMutex.Lock()
+	defer messageMutex.Unlock()
+
+	closeMessageLocked()
+}
+
+// messageMutex must be held
+func closeMessageLocked() {
+	if messageCmd != nil {
+		messageCmd.Process.Kill()
+		messageCmd = nil
+	}
+}
+
+// Shows the message in a big alert window in front of all apps. Unlike a
+// notification it stays on the screen until it is closed, a new message
+// replaces the previous one
+func commandMessage(c messageCommand) error {
+	if c.Title == "" {
+		c.Title = "mac2mqtt"
+	}
+
+	display := "display alert (item 1 of argv) message (item 2 of argv)"
+	if c.Timeout > 0 {
+		display += " giving up after (item 3 of argv as integer)"
+	}
+
+	// osascript shows the alert itself, not System Events, so that the alert
+	// goes away with the process. activate brings it in front of all apps
+	script := []string{
+		"on run argv",
+		"activate",
+		display,
+		"end run",
+	}
+
+	args := []string{}
+	for _, line := range script {
+		args = append(args, "-e", line)
+	}
+	args = append(args, c.Title, c.Message, strconv.Itoa(c.Timeout))
+
+	// the previous message is closed and the new one shown under one lock,
+	// so two messages at once can't leave an alert nobody can close
+	messageMutex.Lock()
+	defer messageMutex.Unlock()
+
+	closeMessageLocked()
+
+	cmd := exec.Command("/usr/bin/osascript", args...)
+	if err := cmd.Start(); err != nil {
+		return commandError("/usr/bin/osascript", err)
+	}
+
+	messageCmd = cmd
+
+	go func() {
+		cmd.Wait()
+
+		messageMutex.Lock()
+		if messageCmd == cmd {
+			messageCmd = nil
+		}
+		messageMutex.Unlock()
+	}()
+
+	return nil
+}
+
+// Covers every screen with a white window above everything for a moment,
+// osascript can show AppKit windows from JavaScript without a helper app
+const flashScript = `ObjC.import("Cocoa");
+function run(argv) {
+	var app = $.NSApplication.sharedApplication;
+	var windows = [];
+	var screens = $.NSScreen.screens;
+	for (var i = 0; i < screens.count; i++) {
+		var w = $.NSWindow.alloc.initWithContentRectStyleMaskBackingDefer(
+			screens.objectAtIndex(i).frame, $.NSWindowStyleMaskBorderless, $.NSBackingStoreBuffered, false);
+		w.backgroundColor = $.NSColor.whiteColor;
+		w.alphaValue = 0.85;
+		w.level = $.NSScreenSaverWindowLevel;
+		w.ignoresMouseEvents = true;
+		windows.push(w);
+	}
+	for (var n = 0; n < Number(argv[0]); n++) {
+		windows.forEach(function (w) { w.orderFrontRegardless; });
+		$.NSRunLoop.currentRunLoop.runUntilDate($.NSDate.dateWithTimeIntervalSinceNow(0.2));
+		windows.forEach(function (w) { w.orderOut(null); });
+		$.NSRunLoop.currentRunLoop.runUntilDate($.NSDate.dateWithTimeIntervalSinceNow(0.2));
+	}
+}`
+
+// Visual doorbell for somebody wearing headphones
+func commandFlash(times int) error {
+	return runCommand("/usr/bin/osascript", "-l", "JavaScript", "-e", flashScript, strconv.Itoa(times))
+}
+
+func publishNotifyDiscoveryConfig(client mqtt.Client) {
+	topicPrefix := getTopicPrefix()
+	device := getDevice()
+
+	sayNotifyConfig := NotifyConfig{
+		Name:              hostname + " Say",
+		Icon:              "mdi:account-voice",
+		CommandTopic:      topicPrefix + "/command/say",
+		UniqueID:          hostname + "_say",
+		AvailabilityTopic: getAvailabilityTopic(),
+		Device:            device,
+	}
+	publishConfig(client, "notify", hostname+"_say", sayNotifyConfig)
+
+	notificationNotifyConfig := NotifyConfig{
+		Name:              hostname + " Notification",
+		Icon:              "mdi:message-text",
+		CommandTopic:      topicPrefix + "/command/notify",
+		UniqueID:          hostname + "_notify",
+		AvailabilityTopic: getAvailabilityTopic(),
+		Device:            device,
+	}
+	publishConfig(client, "notify", hostname+"_notify", notificationNotifyConfig)
+
+	messageNotifyConfig := NotifyConfig{
+		Name:              hostname + " Message",
+		Icon:              "mdi:message-alert",
+		CommandTopic:      topicPrefix + "/command/message",
+		UniqueID:          hostname + "_message",
+		AvailabilityTopic: getAvailabilityTopic(),
+		Device:            device,
+	}
+	publishConfig(client, "notify", hostname+"_message", messageNotifyConfig)
+
+	flashButtonConfig := ButtonConfig{
+		Name:              hostname + " Flash Screen",
+		Icon:              "mdi:flash-alert",
+		CommandTopic:      topicPrefix + "/command/flash",
+		PayloadPress:      "flash",
+		UniqueID:          hostname + "_flash",
+		AvailabilityTopic: getAvailabilityTopic(),
+		Device:            device,
+	}
+	publishConfig(client, "button", hostname+"_flash", flashButtonConfig)
+}
+
+func init() {
+	registerSensor(sensorFuncs{
+		name:      "notify",
+		discovery: publishNotifyDiscoveryConfig,
+	})
+
+	registerCommand("notify", "say", func(client mqtt.Client, command string, payload string) {
+		c := parseSayCommand(payload)
+		if c.Text == "" {
+			logWarnf("Incorrect say value")
+			return
+		}
+
+		// speaking takes a while, don't block other commands
+		go func() {
+			if err := commandSay(c); err != nil {
+				reportError(client, "speaking", err)
+			}
+		}()
+	})
+
+	registerCommand("notify", "notify", func(client mqtt.Client, command string, payload string) {
+		c := parseNotifyCommand(payload)
+		if c.Message == "" {
+			logWarnf("Incorrect notify value")
+			return
+		}
+
+		if err := commandNotify(c); err != nil {
+			reportError(client, "showing notification", err)
+		}
+	})
+
+	registerCommand("notify", "flash", func(client mqtt.Client, command string, payload string) {
+		// flash or the number of flashes
+		times := 3
+		if payload != "flash" {
+			n, err := strconv.Atoi(payload)
+			if err != nil || n < 1 || n > 20 {
+				logWarnf("Incorrect flash value")
+				return
+			}
+			times = n
+		}
+
+		go func() {
+			if err := commandFlash(times); err != nil {
+				reportError(client, "flashing screen", err)
+			}
+		}()
+	})
+
+	registerCommand("notify", "message", func(client mqtt.Client, command string, payload string) {
+		c := parseMessageCommand(payload)
+		if c.Message == "" {
+			closeMessage()
+			return
+		}
+
+		if err := commandMessage(c); err != nil {
+			reportError(client, "showing message", err)
+		}
+	})
+}
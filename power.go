@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// Running `caffeinate` process, nil when the Mac is allowed to sleep
+var caffeinateCmd *exec.Cmd
+var caffeinateMutex sync.Mutex
+
+func getCaffeinateStatus() bool {
+	caffeinateMutex.Lock()
+	defer caffeinateMutex.Unlock()
+
+	return caffeinateCmd != nil
+}
+
+// true - keep the Mac and the display awake
+// false - allow sleep again
+func setCaffeinate(b bool) error {
+	caffeinateMutex.Lock()
+	defer caffeinateMutex.Unlock()
+
+	if b && caffeinateCmd == nil {
+		// -w makes caffeinate exit together with mac2mqtt, so the Mac is never
+		// kept awake by a leftover process
+		cmd := exec.Command("/usr/bin/caffeinate", "-dims", "-w", strconv.Itoa(os.Getpid()))
+		if err := cmd.Start(); err != nil {
+			return commandError("/usr/bin/caffeinate", err)
+		}
+		caffeinateCmd = cmd
+
+		go func() {
+			cmd.Wait()
+
+			caffeinateMutex.Lock()
+			if caffeinateCmd == cmd {
+				caffeinateCmd = nil
+			}
+			caffeinateMutex.Unlock()
+		}()
+
+	} else if !b && caffeinateCmd != nil {
+		err := caffeinateCmd.Process.Kill()
+		caffeinateCmd = nil
+		if err != nil {
+			return fmt.Errorf("stopping caffeinate: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// Cancels the running jiggle loop, nil when it is not running
+var jiggleCancel context.CancelFunc
+var jiggleMutex sync.Mutex
+
+const jiggleInterval = 30 * time.Second
+
+func getJiggleStatus() bool {
+	jiggleMutex.Lock()
+	defer jiggleMutex.Unlock()
+
+	return jiggleCancel != nil
+}
+
+// Lighter than caffeinate: instead of holding the Mac awake it only tells
+// macOS that the user is active, so the display and the screen saver act
+// as if somebody touched the mouse every 30 seconds
+func setJiggle(b bool) {
+	jiggleMutex.Lock()
+	defer jiggleMutex.Unlock()
+
+	if b && jiggleCancel == nil {
+		ctx, cancel := context.WithCancel(context.Background())
+		jiggleCancel = cancel
+
+		go func() {
+			for {
+				// -u declares user activity, it turns the display on and
+				// keeps it on for -t seconds
+				if err := runCommand("/usr/bin/caffeinate", "-u", "-t", "1"); err != nil {
+					logErrorf("Error jiggling: %v", err)
+				}
+
+				if !sleepContext(ctx, jiggleInterval) {
+					return
+				}
+			}
+		}()
+
+	} else if !b && jiggleCancel != nil {
+		jiggleCancel()
+		jiggleCancel = nil
+	}
+}
+
+// Payload of PREFIX/command/schedule_wake can be Unix time or date in one of these formats.
+// Dates without time zone are in local time
+var wakeTimeLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04",
+}
+
+func parseWakeTime(payload string) (time.Time, error) {
+	if sec, err := strconv.ParseInt(payload, 10, 64); err == nil {
+		return time.Unix(sec, 0), nil
+	}
+
+	for _, layout := range wakeTimeLayouts {
+		t, err := time.ParseInLocation(layout, payload, time.Local)
+		if err == nil {
+			return t, nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("unknown time format: %s", payload)
+}
+
+// Requires mac2mqtt to run as root
+func commandScheduleWake(t time.Time) error {
+	// $ pmset schedule wake "04/10/24 07:30:00"
+	return runCommand("/usr/bin/pmset", "schedule", "wake", t.Local().Format("01/02/06 15:04:05"))
+}
+
+func updateCaffeinate(client mqtt.Client) {
+	publishState(client, "caffeinate", getTopicPrefix()+"/state/caffeinate", strconv.FormatBool(getCaffeinateStatus()))
+}
+
+func updateJiggle(client mqtt.Client) {
+	publishState(client, "jiggle", getTopicPrefix()+"/state/jiggle", strconv.FormatBool(getJiggleStatus()))
+}
+
+func publishPowerDiscoveryConfig(client mqtt.Client) {
+	topicPrefix := getTopicPrefix()
+	device := getDevice()
+
+	caffeinateSwitchConfig := SwitchConfig{
+		Name:              hostname + " Keep Awake",
+		Icon:              "mdi:coffee",
+		StateTopic:        topicPrefix + "/state/caffeinate",
+		CommandTopic:      topicPrefix + "/command/caffeinate",
+		PayloadOn:         "true",
+		PayloadOff:        "false",
+		UniqueID:          hostname + "_caffeinate",
+		AvailabilityTopic: getAvailabilityTopic(),
+		Device:            device,
+	}
+	publishConfig(client, "switch", hostname+"_caffeinate", caffeinateSwitchConfig)
+
+	jiggleSwitchConfig := SwitchConfig{
+		Name:              hostname + " Keep Display Awake",
+		Icon:              "mdi:mouse-move-vertical",
+		StateTopic:        topicPrefix + "/state/jiggle",
+		CommandTopic:      topicPrefix + "/command/jiggle",
+		PayloadOn:         "true",
+		PayloadOff:        "false",
+		UniqueID:          hostname + "_jiggle",
+		AvailabilityTopic: getAvailabilityTopic(),
+		Device:            device,
+	}
+	publishConfig(client, "switch", hostname+"_jiggle", jiggleSwitchConfig)
+
+	scheduleWakeConfig := TextConfig{
+		Name:              hostname + " Schedule Wake",
+		Icon:              "mdi:alarm",
+		EntityCategory:    "config",
+		CommandTopic:      topicPrefix + "/command/schedule_wake",
+		UniqueID:          hostname + "_schedule_wake",
+		AvailabilityTopic: getAvailabilityTopic(),
+		Device:            device,
+	}
+	publishConfig(client, "text", hostname+"_schedule_wake", scheduleWakeConfig)
+}
+
+func init() {
+	registerSensor(sensorFuncs{
+		name: "power",
+		poll: func(client mqtt.Client) {
+			updateCaffeinate(client)
+			updateJiggle(client)
+		},
+		discovery: publishPowerDiscoveryConfig,
+	})
+
+	registerCommand("power", "caffeinate", func(client mqtt.Client, command string, payload string) {
+		b, err := strconv.ParseBool(payload)
+		if err != nil {
+			logWarnf("Incorrect caffeinate value")
+			return
+		}
+
+		if err := setCaffeinate(b); err != nil {
+			reportError(client, "setting caffeinate", err)
+		}
+
+		updateCaffeinate(client)
+	})
+
+	registerCommand("power", "jiggle", func(client mqtt.Client, command string, payload string) {
+		b, err := strconv.ParseBool(payload)
+		if err != nil {
+			logWarnf("Incorrect jiggle value")
+			return
+		}
+
+		setJiggle(b)
+
+		updateJiggle(client)
+	})
+
+	registerCommand("power", "schedule_wake", func(client mqtt.Client, command string, payload string) {
+		t, err := parseWakeTime(payload)
+		if err != nil || !t.After(time.Now()) {
+			logWarnf("Incorrect schedule wake value")
+			return
+		}
+
+		if err := commandScheduleWake(t); err != nil {
+			reportError(client, "scheduling wake", err)
+			return
+		}
+
+		logInfof("Scheduled wake at %v", t)
+	})
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"regexp"
+	"strconv"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// powermetrics works only as root, without it there is no power usage sensor
+func isPowerUsageAvailable() bool {
+	return os.Getuid() == 0
+}
+
+// Power drawn by the CPU package in watts
+func getPowerUsage() (float64, error) {
+	// The sample takes half a second
+	output, err := getCommandOutput("/usr/bin/powermetrics", "-n", "1", "-i", "500", "--samplers", "cpu_power")
+	if err != nil {
+		return 0, err
+	}
+
+	// Apple Silicon:
+	//
+	// $ sudo /usr/bin/powermetrics -n 1 -i 500 --samplers cpu_power
+	// ...
+	// CPU Power: 1532 mW
+	// GPU Power: 45 mW
+	// ANE Power: 0 mW
+	// Combined Power (CPU + GPU + ANE): 1577 mW
+	//
+	// Intel:
+	//
+	// ...
+	// Intel energy model derived package power (CPUs+GT+SA): 2.08W
+
+	r := regexp.MustCompile(`Combined Power \(CPU \+ GPU \+ ANE\): (\d+) mW`)
+	if m := r.FindStringSubmatch(output); m != nil {
+		mW, err := strconv.Atoi(m[1])
+		if err != nil {
+			return 0, err
+		}
+		return float64(mW) / 1000, nil
+	}
+
+	r = regexp.MustCompile(`package power \(CPUs\+GT\+SA\): ([\d.]+)W`)
+	if m := r.FindStringSubmatch(output); m != nil {
+		return strconv.ParseFloat(m[1], 64)
+	}
+
+	return 0, errors.New("can't find package power in the output of powermetrics")
+}
+
+func updatePowerUsage(client mqtt.Client) {
+	if !isPowerUsageAvailable() {
+		return
+	}
+
+	watts, err := getPowerUsage()
+	if err != nil {
+		reportError(client, "updating power usage", err)
+		return
+	}
+
+	publishState(client, "power usage", getTopicPrefix()+"/state/power_usage", strconv.FormatFloat(watts, 'f', 2, 64))
+}
+
+func publishPowerUsageDiscoveryConfig(client mqtt.Client) {
+	if !isPowerUsageAvailable() {
+		return
+	}
+
+	topicPrefix := getTopicPrefix()
+	device := getDevice()
+
+	powerUsageConfig := SensorConfig{
+		Name:                      hostname + " Power Usage",
+		Icon:                      "mdi:lightning-bolt",
+		StateTopic:                topicPrefix + "/state/power_usage",
+		UniqueID:                  hostname + "_power_usage",
+		UnitOfMeasurement:         "W",
+		DeviceClass:               "power",
+		StateClass:                "measurement",
+		SuggestedDisplayPrecision: precision(1),
+		ExpireAfter:               expireAfter(powerUsageInterval),
+		AvailabilityTopic:         getAvailabilityTopic(),
+		Device:                    device,
+	}
+	publishConfig(client, "sensor", hostname+"_power_usage", powerUsageConfig)
+}
+
+func init() {
+	registerSensor(sensorFuncs{
+		name:      "power_usage",
+		interval:  powerUsageInterval,
+		poll:      updatePowerUsage,
+		discovery: publishPowerUsageDiscoveryConfig,
+	})
+}
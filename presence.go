@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"regexp"
+	"strconv"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+type occupancyConfig struct {
+	// Seconds without keyboard or mouse activity after which the desk is
+	// free, user_active_threshold by default
+	IdleThreshold int `yaml:"idle_threshold"`
+	// The camera in use, e.g. in a video call, counts as present even
+	// without typing. Off by default. Needs the av_usage feature
+	Camera bool `yaml:"camera"`
+	// Seconds the desk stays occupied after the signals are gone, so that
+	// reading something doesn't turn the lights off. 0 by default
+	Hold int `yaml:"hold"`
+}
+
+type occupancyState struct {
+	Occupied    bool `json:"occupied"`
+	IdleTime    int  `json:"idle_time"`
+	ScreenLock  bool `json:"screen_locked"`
+	DisplayOn   bool `json:"display_on"`
+	CameraInUse bool `json:"camera_in_use"`
+}
+
+// Last time the desk was occupied, for occupancy.hold
+var lastOccupied time.Time
+
+// Seconds since the last keyboard or mouse event
+func getIdleTime() (int, error) {
+	output, err := getCommandOutput("/usr/sbin/ioreg", "-c", "IOHIDSystem", "-d", "4")
+	if err != nil {
+		return 0, err
+	}
+
+	// $ /usr/sbin/ioreg -c IOHIDSystem -d 4
+	// ...
+	//   |   "HIDIdleTime" = 1536473958
+	// ...
+
+	r := regexp.MustCompile(`"HIDIdleTime" = (\d+)`)
+	m := r.FindStringSubmatch(output)
+	if m == nil {
+		return 0, errors.New("can't find HIDIdleTime in the output of ioreg")
+	}
+
+	// nanoseconds
+	ns, err := strconv.ParseInt(m[1], 10, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	return int(ns / 1000000000), nil
+}
+
+func updateIdleTime(client mqtt.Client) {
+	idle, err := getIdleTime()
+	if err != nil {
+		reportError(client, "updating idle time", err)
+		return
+	}
+
+	publishState(client, "idle time", getTopicPrefix()+"/state/idle_time", strconv.Itoa(idle))
+	publishState(client, "user active", getTopicPrefix()+"/state/user_active", strconv.FormatBool(idle < settings.UserActiveThreshold))
+}
+
+// Someone is at the Mac when the screen is unlocked, the display is on and
+// there was input recently or the camera is in use
+func getOccupancy() (occupancyState, error) {
+	idle, err := getIdleTime()
+	if err != nil {
+		return occupancyState{}, err
+	}
+
+	locked, err := getScreenLocked()
+	if err != nil {
+		return occupancyState{}, err
+	}
+
+	displayOn, err := getDisplayOn()
+	if err != nil {
+		return occupancyState{}, err
+	}
+
+	state := occupancyState{
+		IdleTime:    idle,
+		ScreenLock:  locked,
+		DisplayOn:   displayOn,
+		CameraInUse: getAVUsage().Camera,
+	}
+
+	active := idle < settings.Occupancy.IdleThreshold || (settings.Occupancy.Camera && state.CameraInUse)
+	state.Occupied = !locked && displayOn && active
+
+	if state.Occupied {
+		lastOccupied = time.Now()
+	} else if time.Since(lastOccupied) < time.Duration(settings.Occupancy.Hold)*time.Second && !locked {
+		state.Occupied = true
+	}
+
+	return state, nil
+}
+
+func updateOccupancy(client mqtt.Client) {
+	state, err := getOccupancy()
+	if err != nil {
+		reportError(client, "updating occupancy", err)
+		return
+	}
+
+	payload, err := json.Marshal(state)
+	if err != nil {
+		logErrorf("Error marshaling occupancy: %v", err)
+		return
+	}
+
+	publishState(client, "occupancy", getTopicPrefix()+"/state/occupancy", payload)
+}
+
+func publishOccupancyDiscoveryConfig(client mqtt.Client) {
+	occupiedConfig := BinarySensorConfig{
+		Name:                hostname + " Occupied",
+		Icon:                "mdi:desk",
+		StateTopic:          getTopicPrefix() + "/state/occupancy",
+		ValueTemplate:       "{{ 'ON' if value_json.occupied else 'OFF' }}",
+		JsonAttributesTopic: getTopicPrefix() + "/state/occupancy",
+		UniqueID:            hostname + "_occupied",
+		DeviceClass:         "occupancy",
+		ExpireAfter:         expireAfter(screenInterval),
+		AvailabilityTopic:   getAvailabilityTopic(),
+		Device:              getDevice(),
+	}
+	publishConfig(client, "binary_sensor", hostname+"_occupied", occupiedConfig)
+}
+
+func publishPresenceDiscoveryConfig(client mqtt.Client) {
+	topicPrefix := getTopicPrefix()
+	device := getDevice()
+
+	idleTimeConfig := SensorConfig{
+		Name:                      hostname + " Idle Time",
+		Icon:                      "mdi:timer-sand",
+		SuggestedDisplayPrecision: precision(0),
+		StateTopic:                topicPrefix + "/state/idle_time",
+		UniqueID:                  hostname + "_idle_time",
+		UnitOfMeasurement:         "s",
+		DeviceClass:               "duration",
+		ExpireAfter:               expireAfter(screenInterval),
+		AvailabilityTopic:         getAvailabilityTopic(),
+		Device:                    device,
+	}
+	publishConfig(client, "sensor", hostname+"_idle_time", idleTimeConfig)
+
+	userActiveConfig := BinarySensorConfig{
+		Name:              hostname + " User Active",
+		StateTopic:        topicPrefix + "/state/user_active",
+		PayloadOn:         "true",
+		PayloadOff:        "false",
+		UniqueID:          hostname + "_user_active",
+		DeviceClass:       "occupancy",
+		ExpireAfter:       expireAfter(screenInterval),
+		AvailabilityTopic: getAvailabilityTopic(),
+		Device:            device,
+	}
+	publishConfig(client, "binary_sensor", hostname+"_user_active", userActiveConfig)
+}
+
+func init() {
+	registerSensor(sensorFuncs{
+		name:      "idle_time",
+		interval:  screenInterval,
+		poll:      updateIdleTime,
+		discovery: publishPresenceDiscoveryConfig,
+	})
+
+	registerSensor(sensorFuncs{
+		name:      "occupancy",
+		interval:  screenInterval,
+		poll:      updateOccupancy,
+		discovery: publishOccupancyDiscoveryConfig,
+	})
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+type printQueues struct {
+	// Jobs waiting in all queues
+	Jobs int `json:"jobs"`
+	// Jobs by printer name
+	Queues map[string]int `json:"queues"`
+}
+
+func getPrintQueues() (printQueues, error) {
+	queues := printQueues{Queues: map[string]int{}}
+
+	output, err := getCommandOutput("/usr/bin/lpstat", "-o")
+	if err != nil {
+		// no printers were ever added
+		if strings.Contains(err.Error(), "No destinations added") {
+			return queues, nil
+		}
+		return printQueues{}, err
+	}
+
+	// $ /usr/bin/lpstat -o
+	// HP_LaserJet_Pro-12      alice        102400   Wed Apr 10 10:00:00 2024
+	// HP_LaserJet_Pro-13      alice         20480   Wed Apr 10 10:05:00 2024
+
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		// job id is the printer name and the job number
+		printer := fields[0]
+		if i := strings.LastIndex(printer, "-"); i > 0 {
+			printer = printer[:i]
+		}
+
+		queues.Jobs++
+		queues.Queues[printer]++
+	}
+
+	return queues, nil
+}
+
+// Cancels the jobs of all printers. Jobs of other users can be canceled
+// only when mac2mqtt runs as root
+func commandClearPrintQueues() error {
+	return runCommand("/usr/bin/cancel", "-a")
+}
+
+func updatePrinters(client mqtt.Client) {
+	queues, err := getPrintQueues()
+	if err != nil {
+		reportError(client, "updating printers", err)
+		return
+	}
+
+	payload, err := json.Marshal(queues)
+	if err != nil {
+		logErrorf("Error marshaling printers: %v", err)
+		return
+	}
+
+	publishState(client, "printers", getTopicPrefix()+"/state/print_queue", payload)
+}
+
+func publishPrintersDiscoveryConfig(client mqtt.Client) {
+	topicPrefix := getTopicPrefix()
+	device := getDevice()
+
+	printQueueConfig := SensorConfig{
+		Name:                hostname + " Print Queue",
+		Icon:                "mdi:printer",
+		StateTopic:          topicPrefix + "/state/print_queue",
+		ValueTemplate:       "{{ value_json.jobs }}",
+		JsonAttributesTopic: topicPrefix + "/state/print_queue",
+		UnitOfMeasurement:   "jobs",
+		StateClass:          "measurement",
+		UniqueID:            hostname + "_print_queue",
+		ExpireAfter:         expireAfter(printersInterval),
+		AvailabilityTopic:   getAvailabilityTopic(),
+		Device:              device,
+	}
+	publishConfig(client, "sensor", hostname+"_print_queue", printQueueConfig)
+
+	clearConfig := ButtonConfig{
+		Name:              hostname + " Clear Print Queue",
+		Icon:              "mdi:printer-off",
+		CommandTopic:      topicPrefix + "/command/clear_print_queue",
+		PayloadPress:      "clear",
+		UniqueID:          hostname + "_clear_print_queue",
+		AvailabilityTopic: getAvailabilityTopic(),
+		Device:            device,
+	}
+	publishConfig(client, "button", hostname+"_clear_print_queue", clearConfig)
+}
+
+func init() {
+	registerSensor(sensorFuncs{
+		name:      "printers",
+		interval:  printersInterval,
+		poll:      updatePrinters,
+		discovery: publishPrintersDiscoveryConfig,
+	})
+
+	registerCommand("printers", "clear_print_queue", func(client mqtt.Client, command string, payload string) {
+		if payload != "clear" {
+			return
+		}
+
+		if err := commandClearPrintQueues(); err != nil {
+			reportError(client, "clearing print queue", err)
+		}
+
+		time.Sleep(1 * time.Second)
+
+		updatePrinters(client)
+	})
+}
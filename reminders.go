@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// JSON payload of PREFIX/command/reminder. A payload that is not JSON is the
+// title of a reminder in the default list
+type reminderCommand struct {
+	Title string `json:"title"`
+	// Name of the list in Reminders, the default list when empty
+	List  string `json:"list"`
+	Notes string `json:"notes"`
+	// Unix time or a date in one of the formats of PREFIX/command/schedule_wake
+	Due string `json:"due"`
+}
+
+func parseReminderCommand(payload string) reminderCommand {
+	var c reminderCommand
+
+	if strings.HasPrefix(strings.TrimSpace(payload), "{") && json.Unmarshal([]byte(payload), &c) == nil {
+		return c
+	}
+
+	return reminderCommand{Title: payload}
+}
+
+// The due date is built from numbers, AppleScript parses date strings in
+// the format of the user's locale
+const reminderScript = `on run argv
+	tell application "Reminders"
+		if item 2 of argv is "" then
+			set l to default list
+		else
+			set l to list (item 2 of argv)
+		end if
+		tell l to set r to make new reminder with properties {name:item 1 of argv, body:item 3 of argv}
+		if (count of argv) > 3 then
+			set d to current date
+			set day of d to 1
+			set year of d to item 4 of argv as integer
+			set month of d to item 5 of argv as integer
+			set day of d to item 6 of argv as integer
+			set time of d to item 7 of argv as integer
+			set due date of r to d
+		end if
+	end tell
+end run`
+
+// Reminders asks once to allow mac2mqtt in Privacy & Security > Reminders
+func commandReminder(c reminderCommand) error {
+	args := []string{"-e", reminderScript, c.Title, c.List, c.Notes}
+
+	if c.Due != "" {
+		due, err := parseWakeTime(c.Due)
+		if err != nil {
+			return err
+		}
+		due = due.Local()
+
+		seconds := due.Hour()*3600 + due.Minute()*60 + due.Second()
+		args = append(args, strconv.Itoa(due.Year()), strconv.Itoa(int(due.Month())), strconv.Itoa(due.Day()), strconv.Itoa(seconds))
+	}
+
+	return runCommand("/usr/bin/osascript", args...)
+}
+
+func publishRemindersDiscoveryConfig(client mqtt.Client) {
+	reminderNotifyConfig := NotifyConfig{
+		Name:              hostname + " Reminder",
+		Icon:              "mdi:checkbox-marked-circle-plus-outline",
+		CommandTopic:      getTopicPrefix() + "/command/reminder",
+		UniqueID:          hostname + "_reminder",
+		AvailabilityTopic: getAvailabilityTopic(),
+		Device:            getDevice(),
+	}
+	publishConfig(client, "notify", hostname+"_reminder", reminderNotifyConfig)
+}
+
+func init() {
+	registerSensor(sensorFuncs{
+		name:      "reminders",
+		discovery: publishRemindersDiscoveryConfig,
+	})
+
+	registerCommand("reminders", "reminder", func(client mqtt.Client, command string, payload string) {
+		c := parseReminderCommand(payload)
+		if c.Title == "" {
+			logWarnf("Incorrect reminder value")
+			return
+		}
+
+		if err := commandReminder(c); err != nil {
+			reportError(client, "creating reminder", err)
+		}
+	})
+}
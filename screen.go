@@ -0,0 +1,419 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+func getScreenLocked() (bool, error) {
+	output, err := getCommandOutput("/usr/sbin/ioreg", "-n", "Root", "-d1")
+	if err != nil {
+		return false, err
+	}
+
+	// $ /usr/sbin/ioreg -n Root -d1
+	// +-o Root  <class IORegistryEntry, id 0x100000100, retain 30>
+	//     {
+	//       "IOConsoleUsers" = ({"kCGSSessionOnConsoleKey"=Yes,"CGSSessionScreenIsLocked"=Yes,...})
+	//     }
+	//
+	// The CGSSessionScreenIsLocked key is only present while the screen is locked
+
+	return strings.Contains(output, `"CGSSessionScreenIsLocked"=Yes`), nil
+}
+
+// false when the displays are asleep
+func getDisplayOn() (bool, error) {
+	output, err := getCommandOutput("/usr/sbin/ioreg", "-r", "-c", "IODisplayWrangler", "-d", "1")
+	if err != nil {
+		return false, err
+	}
+
+	// $ /usr/sbin/ioreg -r -c IODisplayWrangler -d 1
+	// +-o IODisplayWrangler  <class IODisplayWrangler, id 0x100000302, registered, matched, active, busy 0 (0 ms), retain 8>
+	//     {
+	//       "IOPowerManagement" = {"CapabilityFlags"=32768,"MaxPowerState"=4,"CurrentPowerState"=4,...}
+	//     ...
+	//
+	// 4 is on, 3 is dimmed, 1 and 0 are asleep
+
+	r := regexp.MustCompile(`"CurrentPowerState"=(\d+)`)
+	m := r.FindStringSubmatch(output)
+	if m == nil {
+		return false, errors.New("can't find CurrentPowerState in the output of ioreg")
+	}
+
+	state, err := strconv.Atoi(m[1])
+	if err != nil {
+		return false, err
+	}
+
+	return state >= 3, nil
+}
+
+func commandLockScreen() error {
+	if isDryRun("locking screen") {
+		return nil
+	}
+
+	// Ctrl+Cmd+Q is the system shortcut for "Lock Screen". Sending keystrokes
+	// requires mac2mqtt to be allowed in Privacy & Security > Accessibility
+	return runCommand("/usr/bin/osascript", "-e", `tell application "System Events" to keystroke "q" using {control down, command down}`)
+}
+
+// Path to the `brightness` tool (https://github.com/nriley/brightness),
+// empty if it is not installed and brightness control is disabled
+var brightnessPath string
+
+// from 0 to 100
+func getBrightness() (int, error) {
+	output, err := getCommandOutput(brightnessPath, "-l")
+	if err != nil {
+		return 0, err
+	}
+
+	// $ brightness -l
+	// display 0: main, active, awake, online, built-in, ID 0x1
+	// display 0: brightness 0.750000
+
+	r := regexp.MustCompile(`brightness ([0-9.]+)`)
+	m := r.FindStringSubmatch(output)
+	if m == nil {
+		return 0, errors.New("can't find brightness in the output of brightness -l")
+	}
+
+	f, err := strconv.ParseFloat(m[1], 64)
+	if err != nil {
+		return 0, err
+	}
+
+	return int(math.Round(f * 100)), nil
+}
+
+// from 0 to 100
+func setBrightness(i int) error {
+	return runCommand(brightnessPath, strconv.FormatFloat(float64(i)/100, 'f', 2, 64))
+}
+
+func updateBrightness(client mqtt.Client) {
+	brightness, err := getBrightness()
+	if err != nil {
+		reportError(client, "updating brightness", err)
+		return
+	}
+
+	publishState(client, "brightness", getTopicPrefix()+"/state/brightness", strconv.Itoa(brightness))
+}
+
+type displays struct {
+	Count int      `json:"count"`
+	Names []string `json:"names"`
+}
+
+func getDisplays() (displays, error) {
+	output, err := getCommandOutput("/usr/sbin/system_profiler", "SPDisplaysDataType", "-json")
+	if err != nil {
+		return displays{}, err
+	}
+
+	// $ /usr/sbin/system_profiler SPDisplaysDataType -json
+	// {"SPDisplaysDataType":[{"sppci_model":"Apple M2","spdisplays_ndrvs":[
+	//     {"_name":"Color LCD",...},{"_name":"LG UltraFine",...}]}]}
+
+	var data struct {
+		SPDisplaysDataType []struct {
+			Displays []struct {
+				Name string `json:"_name"`
+			} `json:"spdisplays_ndrvs"`
+		} `json:"SPDisplaysDataType"`
+	}
+	if err := json.Unmarshal([]byte(output), &data); err != nil {
+		return displays{}, fmt.Errorf("parsing system_profiler output: %v", err)
+	}
+
+	result := displays{Names: []string{}}
+	for _, gpu := range data.SPDisplaysDataType {
+		for _, d := range gpu.Displays {
+			result.Names = append(result.Names, d.Name)
+		}
+	}
+	result.Count = len(result.Names)
+
+	return result, nil
+}
+
+// macOS puts all displays to sleep and wakes them together, there is no way
+// to do that for one display
+func commandDisplayWake() error {
+	// declaring user activity wakes the displays just like moving the mouse
+	return runCommand("/usr/bin/caffeinate", "-u", "-t", "1")
+}
+
+func getScreenSaverStatus() (bool, error) {
+	output, err := getCommandOutput("/usr/bin/osascript", "-e", `tell application "System Events" to running of screen saver preferences`)
+	if err != nil {
+		return false, err
+	}
+
+	return strconv.ParseBool(output)
+}
+
+func commandScreenSaver(start bool) error {
+	if start {
+		return runCommand("/usr/bin/open", "-a", "ScreenSaverEngine")
+	}
+
+	return runCommand("/usr/bin/osascript", "-e", `tell application "System Events" to stop current screen saver`)
+}
+
+func updateScreenSaver(client mqtt.Client) {
+	running, err := getScreenSaverStatus()
+	if err != nil {
+		reportError(client, "updating screensaver", err)
+		return
+	}
+
+	publishState(client, "screensaver", getTopicPrefix()+"/state/screensaver", strconv.FormatBool(running))
+}
+
+func updateDisplays(client mqtt.Client) {
+	d, err := getDisplays()
+	if err != nil {
+		reportError(client, "updating displays", err)
+		return
+	}
+
+	payload, err := json.Marshal(d)
+	if err != nil {
+		logErrorf("Error marshaling displays: %v", err)
+		return
+	}
+
+	publishState(client, "displays", getTopicPrefix()+"/state/displays", payload)
+}
+
+func updateDisplayOn(client mqtt.Client) {
+	on, err := getDisplayOn()
+	if err != nil {
+		reportError(client, "updating display state", err)
+		return
+	}
+
+	publishState(client, "display state", getTopicPrefix()+"/state/display_on", strconv.FormatBool(on))
+}
+
+func updateScreenLocked(client mqtt.Client) {
+	locked, err := getScreenLocked()
+	if err != nil {
+		reportError(client, "updating screen lock", err)
+		return
+	}
+
+	publishState(client, "screen lock", getTopicPrefix()+"/state/screen_locked", strconv.FormatBool(locked))
+}
+
+func publishScreenDiscoveryConfig(client mqtt.Client) {
+	topicPrefix := getTopicPrefix()
+	device := getDevice()
+
+	// Lock device class treats "on" as unlocked, so payloads are inverted
+	screenLockedConfig := BinarySensorConfig{
+		Name:              hostname + " Screen Lock",
+		StateTopic:        topicPrefix + "/state/screen_locked",
+		PayloadOn:         "false",
+		PayloadOff:        "true",
+		UniqueID:          hostname + "_screen_locked",
+		DeviceClass:       "lock",
+		ExpireAfter:       expireAfter(screenInterval),
+		AvailabilityTopic: getAvailabilityTopic(),
+		Device:            device,
+	}
+	publishConfig(client, "binary_sensor", hostname+"_screen_locked", screenLockedConfig)
+
+	displayOnConfig := BinarySensorConfig{
+		Name:              hostname + " Display",
+		Icon:              "mdi:monitor",
+		StateTopic:        topicPrefix + "/state/display_on",
+		PayloadOn:         "true",
+		PayloadOff:        "false",
+		UniqueID:          hostname + "_display_on",
+		DeviceClass:       "power",
+		ExpireAfter:       expireAfter(screenInterval),
+		AvailabilityTopic: getAvailabilityTopic(),
+		Device:            device,
+	}
+	publishConfig(client, "binary_sensor", hostname+"_display_on", displayOnConfig)
+
+	lockScreenButtonConfig := ButtonConfig{
+		Name:              hostname + " Lock Screen",
+		Icon:              "mdi:lock",
+		CommandTopic:      topicPrefix + "/command/lockscreen",
+		PayloadPress:      "lockscreen",
+		UniqueID:          hostname + "_lock_screen",
+		AvailabilityTopic: getAvailabilityTopic(),
+		Device:            device,
+	}
+	publishConfig(client, "button", hostname+"_lock_screen", lockScreenButtonConfig)
+
+	displayWakeButtonConfig := ButtonConfig{
+		Name:              hostname + " Display Wake",
+		Icon:              "mdi:monitor",
+		CommandTopic:      topicPrefix + "/command/displaywake",
+		PayloadPress:      "displaywake",
+		UniqueID:          hostname + "_display_wake",
+		AvailabilityTopic: getAvailabilityTopic(),
+		Device:            device,
+	}
+	publishConfig(client, "button", hostname+"_display_wake", displayWakeButtonConfig)
+
+	screenSaverConfig := BinarySensorConfig{
+		Name:              hostname + " Screensaver",
+		Icon:              "mdi:monitor-shimmer",
+		StateTopic:        topicPrefix + "/state/screensaver",
+		PayloadOn:         "true",
+		PayloadOff:        "false",
+		UniqueID:          hostname + "_screensaver",
+		DeviceClass:       "running",
+		ExpireAfter:       expireAfter(screenSaverInterval),
+		AvailabilityTopic: getAvailabilityTopic(),
+		Device:            device,
+	}
+	publishConfig(client, "binary_sensor", hostname+"_screensaver", screenSaverConfig)
+
+	screenSaverButtonConfig := ButtonConfig{
+		Name:              hostname + " Start Screensaver",
+		Icon:              "mdi:monitor-shimmer",
+		CommandTopic:      topicPrefix + "/command/screensaver",
+		PayloadPress:      "start",
+		UniqueID:          hostname + "_start_screensaver",
+		AvailabilityTopic: getAvailabilityTopic(),
+		Device:            device,
+	}
+	publishConfig(client, "button", hostname+"_start_screensaver", screenSaverButtonConfig)
+
+	if brightnessPath != "" {
+		brightnessNumberConfig := NumberConfig{
+			Name:              hostname + " Display Brightness",
+			Icon:              "mdi:brightness-6",
+			CommandTopic:      topicPrefix + "/command/brightness",
+			StateTopic:        topicPrefix + "/state/brightness",
+			UniqueID:          hostname + "_brightness",
+			Min:               0,
+			Max:               100,
+			AvailabilityTopic: getAvailabilityTopic(),
+			Device:            device,
+		}
+		publishConfig(client, "number", hostname+"_brightness", brightnessNumberConfig)
+	}
+}
+
+func publishDisplaysDiscoveryConfig(client mqtt.Client) {
+	displaysConfig := SensorConfig{
+		Name:                hostname + " Displays",
+		Icon:                "mdi:monitor-multiple",
+		StateTopic:          getTopicPrefix() + "/state/displays",
+		UniqueID:            hostname + "_displays",
+		ValueTemplate:       "{{ value_json.count }}",
+		JsonAttributesTopic: getTopicPrefix() + "/state/displays",
+		ExpireAfter:         expireAfter(systemInterval),
+		AvailabilityTopic:   getAvailabilityTopic(),
+		Device:              getDevice(),
+	}
+	publishConfig(client, "sensor", hostname+"_displays", displaysConfig)
+}
+
+func init() {
+	registerSensor(sensorFuncs{
+		name:     "screen",
+		interval: screenInterval,
+		poll: func(client mqtt.Client) {
+			updateScreenLocked(client)
+			updateDisplayOn(client)
+			if brightnessPath != "" {
+				updateBrightness(client)
+			}
+		},
+		discovery: publishScreenDiscoveryConfig,
+	})
+
+	// part of the screen feature, its config is published with the others
+	registerSensor(sensorFuncs{
+		name:     "screen",
+		interval: screenSaverInterval,
+		poll:     updateScreenSaver,
+	})
+
+	registerSensor(sensorFuncs{
+		name:      "displays",
+		interval:  systemInterval,
+		poll:      updateDisplays,
+		discovery: publishDisplaysDiscoveryConfig,
+	})
+
+	registerCommand("screen", "brightness", func(client mqtt.Client, command string, payload string) {
+		if brightnessPath == "" {
+			return
+		}
+
+		i, err := strconv.Atoi(payload)
+		if err != nil || i < 0 || i > 100 {
+			logWarnf("Incorrect brightness value")
+			return
+		}
+
+		if err := setBrightness(i); err != nil {
+			reportError(client, "setting brightness", err)
+		}
+
+		time.Sleep(1 * time.Second)
+
+		updateBrightness(client)
+	})
+
+	registerCommand("screen", "displaywake", func(client mqtt.Client, command string, payload string) {
+		if payload != "displaywake" {
+			return
+		}
+
+		if err := commandDisplayWake(); err != nil {
+			reportError(client, "waking displays", err)
+		}
+	})
+
+	registerCommand("screen", "screensaver", func(client mqtt.Client, command string, payload string) {
+		if payload != "start" && payload != "stop" {
+			logWarnf("Incorrect screensaver value")
+			return
+		}
+
+		if err := commandScreenSaver(payload == "start"); err != nil {
+			reportError(client, "controlling screensaver", err)
+		}
+
+		time.Sleep(1 * time.Second)
+
+		updateScreenSaver(client)
+	})
+
+	registerCommand("screen", "lockscreen", func(client mqtt.Client, command string, payload string) {
+		if payload != "lockscreen" {
+			return
+		}
+
+		if err := commandLockScreen(); err != nil {
+			reportError(client, "locking screen", err)
+		}
+
+		time.Sleep(1 * time.Second)
+
+		updateScreenLocked(client)
+	})
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// AppleScript entity from the scripts section of mac2mqtt.yaml, e.g.
+//
+//	scripts:
+//	  dark_mode:
+//	    type: switch
+//	    state: tell application "System Events" to get dark mode of appearance preferences
+//	    turn_on: tell application "System Events" to set dark mode of appearance preferences to true
+//	    turn_off: tell application "System Events" to set dark mode of appearance preferences to false
+type scriptConfig struct {
+	// "button", "switch" or "sensor"
+	Type string `yaml:"type"`
+	// Run when the button is pressed
+	Run string `yaml:"run"`
+	// Run when the switch is turned on or off
+	TurnOn  string `yaml:"turn_on"`
+	TurnOff string `yaml:"turn_off"`
+	// Result of the script is the state of the switch (true or false) or the sensor
+	State string `yaml:"state"`
+	Unit  string `yaml:"unit"`
+	Icon  string `yaml:"icon"`
+}
+
+// Fails on scripts that can't become entities, called when the config is read
+func checkScripts(scripts map[string]scriptConfig) {
+	for name, s := range scripts {
+		if getObjectId(name) != name {
+			log.Fatalf("Script name %q in mac2mqtt.yaml can contain only a-z, 0-9 and _", name)
+		}
+
+		switch {
+		case s.Type == "button" && s.Run != "":
+		case s.Type == "switch" && s.TurnOn != "" && s.TurnOff != "" && s.State != "":
+		case s.Type == "sensor" && s.State != "":
+		case s.Type != "button" && s.Type != "switch" && s.Type != "sensor":
+			log.Fatalf("Script %s in mac2mqtt.yaml has type %q, it can be only button, switch or sensor", name, s.Type)
+		default:
+			log.Fatalf("Script %s in mac2mqtt.yaml needs run for a button, turn_on, turn_off and state for a switch and state for a sensor", name)
+		}
+	}
+}
+
+func runAppleScript(name string, script string) (string, error) {
+	output, err := getCommandOutput("/usr/bin/osascript", "-e", script)
+	if err != nil {
+		return "", fmt.Errorf("script %s: %v", name, err)
+	}
+
+	return strings.TrimSpace(output), nil
+}
+
+// Results of the state scripts by script name. Switches are true or false,
+// sensors are the text the script returned
+func getScriptStates() (map[string]any, error) {
+	states := map[string]any{}
+
+	for name, s := range settings.Scripts {
+		if s.State == "" {
+			continue
+		}
+
+		output, err := runAppleScript(name, s.State)
+		if err != nil {
+			return nil, err
+		}
+
+		if s.Type == "switch" {
+			states[name] = output == "true"
+		} else {
+			states[name] = output
+		}
+	}
+
+	return states, nil
+}
+
+func updateScripts(client mqtt.Client) {
+	if len(settings.Scripts) == 0 {
+		return
+	}
+
+	states, err := getScriptStates()
+	if err != nil {
+		reportError(client, "updating scripts", err)
+		return
+	}
+
+	payload, err := json.Marshal(states)
+	if err != nil {
+		logErrorf("Error marshaling scripts: %v", err)
+		return
+	}
+
+	publishState(client, "scripts", getTopicPrefix()+"/state/scripts", payload)
+}
+
+func publishScriptsDiscoveryConfig(client mqtt.Client) {
+	topicPrefix := getTopicPrefix()
+	device := getDevice()
+
+	for name, s := range settings.Scripts {
+		icon := s.Icon
+		if icon == "" {
+			icon = "mdi:script-text-outline"
+		}
+
+		switch s.Type {
+		case "button":
+			scriptButtonConfig := ButtonConfig{
+				Name:              hostname + " " + name,
+				Icon:              icon,
+				CommandTopic:      topicPrefix + "/command/script/" + name,
+				PayloadPress:      "run",
+				UniqueID:          hostname + "_script_" + name,
+				AvailabilityTopic: getAvailabilityTopic(),
+				Device:            device,
+			}
+			publishConfig(client, "button", hostname+"_script_"+name, scriptButtonConfig)
+
+		case "switch":
+			scriptSwitchConfig := SwitchConfig{
+				Name:              hostname + " " + name,
+				Icon:              icon,
+				StateTopic:        topicPrefix + "/state/scripts",
+				ValueTemplate:     "{{ 'ON' if value_json['" + name + "'] else 'OFF' }}",
+				CommandTopic:      topicPrefix + "/command/script/" + name,
+				PayloadOn:         "ON",
+				PayloadOff:        "OFF",
+				UniqueID:          hostname + "_script_" + name,
+				AvailabilityTopic: getAvailabilityTopic(),
+				Device:            device,
+			}
+			publishConfig(client, "switch", hostname+"_script_"+name, scriptSwitchConfig)
+
+		case "sensor":
+			scriptSensorConfig := SensorConfig{
+				Name:              hostname + " " + name,
+				Icon:              icon,
+				StateTopic:        topicPrefix + "/state/scripts",
+				ValueTemplate:     "{{ value_json['" + name + "'] }}",
+				UnitOfMeasurement: s.Unit,
+				UniqueID:          hostname + "_script_" + name,
+				ExpireAfter:       expireAfter(scriptsInterval),
+				AvailabilityTopic: getAvailabilityTopic(),
+				Device:            device,
+			}
+			publishConfig(client, "sensor", hostname+"_script_"+name, scriptSensorConfig)
+		}
+	}
+}
+
+func init() {
+	registerSensor(sensorFuncs{
+		name:      "scripts",
+		interval:  scriptsInterval,
+		poll:      updateScripts,
+		discovery: publishScriptsDiscoveryConfig,
+	})
+
+	registerCommand("scripts", "script/", func(client mqtt.Client, command string, payload string) {
+		name := strings.TrimPrefix(command, "script/")
+		s, ok := settings.Scripts[name]
+
+		var script string
+		switch {
+		case ok && s.Type == "button" && payload == "run":
+			script = s.Run
+		case ok && s.Type == "switch" && payload == "ON":
+			script = s.TurnOn
+		case ok && s.Type == "switch" && payload == "OFF":
+			script = s.TurnOff
+		default:
+			logWarnf("Unknown script %s or incorrect value", name)
+			return
+		}
+
+		if isDryRun("running script " + name) {
+			return
+		}
+
+		if _, err := runAppleScript(name, script); err != nil {
+			reportError(client, "running script", err)
+			return
+		}
+
+		if s.Type == "switch" {
+			updateScripts(client)
+		}
+	})
+}
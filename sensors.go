@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// Every part of mac2mqtt (battery, disks, media...) is a Sensor registered in
+// init() of its file, so adding one doesn't need changes anywhere else
+type Sensor interface {
+	// Name of the feature in the features section of mac2mqtt.yaml, e.g. "battery"
+	Name() string
+	// How often Poll is called. 0 for sensors that publish on change or
+	// have only commands, their Poll is called only after connecting and by
+	// updateAllStates
+	Interval() time.Duration
+	// Publishes the current states with publishState
+	Poll(client mqtt.Client)
+	// Publishes Home Assistant discovery configs with publishConfig
+	DiscoveryConfig(client mqtt.Client)
+}
+
+// Sensor made of functions, both can be nil
+type sensorFuncs struct {
+	name      string
+	interval  time.Duration
+	poll      func(client mqtt.Client)
+	discovery func(client mqtt.Client)
+}
+
+func (s sensorFuncs) Name() string {
+	return s.name
+}
+
+func (s sensorFuncs) Interval() time.Duration {
+	return s.interval
+}
+
+func (s sensorFuncs) Poll(client mqtt.Client) {
+	if s.poll != nil {
+		s.poll(client)
+	}
+}
+
+func (s sensorFuncs) DiscoveryConfig(client mqtt.Client) {
+	if s.discovery != nil {
+		s.discovery(client)
+	}
+}
+
+var sensors []Sensor
+
+// Every feature has its own lock, taken by its polls and its commands. Their
+// states and caches are not safe for concurrent use, but a slow poll, e.g. of
+// the public IP or a user script, holds back only its own feature. The map is
+// filled in init() and only read afterwards
+var featureLocks = map[string]*sync.Mutex{}
+
+func registerSensor(s Sensor) {
+	sensors = append(sensors, s)
+
+	if _, found := featureLocks[s.Name()]; !found {
+		featureLocks[s.Name()] = &sync.Mutex{}
+	}
+}
+
+// Returns the function that unlocks the feature
+func lockFeature(name string) func() {
+	lock, found := featureLocks[name]
+	if !found {
+		return func() {}
+	}
+
+	lock.Lock()
+	return lock.Unlock
+}
+
+func pollSensor(client mqtt.Client, s Sensor) {
+	// commands like osascript would hang or fail while the Mac goes to sleep
+	if isAsleep() {
+		return
+	}
+
+	unlock := lockFeature(s.Name())
+	defer unlock()
+
+	s.Poll(client)
+	recordPoll(s.Name())
+}
+
+// Features are on unless they are turned off in mac2mqtt.yaml:
+//
+//	features:
+//	  shutdown: false
+func isFeatureEnabled(name string) bool {
+	enabled, found := settings.Features[name]
+	return !found || enabled
+}
+
+func isFeature(name string) bool {
+	for _, s := range sensors {
+		if s.Name() == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Client for DiscoveryConfig of turned off features, publishConfig sends
+// empty configs through it
+type configRemover struct {
+	mqtt.Client
+}
+
+// Entities of turned off features are removed from Home Assistant, they may
+// have been announced before the feature was turned off
+func publishSensorsDiscoveryConfig(client mqtt.Client) {
+	for _, s := range sensors {
+		if isFeatureEnabled(s.Name()) {
+			s.DiscoveryConfig(client)
+		} else {
+			s.DiscoveryConfig(configRemover{client})
+		}
+	}
+}
+
+// Polls the sensors that publish on change, after connecting to MQTT they
+// have to send their current states once
+func updateEventStates(client mqtt.Client) {
+	for _, s := range sensors {
+		if s.Interval() == 0 && isFeatureEnabled(s.Name()) {
+			pollSensor(client, s)
+		}
+	}
+}
+
+// Publishes every state right away instead of waiting for the tickers
+func updateAllStates(client mqtt.Client) {
+	for _, s := range sensors {
+		if isFeatureEnabled(s.Name()) {
+			pollSensor(client, s)
+		}
+	}
+}
+
+// Polls every sensor on its own ticker until ctx is done
+func runSensors(ctx context.Context, client mqtt.Client, wg *sync.WaitGroup) {
+	for _, s := range sensors {
+		if s.Interval() == 0 || !isFeatureEnabled(s.Name()) {
+			continue
+		}
+
+		wg.Add(1)
+		go func(s Sensor) {
+			defer wg.Done()
+
+			ticker := time.NewTicker(s.Interval())
+			defer ticker.Stop()
+
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+					pollSensor(client, s)
+				}
+			}
+		}(s)
+	}
+}
+
+// Handles a message sent to PREFIX/command/NAME. command is NAME, e.g.
+// "volume" or "run/backup"
+type commandHandler func(client mqtt.Client, command string, payload string)
+
+type registeredCommand struct {
+	// name of the sensor the command belongs to
+	feature string
+	handler commandHandler
+}
+
+var commandHandlers = map[string]registeredCommand{}
+
+// A name that ends with "/" handles all commands that start with it
+func registerCommand(feature string, name string, handler commandHandler) {
+	commandHandlers[name] = registeredCommand{feature: feature, handler: handler}
+}
+
+var errUnknownCommand = errors.New("unknown command")
+
+func handleCommand(client mqtt.Client, command string, payload string) error {
+	c, found := commandHandlers[command]
+	if !found {
+		if prefix, _, cut := strings.Cut(command, "/"); cut {
+			c, found = commandHandlers[prefix+"/"]
+		}
+	}
+
+	if !found {
+		return errUnknownCommand
+	}
+
+	if !isFeatureEnabled(c.feature) {
+		return fmt.Errorf("feature %s is turned off in mac2mqtt.yaml", c.feature)
+	}
+
+	if isSignatureRequired(command) {
+		signed, err := verifySignedCommand(command, payload)
+		if err != nil {
+			return err
+		}
+		payload = signed
+	}
+
+	unlock := lockFeature(c.feature)
+	defer unlock()
+
+	c.handler(client, command, payload)
+
+	return nil
+}
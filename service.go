@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+const serviceLabel = "com.bessarabov.mac2mqtt"
+
+func getServicePlistPath() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(home, "Library/LaunchAgents", serviceLabel+".plist"), nil
+}
+
+// launchd domain of the LaunchAgents of the current user
+func getServiceDomain() string {
+	return "gui/" + strconv.Itoa(os.Getuid())
+}
+
+func xmlEscape(s string) string {
+	var b bytes.Buffer
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+func getServicePlist(executable, configPath, logPath string) string {
+	return fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+    <dict>
+        <key>Label</key>
+        <string>%s</string>
+        <key>ProgramArguments</key>
+        <array>
+            <string>%s</string>
+            <string>-config</string>
+            <string>%s</string>
+        </array>
+        <key>RunAtLoad</key>
+        <true/>
+        <key>KeepAlive</key>
+        <true/>
+        <key>StandardOutPath</key>
+        <string>%s</string>
+        <key>StandardErrorPath</key>
+        <string>%s</string>
+    </dict>
+</plist>
+`, serviceLabel, xmlEscape(executable), xmlEscape(configPath), xmlEscape(logPath), xmlEscape(logPath))
+}
+
+// Creates a LaunchAgent that starts mac2mqtt at login and keeps it running,
+// and starts it right away
+func installService(configPath string) error {
+	if configPath == "" {
+		configPath = findConfigFile()
+	}
+	if configPath == "" {
+		return fmt.Errorf("can't find mac2mqtt.yaml, use -config to set the path")
+	}
+
+	// launchd starts the agent in /, so all paths must be absolute
+	configPath, err := filepath.Abs(configPath)
+	if err != nil {
+		return err
+	}
+
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	executable, err = filepath.EvalSymlinks(executable)
+	if err != nil {
+		return err
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return err
+	}
+	logPath := filepath.Join(home, "Library/Logs/mac2mqtt.log")
+
+	plistPath, err := getServicePlistPath()
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(plistPath), 0755); err != nil {
+		return err
+	}
+
+	// an agent that is already loaded must be unloaded to pick up the new plist
+	runCommand("/bin/launchctl", "bootout", getServiceDomain()+"/"+serviceLabel)
+
+	if err := os.WriteFile(plistPath, []byte(getServicePlist(executable, configPath, logPath)), 0644); err != nil {
+		return err
+	}
+
+	if err := runCommand("/bin/launchctl", "bootstrap", getServiceDomain(), plistPath); err != nil {
+		return err
+	}
+
+	fmt.Printf("Installed %s\nLog is written to %s\n", plistPath, logPath)
+
+	return nil
+}
+
+// Stops the LaunchAgent and removes its plist
+func uninstallService() error {
+	plistPath, err := getServicePlistPath()
+	if err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(plistPath); os.IsNotExist(err) {
+		return fmt.Errorf("%s is not installed", plistPath)
+	}
+
+	if err := runCommand("/bin/launchctl", "bootout", getServiceDomain()+"/"+serviceLabel); err != nil {
+		// not loaded, the plist is removed anyway
+		fmt.Printf("Service was not running: %v\n", err)
+	}
+
+	if err := os.Remove(plistPath); err != nil {
+		return err
+	}
+
+	fmt.Printf("Removed %s\n", plistPath)
+
+	return nil
+}
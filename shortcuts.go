@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// JSON payload of PREFIX/command/shortcut. A payload that is not JSON is the shortcut name
+type shortcutCommand struct {
+	Name  string `json:"name"`
+	Input string `json:"input"`
+}
+
+func parseShortcutCommand(payload string) shortcutCommand {
+	var c shortcutCommand
+
+	if strings.HasPrefix(strings.TrimSpace(payload), "{") && json.Unmarshal([]byte(payload), &c) == nil {
+		return c
+	}
+
+	return shortcutCommand{Name: payload}
+}
+
+func commandShortcut(c shortcutCommand) error {
+	if isDryRun("running shortcut " + c.Name) {
+		return nil
+	}
+
+	args := []string{"run", c.Name}
+
+	// shortcuts can only read input from a file
+	if c.Input != "" {
+		f, err := os.CreateTemp("", "mac2mqtt-shortcut-*.txt")
+		if err != nil {
+			return fmt.Errorf("creating shortcut input file: %v", err)
+		}
+		defer os.Remove(f.Name())
+
+		_, err = f.WriteString(c.Input)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("writing shortcut input file: %v", err)
+		}
+
+		args = append(args, "--input-path", f.Name())
+	}
+
+	return runCommandTimeout(longCommandTimeout, "/usr/bin/shortcuts", args...)
+}
+
+func publishShortcutsDiscoveryConfig(client mqtt.Client) {
+	topicPrefix := getTopicPrefix()
+	device := getDevice()
+
+	for _, name := range settings.Shortcuts {
+		id := getObjectId(name)
+
+		shortcutButtonConfig := ButtonConfig{
+			Name:              hostname + " " + name,
+			Icon:              "mdi:layers-triple",
+			CommandTopic:      topicPrefix + "/command/shortcut",
+			PayloadPress:      name,
+			UniqueID:          hostname + "_shortcut_" + id,
+			AvailabilityTopic: getAvailabilityTopic(),
+			Device:            device,
+		}
+		publishConfig(client, "button", hostname+"_shortcut_"+id, shortcutButtonConfig)
+	}
+}
+
+func init() {
+	registerSensor(sensorFuncs{
+		name:      "shortcuts",
+		discovery: publishShortcutsDiscoveryConfig,
+	})
+
+	registerCommand("shortcuts", "shortcut", func(client mqtt.Client, command string, payload string) {
+		c := parseShortcutCommand(payload)
+		if c.Name == "" {
+			logWarnf("Incorrect shortcut value")
+			return
+		}
+
+		// shortcuts can run for a long time, don't block other commands
+		go func() {
+			if err := commandShortcut(c); err != nil {
+				reportError(client, "running shortcut", err)
+			}
+		}()
+	})
+}
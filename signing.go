@@ -0,0 +1,69 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bessarabov/mac2mqtt/internal/signing"
+)
+
+// Commands that can take the Mac away or run code can require a signature, so
+// that whoever can write to the MQTT server or controls Home Assistant can't
+// send them. The payload of such a command is
+//
+//	{"payload":"shutdown","time":1712736000,"signature":"HEX"}
+//
+// where signature is HMAC-SHA256 of "COMMAND\nPAYLOAD\nTIME" with the shared
+// secret, e.g. "shutdown\nshutdown\n1712736000". `mac2mqtt sign` prints it
+type commandSigningConfig struct {
+	Secret string `yaml:"secret"`
+	// Keychain service name of the secret, used instead of secret
+	SecretKeychain string `yaml:"secret_keychain"`
+	// Commands that need a signature, "run" covers all run/NAME commands
+	Commands []string `yaml:"commands"`
+	// Seconds a signature is valid
+	MaxAge int `yaml:"max_age"`
+}
+
+func isSigningEnabled() bool {
+	return settings.CommandSigning.Secret != ""
+}
+
+func isSignatureRequired(command string) bool {
+	if !isSigningEnabled() {
+		return false
+	}
+
+	name, _, _ := strings.Cut(command, "/")
+	for _, c := range settings.CommandSigning.Commands {
+		if c == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+func signCommand(command string, payload string) ([]byte, error) {
+	if !isSigningEnabled() {
+		return nil, errors.New("command_signing.secret is not set in mac2mqtt.yaml")
+	}
+
+	return signing.Sign(settings.CommandSigning.Secret, command, payload, time.Now().Unix())
+}
+
+// The verifier remembers used signatures, it is created once the secret
+// is known
+var commandVerifier *signing.Verifier
+var commandVerifierOnce sync.Once
+
+// Returns the payload inside a signed command
+func verifySignedCommand(command string, message string) (string, error) {
+	commandVerifierOnce.Do(func() {
+		commandVerifier = signing.NewVerifier(settings.CommandSigning.Secret, int64(settings.CommandSigning.MaxAge))
+	})
+
+	return commandVerifier.Verify(command, message, time.Now().Unix())
+}
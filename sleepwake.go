@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"os/exec"
+	"sync/atomic"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// True between the sleep notification and the wake up. Sensors are not polled
+// and states are not published, the network may already be gone
+var systemAsleep atomic.Bool
+
+// Unix time in nanoseconds of the last sleep notification
+var sleepNotifiedAt atomic.Int64
+
+func isAsleep() bool {
+	return systemAsleep.Load()
+}
+
+// How often the clock is checked for a wake up. Go timers stop while the Mac
+// sleeps, so a wall clock jump bigger than this means it slept
+const wakeCheckInterval = 5 * time.Second
+
+// A sleep can be cancelled after the notification, e.g. by an app holding
+// an assertion. Without a clock jump for this long the Mac is taken as awake,
+// it is much longer than the few seconds between the notification and sleep
+const sleepCancelTimeout = 60 * time.Second
+
+func onSystemSleep(client mqtt.Client) {
+	if systemAsleep.Swap(true) {
+		return
+	}
+	sleepNotifiedAt.Store(time.Now().UnixNano())
+
+	logInfof("System is going to sleep, publishing offline")
+
+	// The broker would publish the will only after the keep alive timeout,
+	// long after the Mac is asleep
+	updateAvailability(client, false)
+}
+
+func onSystemWake(client mqtt.Client, slept time.Duration) {
+	systemAsleep.Store(false)
+
+	logInfof("System woke up after %v", slept.Round(time.Second))
+
+	// The connection to the broker usually died during sleep, but the client
+	// notices it only after the keep alive timeout and publishing fails until
+	// then. A publish that doesn't go through means the connection has to be
+	// made again, connectHandler then publishes everything
+	token := client.Publish(getAvailabilityTopic(), settings.QoS.State, true, "online")
+	if !token.WaitTimeout(2*time.Second) || token.Error() != nil {
+		logInfof("Connection to MQTT was lost during sleep, reconnecting")
+		reconnectMQTT()
+		return
+	}
+
+	resetStateCache()
+	updateAllStates(client)
+}
+
+// Drops the connection to the main broker and connects again right away,
+// instead of waiting for the keep alive timeout and the reconnect interval
+func reconnectMQTT() {
+	client.Disconnect(0)
+
+	token := client.Connect()
+	if !token.WaitTimeout(10 * time.Second) {
+		logWarnf("Reconnect to MQTT timed out, the client keeps retrying")
+	} else if token.Error() != nil {
+		logWarnf("Failed to reconnect: %v, the client keeps retrying", token.Error())
+	}
+}
+
+// Follows the system log for the sleep notification. powerd writes it a few
+// seconds before the Mac sleeps, which is enough to publish "offline"
+func watchSystemSleep(ctx context.Context, client mqtt.Client) {
+	for {
+		cmd := exec.CommandContext(ctx, "/usr/bin/log", "stream", "--style", "compact",
+			"--predicate", `process == "powerd" AND eventMessage CONTAINS "Entering Sleep state"`)
+
+		stdout, err := cmd.StdoutPipe()
+		if err == nil {
+			err = cmd.Start()
+		}
+		if err != nil {
+			logErrorf("Error starting log stream: %v", err)
+			if !sleepContext(ctx, 10*time.Second) {
+				return
+			}
+			continue
+		}
+
+		scanner := bufio.NewScanner(stdout)
+		// the first line is the "Filtering the log data using ..." header
+		scanner.Scan()
+		for scanner.Scan() {
+			onSystemSleep(client)
+		}
+
+		err = cmd.Wait()
+		if ctx.Err() != nil {
+			return
+		}
+
+		logWarnf("log stream exited: %v. Restarting in 10 seconds...", err)
+		if !sleepContext(ctx, 10*time.Second) {
+			return
+		}
+	}
+}
+
+// Notices wake ups by the wall clock, it runs on even if the sleep
+// notification was missed
+func watchSystemWake(ctx context.Context, client mqtt.Client) {
+	ticker := time.NewTicker(wakeCheckInterval)
+	defer ticker.Stop()
+
+	// Round(0) drops the monotonic clock, which doesn't count the time asleep
+	last := time.Now().Round(0)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			now := time.Now().Round(0)
+			elapsed := now.Sub(last)
+			last = now
+
+			// ticks between the sleep notification and the sleep itself
+			// must not count as a wake up
+			if elapsed > 3*wakeCheckInterval {
+				onSystemWake(client, elapsed)
+			} else if isAsleep() && time.Since(time.Unix(0, sleepNotifiedAt.Load())) > sleepCancelTimeout {
+				logInfof("System didn't go to sleep after the notification")
+				onSystemWake(client, 0)
+			}
+		}
+	}
+}
+
+func watchSleepWake(ctx context.Context, client mqtt.Client) {
+	go watchSystemSleep(ctx, client)
+	watchSystemWake(ctx, client)
+}
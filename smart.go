@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// Full path of smartctl from smartmontools, empty when it is not installed.
+// Without it only the SMART status from diskutil is known
+var smartctlPath string
+
+type ssdHealth struct {
+	// SMART status says the disk is fine
+	Passed bool `json:"passed"`
+	// Estimated wear in percent of the rated endurance, can go over 100
+	PercentageUsed *int `json:"percentage_used"`
+	// Celsius
+	Temperature *int `json:"temperature"`
+	// Percent of the spare blocks left
+	AvailableSpare *int `json:"available_spare"`
+	// Terabytes written in the whole life of the disk
+	DataWrittenTB *float64 `json:"data_written_tb"`
+}
+
+func getSSDHealthSmartctl() (ssdHealth, error) {
+	// smartctl sets bits of the exit code for things it finds about the
+	// disk, its output is still valid then
+	output, _ := getCommandOutput(smartctlPath, "-j", "-a", "disk0")
+
+	// $ smartctl -j -a disk0
+	// {"smart_status":{"passed":true},"nvme_smart_health_information_log":{"critical_warning":0,
+	//     "temperature":31,"available_spare":100,"percentage_used":2,"data_units_written":41934873,...}}
+
+	var data struct {
+		SmartStatus *struct {
+			Passed bool `json:"passed"`
+		} `json:"smart_status"`
+		NVMe *struct {
+			Temperature      int   `json:"temperature"`
+			AvailableSpare   int   `json:"available_spare"`
+			PercentageUsed   int   `json:"percentage_used"`
+			DataUnitsWritten int64 `json:"data_units_written"`
+		} `json:"nvme_smart_health_information_log"`
+	}
+	if err := json.Unmarshal([]byte(output), &data); err != nil {
+		return ssdHealth{}, fmt.Errorf("parsing smartctl output: %v", err)
+	}
+	if data.SmartStatus == nil {
+		return ssdHealth{}, fmt.Errorf("no SMART status in the output of smartctl")
+	}
+
+	health := ssdHealth{Passed: data.SmartStatus.Passed}
+
+	if data.NVMe != nil {
+		// a data unit is 1000 blocks of 512 bytes
+		written := round2(float64(data.NVMe.DataUnitsWritten) * 512000 / 1e12)
+
+		health.Temperature = &data.NVMe.Temperature
+		health.AvailableSpare = &data.NVMe.AvailableSpare
+		health.PercentageUsed = &data.NVMe.PercentageUsed
+		health.DataWrittenTB = &written
+	}
+
+	return health, nil
+}
+
+func getSSDHealthDiskutil() (ssdHealth, error) {
+	output, err := getCommandOutput("/usr/sbin/diskutil", "info", "disk0")
+	if err != nil {
+		return ssdHealth{}, err
+	}
+
+	// $ /usr/sbin/diskutil info disk0
+	// ...
+	//    SMART Status:              Verified
+	// ...
+
+	r := regexp.MustCompile(`SMART Status:\s+(.+)`)
+	m := r.FindStringSubmatch(output)
+	if m == nil {
+		return ssdHealth{}, fmt.Errorf("can't find SMART status in the output of diskutil info")
+	}
+
+	return ssdHealth{Passed: m[1] == "Verified"}, nil
+}
+
+func getSSDHealth() (ssdHealth, error) {
+	if smartctlPath != "" {
+		return getSSDHealthSmartctl()
+	}
+
+	return getSSDHealthDiskutil()
+}
+
+func updateSSDHealth(client mqtt.Client) {
+	health, err := getSSDHealth()
+	if err != nil {
+		reportError(client, "updating ssd health", err)
+		return
+	}
+
+	payload, err := json.Marshal(health)
+	if err != nil {
+		logErrorf("Error marshaling ssd health: %v", err)
+		return
+	}
+
+	publishState(client, "ssd health", getTopicPrefix()+"/state/ssd_health", payload)
+}
+
+func publishSSDHealthDiscoveryConfig(client mqtt.Client) {
+	topicPrefix := getTopicPrefix()
+	device := getDevice()
+
+	smartStatusConfig := BinarySensorConfig{
+		Name:              hostname + " SSD Health",
+		Icon:              "mdi:harddisk",
+		EntityCategory:    "diagnostic",
+		StateTopic:        topicPrefix + "/state/ssd_health",
+		ValueTemplate:     "{{ 'OFF' if value_json.passed else 'ON' }}",
+		DeviceClass:       "problem",
+		UniqueID:          hostname + "_ssd_health",
+		ExpireAfter:       expireAfter(smartInterval),
+		AvailabilityTopic: getAvailabilityTopic(),
+		Device:            device,
+	}
+	publishConfig(client, "binary_sensor", hostname+"_ssd_health", smartStatusConfig)
+
+	if smartctlPath == "" {
+		return
+	}
+
+	sensors := []struct {
+		id, name, icon, unit, deviceClass, stateClass string
+	}{
+		{"percentage_used", "SSD Wear", "mdi:harddisk", "%", "", "measurement"},
+		{"temperature", "SSD Temperature", "", "°C", "temperature", "measurement"},
+		{"available_spare", "SSD Available Spare", "mdi:harddisk-plus", "%", "", "measurement"},
+		{"data_written_tb", "SSD Data Written", "", "TB", "data_size", "total_increasing"},
+	}
+
+	for _, s := range sensors {
+		ssdSensorConfig := SensorConfig{
+			Name:              hostname + " " + s.name,
+			Icon:              s.icon,
+			EntityCategory:    "diagnostic",
+			StateTopic:        topicPrefix + "/state/ssd_health",
+			ValueTemplate:     "{{ value_json." + s.id + " }}",
+			UnitOfMeasurement: s.unit,
+			DeviceClass:       s.deviceClass,
+			StateClass:        s.stateClass,
+			UniqueID:          hostname + "_ssd_" + s.id,
+			ExpireAfter:       expireAfter(smartInterval),
+			AvailabilityTopic: getAvailabilityTopic(),
+			Device:            device,
+		}
+		publishConfig(client, "sensor", hostname+"_ssd_"+s.id, ssdSensorConfig)
+	}
+}
+
+func init() {
+	registerSensor(sensorFuncs{
+		name:      "ssd_health",
+		interval:  smartInterval,
+		poll:      updateSSDHealth,
+		discovery: publishSSDHealthDiscoveryConfig,
+	})
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"sync/atomic"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+type softwareUpdate struct {
+	Label   string `json:"label"`
+	Title   string `json:"title"`
+	Version string `json:"version"`
+	// the update needs a restart to install
+	Restart bool `json:"restart"`
+}
+
+// Payload of PREFIX/state/software_updates. The version fields are the ones
+// the Home Assistant update entity reads from a JSON state
+type softwareUpdatesState struct {
+	InstalledVersion string           `json:"installed_version"`
+	LatestVersion    string           `json:"latest_version"`
+	Title            string           `json:"title"`
+	Count            int              `json:"count"`
+	Updates          []softwareUpdate `json:"updates"`
+	// a pending update needs a restart to finish installing
+	RestartRequired bool `json:"restart_required"`
+}
+
+func getSoftwareUpdates() ([]softwareUpdate, error) {
+	output, err := getCommandOutputTimeout(longCommandTimeout, "/usr/sbin/softwareupdate", "--list")
+	if err != nil {
+		return nil, err
+	}
+
+	// $ /usr/sbin/softwareupdate --list
+	// Software Update Tool
+	//
+	// Finding available software
+	// Software Update found the following new or updated software:
+	// * Label: macOS Sonoma 14.4.1-23E224
+	// 	Title: macOS Sonoma 14.4.1, Version: 14.4.1, Size: 1234567KiB, Recommended: YES, Action: restart,
+	// * Label: Safari17.4.1SonomaAuto-17.4.1
+	// 	Title: Safari, Version: 17.4.1, Size: 156789KiB, Recommended: YES,
+	//
+	// Without updates "No new software available." is printed to stderr
+
+	updates := []softwareUpdate{}
+
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+
+		if label, found := strings.CutPrefix(line, "* Label: "); found {
+			updates = append(updates, softwareUpdate{Label: label})
+			continue
+		}
+
+		if !strings.HasPrefix(line, "Title: ") || len(updates) == 0 {
+			continue
+		}
+
+		update := &updates[len(updates)-1]
+		for _, field := range strings.Split(line, ", ") {
+			key, value, _ := strings.Cut(field, ": ")
+			value = strings.TrimSuffix(value, ",")
+
+			switch key {
+			case "Title":
+				update.Title = value
+			case "Version":
+				update.Version = value
+			case "Action":
+				update.Restart = value == "restart"
+			}
+		}
+	}
+
+	return updates, nil
+}
+
+func getSoftwareUpdatesState() (softwareUpdatesState, error) {
+	updates, err := getSoftwareUpdates()
+	if err != nil {
+		return softwareUpdatesState{}, err
+	}
+
+	state := softwareUpdatesState{
+		InstalledVersion: hardware.OSVersion,
+		LatestVersion:    hardware.OSVersion,
+		Title:            "macOS",
+		Count:            len(updates),
+		Updates:          updates,
+	}
+
+	for _, update := range updates {
+		if update.Restart {
+			state.RestartRequired = true
+		}
+	}
+
+	for _, update := range updates {
+		if strings.HasPrefix(update.Label, "macOS") {
+			state.LatestVersion = update.Version
+			state.Title = update.Title
+			break
+		}
+	}
+
+	return state, nil
+}
+
+// softwareupdate asks Apple's servers and can take minutes, so it runs in
+// its own goroutine and other sensors don't wait for it
+var softwareUpdateChecking atomic.Bool
+
+func updateSoftwareUpdates(client mqtt.Client) {
+	if !softwareUpdateChecking.CompareAndSwap(false, true) {
+		return
+	}
+
+	go func() {
+		defer softwareUpdateChecking.Store(false)
+
+		state, err := getSoftwareUpdatesState()
+		if err != nil {
+			reportError(client, "updating software updates", err)
+			return
+		}
+
+		payload, err := json.Marshal(state)
+		if err != nil {
+			logErrorf("Error marshaling software updates: %v", err)
+			return
+		}
+
+		publishState(client, "software updates", getTopicPrefix()+"/state/software_updates", payload)
+	}()
+}
+
+func publishSoftwareUpdateDiscoveryConfig(client mqtt.Client) {
+	topicPrefix := getTopicPrefix()
+	device := getDevice()
+
+	updateConfig := UpdateConfig{
+		Name:              hostname + " macOS",
+		StateTopic:        topicPrefix + "/state/software_updates",
+		UniqueID:          hostname + "_macos_update",
+		DeviceClass:       "firmware",
+		AvailabilityTopic: getAvailabilityTopic(),
+		Device:            device,
+	}
+	publishConfig(client, "update", hostname+"_macos_update", updateConfig)
+
+	countConfig := SensorConfig{
+		Name:                hostname + " Software Updates",
+		Icon:                "mdi:package-up",
+		StateTopic:          topicPrefix + "/state/software_updates",
+		UniqueID:            hostname + "_software_updates",
+		ValueTemplate:       "{{ value_json.count }}",
+		JsonAttributesTopic: topicPrefix + "/state/software_updates",
+		ExpireAfter:         expireAfter(softwareUpdateInterval),
+		AvailabilityTopic:   getAvailabilityTopic(),
+		Device:              device,
+	}
+	publishConfig(client, "sensor", hostname+"_software_updates", countConfig)
+
+	restartRequiredConfig := BinarySensorConfig{
+		Name:              hostname + " Restart Required",
+		Icon:              "mdi:restart-alert",
+		StateTopic:        topicPrefix + "/state/software_updates",
+		ValueTemplate:     "{{ 'ON' if value_json.restart_required else 'OFF' }}",
+		UniqueID:          hostname + "_restart_required",
+		DeviceClass:       "update",
+		ExpireAfter:       expireAfter(softwareUpdateInterval),
+		AvailabilityTopic: getAvailabilityTopic(),
+		Device:            device,
+	}
+	publishConfig(client, "binary_sensor", hostname+"_restart_required", restartRequiredConfig)
+}
+
+func init() {
+	registerSensor(sensorFuncs{
+		name:      "software_updates",
+		interval:  softwareUpdateInterval,
+		poll:      updateSoftwareUpdates,
+		discovery: publishSoftwareUpdateDiscoveryConfig,
+	})
+}
@@ -0,0 +1,380 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+func getBootTime() (time.Time, error) {
+	output, err := getCommandOutput("/usr/sbin/sysctl", "-n", "kern.boottime")
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	// $ /usr/sbin/sysctl -n kern.boottime
+	// { sec = 1712736000, usec = 523412 } Wed Apr 10 10:00:00 2024
+
+	r := regexp.MustCompile(`sec = (\d+)`)
+	m := r.FindStringSubmatch(output)
+	if m == nil {
+		return time.Time{}, errors.New("can't find boot time in the output of sysctl kern.boottime")
+	}
+
+	sec, err := strconv.ParseInt(m[1], 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return time.Unix(sec, 0), nil
+}
+
+type loadAverage struct {
+	Load1  float64 `json:"load_1"`
+	Load5  float64 `json:"load_5"`
+	Load15 float64 `json:"load_15"`
+}
+
+func getLoadAverage() (loadAverage, error) {
+	output, err := getCommandOutput("/usr/sbin/sysctl", "-n", "vm.loadavg")
+	if err != nil {
+		return loadAverage{}, err
+	}
+
+	// $ /usr/sbin/sysctl -n vm.loadavg
+	// { 1.52 1.71 1.80 }
+
+	r := regexp.MustCompile(`\{ ([\d.]+) ([\d.]+) ([\d.]+) \}`)
+	m := r.FindStringSubmatch(output)
+	if m == nil {
+		return loadAverage{}, errors.New("can't find load average in the output of sysctl vm.loadavg")
+	}
+
+	var load [3]float64
+	for i := range load {
+		load[i], err = strconv.ParseFloat(m[i+1], 64)
+		if err != nil {
+			return loadAverage{}, err
+		}
+	}
+
+	return loadAverage{Load1: load[0], Load5: load[1], Load15: load[2]}, nil
+}
+
+type topProcess struct {
+	Name string  `json:"name"`
+	PID  int     `json:"pid"`
+	CPU  float64 `json:"cpu"`
+}
+
+// The process using the most CPU. ps reports CPU averaged over the last
+// minute, 100 is one full core
+func getTopProcess() (topProcess, error) {
+	output, err := getCommandOutput("/bin/ps", "-Aceo", "pid=,pcpu=,comm=", "-r")
+	if err != nil {
+		return topProcess{}, err
+	}
+
+	// $ /bin/ps -Aceo pid=,pcpu=,comm= -r
+	//   612  87.3 WindowServer
+	//  1450  12.0 Google Chrome He
+	// ...
+
+	line, _, _ := strings.Cut(output, "\n")
+	fields := strings.Fields(line)
+	if len(fields) < 3 {
+		return topProcess{}, fmt.Errorf("unexpected ps output: %s", line)
+	}
+
+	pid, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return topProcess{}, err
+	}
+
+	cpu, err := strconv.ParseFloat(fields[1], 64)
+	if err != nil {
+		return topProcess{}, err
+	}
+
+	return topProcess{Name: strings.Join(fields[2:], " "), PID: pid, CPU: cpu}, nil
+}
+
+// Hardware UUID of the Mac, it doesn't change when the Mac is renamed
+func getPlatformUUID() (string, error) {
+	output, err := getCommandOutput("/usr/sbin/ioreg", "-rd1", "-c", "IOPlatformExpertDevice")
+	if err != nil {
+		return "", err
+	}
+
+	// $ /usr/sbin/ioreg -rd1 -c IOPlatformExpertDevice
+	// +-o J314sAP  <class IOPlatformExpertDevice, id 0x100000110, registered, matched, active, busy 0 (7 ms), retain 34>
+	//   {
+	//     "IOPlatformUUID" = "2B5C1A7E-8F3D-5E2A-9C41-7D0E6B3F1A22"
+	//   ...
+
+	r := regexp.MustCompile(`"IOPlatformUUID" = "([0-9A-F-]+)"`)
+	m := r.FindStringSubmatch(output)
+	if m == nil {
+		return "", errors.New("can't find IOPlatformUUID in the output of ioreg")
+	}
+
+	return m[1], nil
+}
+
+type hardwareInfo struct {
+	// "MacBook Pro"
+	Model string
+	// "Mac14,9"
+	ModelID      string
+	SerialNumber string
+	// "14.4.1"
+	OSVersion string
+	// "23E224"
+	OSBuild string
+}
+
+func getHardwareInfo() (hardwareInfo, error) {
+	var info hardwareInfo
+
+	output, err := getCommandOutput("/usr/sbin/system_profiler", "SPHardwareDataType", "-json")
+	if err != nil {
+		return info, err
+	}
+
+	// $ /usr/sbin/system_profiler SPHardwareDataType -json
+	// {"SPHardwareDataType":[{"machine_model":"Mac14,9","machine_name":"MacBook Pro",
+	//     "serial_number":"C02XL0AAJGH5",...}]}
+
+	var data struct {
+		SPHardwareDataType []struct {
+			MachineModel string `json:"machine_model"`
+			MachineName  string `json:"machine_name"`
+			SerialNumber string `json:"serial_number"`
+		} `json:"SPHardwareDataType"`
+	}
+	if err := json.Unmarshal([]byte(output), &data); err != nil {
+		return info, fmt.Errorf("parsing system_profiler output: %v", err)
+	}
+	if len(data.SPHardwareDataType) == 0 {
+		return info, errors.New("no hardware in the output of system_profiler")
+	}
+
+	info.Model = data.SPHardwareDataType[0].MachineName
+	info.ModelID = data.SPHardwareDataType[0].MachineModel
+	info.SerialNumber = data.SPHardwareDataType[0].SerialNumber
+
+	// $ /usr/bin/sw_vers -productVersion
+	// 14.4.1
+	info.OSVersion, err = getCommandOutput("/usr/bin/sw_vers", "-productVersion")
+	if err != nil {
+		return info, err
+	}
+
+	// $ /usr/bin/sw_vers -buildVersion
+	// 23E224
+	info.OSBuild, err = getCommandOutput("/usr/bin/sw_vers", "-buildVersion")
+	if err != nil {
+		return info, err
+	}
+
+	return info, nil
+}
+
+func updateUptime(client mqtt.Client) {
+	bootTime, err := getBootTime()
+	if err != nil {
+		reportError(client, "updating uptime", err)
+		return
+	}
+	uptime := int(time.Since(bootTime).Seconds())
+
+	publishState(client, "uptime", getTopicPrefix()+"/state/uptime", strconv.Itoa(uptime))
+	publishState(client, "last boot", getTopicPrefix()+"/state/last_boot", bootTime.UTC().Format(time.RFC3339))
+}
+
+func updateLoadAverage(client mqtt.Client) {
+	load, err := getLoadAverage()
+	if err != nil {
+		reportError(client, "updating load average", err)
+		return
+	}
+
+	payload, err := json.Marshal(load)
+	if err != nil {
+		logErrorf("Error marshaling load average: %v", err)
+		return
+	}
+
+	publishState(client, "load average", getTopicPrefix()+"/state/load_average", payload)
+}
+
+func updateTopProcess(client mqtt.Client) {
+	process, err := getTopProcess()
+	if err != nil {
+		reportError(client, "updating top process", err)
+		return
+	}
+
+	payload, err := json.Marshal(process)
+	if err != nil {
+		logErrorf("Error marshaling top process: %v", err)
+		return
+	}
+
+	publishState(client, "top process", getTopicPrefix()+"/state/top_process", payload)
+}
+
+// The version can change only with a restart, which restarts mac2mqtt too
+func updateOSVersion(client mqtt.Client) {
+	if hardware.OSVersion == "" {
+		return
+	}
+
+	payload, err := json.Marshal(map[string]string{
+		"version": hardware.OSVersion,
+		"build":   hardware.OSBuild,
+	})
+	if err != nil {
+		logErrorf("Error marshaling os version: %v", err)
+		return
+	}
+
+	publishState(client, "os version", getTopicPrefix()+"/state/os_version", payload)
+}
+
+func publishSystemDiscoveryConfig(client mqtt.Client) {
+	topicPrefix := getTopicPrefix()
+	device := getDevice()
+
+	uptimeConfig := SensorConfig{
+		Name:              hostname + " Uptime",
+		Icon:              "mdi:timer-outline",
+		EntityCategory:    "diagnostic",
+		StateTopic:        topicPrefix + "/state/uptime",
+		UniqueID:          hostname + "_uptime",
+		UnitOfMeasurement: "s",
+		DeviceClass:       "duration",
+		ExpireAfter:       expireAfter(systemInterval),
+		AvailabilityTopic: getAvailabilityTopic(),
+		Device:            device,
+	}
+	publishConfig(client, "sensor", hostname+"_uptime", uptimeConfig)
+
+	lastBootConfig := SensorConfig{
+		Name:              hostname + " Last Boot",
+		Icon:              "mdi:restart",
+		EntityCategory:    "diagnostic",
+		StateTopic:        topicPrefix + "/state/last_boot",
+		UniqueID:          hostname + "_last_boot",
+		DeviceClass:       "timestamp",
+		ExpireAfter:       expireAfter(systemInterval),
+		AvailabilityTopic: getAvailabilityTopic(),
+		Device:            device,
+	}
+	publishConfig(client, "sensor", hostname+"_last_boot", lastBootConfig)
+}
+
+func publishLoadAverageDiscoveryConfig(client mqtt.Client) {
+	topicPrefix := getTopicPrefix()
+	device := getDevice()
+
+	for _, minutes := range []string{"1", "5", "15"} {
+		loadConfig := SensorConfig{
+			Name:                      hostname + " Load Average " + minutes + " min",
+			Icon:                      "mdi:gauge",
+			EntityCategory:            "diagnostic",
+			StateTopic:                topicPrefix + "/state/load_average",
+			ValueTemplate:             "{{ value_json.load_" + minutes + " }}",
+			StateClass:                "measurement",
+			SuggestedDisplayPrecision: precision(2),
+			UniqueID:                  hostname + "_load_" + minutes,
+			ExpireAfter:               expireAfter(systemInterval),
+			AvailabilityTopic:         getAvailabilityTopic(),
+			Device:                    device,
+		}
+		publishConfig(client, "sensor", hostname+"_load_"+minutes, loadConfig)
+	}
+}
+
+func publishTopProcessDiscoveryConfig(client mqtt.Client) {
+	topicPrefix := getTopicPrefix()
+	device := getDevice()
+
+	topProcessConfig := SensorConfig{
+		Name:                hostname + " Top Process",
+		Icon:                "mdi:fire",
+		StateTopic:          topicPrefix + "/state/top_process",
+		ValueTemplate:       "{{ value_json.name }}",
+		JsonAttributesTopic: topicPrefix + "/state/top_process",
+		UniqueID:            hostname + "_top_process",
+		ExpireAfter:         expireAfter(systemInterval),
+		AvailabilityTopic:   getAvailabilityTopic(),
+		Device:              device,
+	}
+	publishConfig(client, "sensor", hostname+"_top_process", topProcessConfig)
+
+	topProcessCPUConfig := SensorConfig{
+		Name:                      hostname + " Top Process CPU",
+		Icon:                      "mdi:cpu-64-bit",
+		StateTopic:                topicPrefix + "/state/top_process",
+		ValueTemplate:             "{{ value_json.cpu }}",
+		UnitOfMeasurement:         "%",
+		StateClass:                "measurement",
+		SuggestedDisplayPrecision: precision(0),
+		UniqueID:                  hostname + "_top_process_cpu",
+		ExpireAfter:               expireAfter(systemInterval),
+		AvailabilityTopic:         getAvailabilityTopic(),
+		Device:                    device,
+	}
+	publishConfig(client, "sensor", hostname+"_top_process_cpu", topProcessCPUConfig)
+}
+
+func publishOSVersionDiscoveryConfig(client mqtt.Client) {
+	osVersionConfig := SensorConfig{
+		Name:                hostname + " macOS Version",
+		Icon:                "mdi:apple",
+		EntityCategory:      "diagnostic",
+		StateTopic:          getTopicPrefix() + "/state/os_version",
+		UniqueID:            hostname + "_os_version",
+		ValueTemplate:       "{{ value_json.version }} ({{ value_json.build }})",
+		JsonAttributesTopic: getTopicPrefix() + "/state/os_version",
+		AvailabilityTopic:   getAvailabilityTopic(),
+		Device:              getDevice(),
+	}
+	publishConfig(client, "sensor", hostname+"_os_version", osVersionConfig)
+}
+
+func init() {
+	registerSensor(sensorFuncs{
+		name:      "uptime",
+		interval:  systemInterval,
+		poll:      updateUptime,
+		discovery: publishSystemDiscoveryConfig,
+	})
+
+	registerSensor(sensorFuncs{
+		name:      "load_average",
+		interval:  systemInterval,
+		poll:      updateLoadAverage,
+		discovery: publishLoadAverageDiscoveryConfig,
+	})
+
+	registerSensor(sensorFuncs{
+		name:      "top_process",
+		interval:  systemInterval,
+		poll:      updateTopProcess,
+		discovery: publishTopProcessDiscoveryConfig,
+	})
+
+	registerSensor(sensorFuncs{
+		name:      "os_version",
+		poll:      updateOSVersion,
+		discovery: publishOSVersionDiscoveryConfig,
+	})
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// NSProcessInfo.thermalState, macOS lowers the CPU speed from "serious" on
+var thermalStates = []string{"nominal", "fair", "serious", "critical"}
+
+const thermalStateScript = `ObjC.import('Foundation');
+$.NSProcessInfo.processInfo.thermalState`
+
+// One of thermalStates. Every call starts osascript, so it is read every
+// thermalInterval, not more often
+func getThermalState() (string, error) {
+	// It doesn't need root, unlike powermetrics
+	//
+	// $ osascript -l JavaScript -e "ObjC.import('Foundation'); \$.NSProcessInfo.processInfo.thermalState"
+	// 0
+	output, err := getCommandOutput("/usr/bin/osascript", "-l", "JavaScript", "-e", thermalStateScript)
+	if err != nil {
+		return "", err
+	}
+
+	i, err := strconv.Atoi(output)
+	if err != nil || i < 0 || i >= len(thermalStates) {
+		return "", fmt.Errorf("unknown thermal state: %s", output)
+	}
+
+	return thermalStates[i], nil
+}
+
+func updateThermalState(client mqtt.Client) {
+	state, err := getThermalState()
+	if err != nil {
+		reportError(client, "updating thermal state", err)
+		return
+	}
+
+	publishState(client, "thermal state", getTopicPrefix()+"/state/thermal_state", state)
+}
+
+func publishThermalDiscoveryConfig(client mqtt.Client) {
+	topicPrefix := getTopicPrefix()
+	device := getDevice()
+
+	thermalStateConfig := SensorConfig{
+		Name:              hostname + " Thermal State",
+		Icon:              "mdi:thermometer",
+		StateTopic:        topicPrefix + "/state/thermal_state",
+		UniqueID:          hostname + "_thermal_state",
+		DeviceClass:       "enum",
+		Options:           thermalStates,
+		ExpireAfter:       expireAfter(thermalInterval),
+		AvailabilityTopic: getAvailabilityTopic(),
+		Device:            device,
+	}
+	publishConfig(client, "sensor", hostname+"_thermal_state", thermalStateConfig)
+
+	throttlingConfig := BinarySensorConfig{
+		Name:              hostname + " Throttling",
+		Icon:              "mdi:thermometer-alert",
+		StateTopic:        topicPrefix + "/state/thermal_state",
+		ValueTemplate:     "{{ 'ON' if value in ['serious', 'critical'] else 'OFF' }}",
+		UniqueID:          hostname + "_throttling",
+		DeviceClass:       "heat",
+		ExpireAfter:       expireAfter(thermalInterval),
+		AvailabilityTopic: getAvailabilityTopic(),
+		Device:            device,
+	}
+	publishConfig(client, "binary_sensor", hostname+"_throttling", throttlingConfig)
+}
+
+func init() {
+	registerSensor(sensorFuncs{
+		name:      "thermal",
+		interval:  thermalInterval,
+		poll:      updateThermalState,
+		discovery: publishThermalDiscoveryConfig,
+	})
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"regexp"
+	"strconv"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+type timeMachineState struct {
+	Running bool `json:"running"`
+	// e.g. "Copying", empty when no backup is running
+	Phase string `json:"phase"`
+	// 0 to 100, 0 while the backup is being prepared
+	Percent float64 `json:"percent"`
+	// nil when there is no backup or the backup disk is not connected
+	LastBackup *string `json:"last_backup"`
+}
+
+func getTimeMachineState() (timeMachineState, error) {
+	output, err := getCommandOutput("/usr/bin/tmutil", "status")
+	if err != nil {
+		return timeMachineState{}, err
+	}
+
+	// $ /usr/bin/tmutil status
+	// Backup session status:
+	// {
+	//     BackupPhase = Copying;
+	//     ClientID = "com.apple.backupd";
+	//     Percent = "0.4213";
+	//     Progress =     {
+	//         Percent = "0.4213";
+	//         ...
+	//     };
+	//     Running = 1;
+	//     Stopped = 0;
+	// }
+
+	var state timeMachineState
+
+	if m := regexp.MustCompile(`Running = (\d);`).FindStringSubmatch(output); m != nil {
+		state.Running = m[1] == "1"
+	}
+
+	if m := regexp.MustCompile(`BackupPhase = (\w+);`).FindStringSubmatch(output); m != nil {
+		state.Phase = m[1]
+	}
+
+	// "-1" before the size of the backup is known
+	if m := regexp.MustCompile(`Percent = "?([\d.]+)"?;`).FindStringSubmatch(output); m != nil {
+		percent, _ := strconv.ParseFloat(m[1], 64)
+		state.Percent = math.Round(percent*1000) / 10
+	}
+
+	state.LastBackup = getLastBackupTime()
+
+	return state, nil
+}
+
+// Time of the latest backup in RFC 3339, nil if it is unknown
+func getLastBackupTime() *string {
+	// tmutil exits with error when there is no backup or the backup disk is
+	// not connected, that is not a failure for us
+	output, err := getCommandOutput("/usr/bin/tmutil", "latestbackup")
+	if err != nil {
+		return nil
+	}
+
+	// $ /usr/bin/tmutil latestbackup
+	// /Volumes/.timemachine/8B6E3D4A-.../2024-04-10-083012.backup/2024-04-10-083012.backup
+	//
+	// HFS+ backup disks:
+	// /Volumes/Backup/Backups.backupdb/my-mac/2024-04-10-083012
+
+	m := regexp.MustCompile(`(\d{4}-\d{2}-\d{2}-\d{6})`).FindStringSubmatch(output)
+	if m == nil {
+		return nil
+	}
+
+	t, err := time.ParseInLocation("2006-01-02-150405", m[1], time.Local)
+	if err != nil {
+		return nil
+	}
+
+	last := t.UTC().Format(time.RFC3339)
+	return &last
+}
+
+func updateTimeMachine(client mqtt.Client) {
+	state, err := getTimeMachineState()
+	if err != nil {
+		reportError(client, "updating time machine", err)
+		return
+	}
+
+	payload, err := json.Marshal(state)
+	if err != nil {
+		logErrorf("Error marshaling time machine state: %v", err)
+		return
+	}
+
+	publishState(client, "time machine", getTopicPrefix()+"/state/time_machine", payload)
+}
+
+func commandStartBackup() error {
+	// $ tmutil startbackup --auto
+	// --auto runs it like a scheduled backup, it picks the destination
+	return runCommand("/usr/bin/tmutil", "startbackup", "--auto")
+}
+
+func publishTimeMachineDiscoveryConfig(client mqtt.Client) {
+	topicPrefix := getTopicPrefix()
+	device := getDevice()
+
+	lastBackupConfig := SensorConfig{
+		Name:              hostname + " Last Backup",
+		Icon:              "mdi:backup-restore",
+		StateTopic:        topicPrefix + "/state/time_machine",
+		UniqueID:          hostname + "_last_backup",
+		DeviceClass:       "timestamp",
+		ValueTemplate:     "{{ value_json.last_backup }}",
+		ExpireAfter:       expireAfter(systemInterval),
+		AvailabilityTopic: getAvailabilityTopic(),
+		Device:            device,
+	}
+	publishConfig(client, "sensor", hostname+"_last_backup", lastBackupConfig)
+
+	backupRunningConfig := BinarySensorConfig{
+		Name:                hostname + " Backup Running",
+		Icon:                "mdi:backup-restore",
+		StateTopic:          topicPrefix + "/state/time_machine",
+		ValueTemplate:       "{{ 'ON' if value_json.running else 'OFF' }}",
+		JsonAttributesTopic: topicPrefix + "/state/time_machine",
+		UniqueID:            hostname + "_backup_running",
+		DeviceClass:         "running",
+		ExpireAfter:         expireAfter(systemInterval),
+		AvailabilityTopic:   getAvailabilityTopic(),
+		Device:              device,
+	}
+	publishConfig(client, "binary_sensor", hostname+"_backup_running", backupRunningConfig)
+
+	backupProgressConfig := SensorConfig{
+		Name:                      hostname + " Backup Progress",
+		Icon:                      "mdi:progress-upload",
+		StateTopic:                topicPrefix + "/state/time_machine",
+		UniqueID:                  hostname + "_backup_progress",
+		UnitOfMeasurement:         "%",
+		SuggestedDisplayPrecision: precision(0),
+		ValueTemplate:             "{{ value_json.percent }}",
+		ExpireAfter:               expireAfter(systemInterval),
+		AvailabilityTopic:         getAvailabilityTopic(),
+		Device:                    device,
+	}
+	publishConfig(client, "sensor", hostname+"_backup_progress", backupProgressConfig)
+
+	startBackupConfig := ButtonConfig{
+		Name:              hostname + " Start Backup",
+		Icon:              "mdi:backup-restore",
+		CommandTopic:      topicPrefix + "/command/time_machine_backup",
+		PayloadPress:      "backup",
+		UniqueID:          hostname + "_start_backup",
+		AvailabilityTopic: getAvailabilityTopic(),
+		Device:            device,
+	}
+	publishConfig(client, "button", hostname+"_start_backup", startBackupConfig)
+}
+
+func init() {
+	registerSensor(sensorFuncs{
+		name:      "time_machine",
+		interval:  systemInterval,
+		poll:      updateTimeMachine,
+		discovery: publishTimeMachineDiscoveryConfig,
+	})
+
+	registerCommand("time_machine", "time_machine_backup", func(client mqtt.Client, command string, payload string) {
+		if err := commandStartBackup(); err != nil {
+			reportError(client, "starting backup", err)
+			return
+		}
+
+		time.Sleep(1 * time.Second)
+
+		updateTimeMachine(client)
+	})
+}
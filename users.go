@@ -0,0 +1,290 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+type consoleUserState struct {
+	// User who has the screen, empty at the login window
+	User     string `json:"user"`
+	FullName string `json:"full_name"`
+	// Users logged in with the graphical interface, with fast user switching
+	// there can be more than one
+	Users []string `json:"users"`
+	// Time the console user last changed, empty before the first change
+	LastSwitch string `json:"last_switch"`
+}
+
+// Previous console user, to notice a switch
+var lastConsoleUser *string
+var lastConsoleSwitch string
+
+func getConsoleUser() (string, error) {
+	output, err := getCommandOutput("/usr/bin/stat", "-f", "%Su", "/dev/console")
+	if err != nil {
+		return "", err
+	}
+
+	// $ /usr/bin/stat -f %Su /dev/console
+	// anna
+	//
+	// At the login window the console belongs to root
+	if output == "root" {
+		return "", nil
+	}
+
+	return output, nil
+}
+
+func getConsoleUsers() ([]string, error) {
+	output, err := getCommandOutput("/usr/bin/who")
+	if err != nil {
+		return nil, err
+	}
+
+	// $ /usr/bin/who
+	// anna     console  Apr 10 08:00
+	// bob      console  Apr 10 09:12
+	// anna     ttys000  Apr 10 08:05
+
+	users := []string{}
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 2 && fields[1] == "console" {
+			users = append(users, fields[0])
+		}
+	}
+
+	return users, nil
+}
+
+type remoteSessionState struct {
+	// Somebody uses the Mac over the network
+	Active bool `json:"active"`
+	// Screen Sharing or Apple Remote Desktop viewer is connected
+	ScreenSharing bool `json:"screen_sharing"`
+	// Addresses of the Screen Sharing viewers
+	ScreenSharingFrom []string `json:"screen_sharing_from"`
+	// Users logged in with SSH
+	SSHUsers []string `json:"ssh_users"`
+}
+
+// Screen Sharing and Apple Remote Desktop both use VNC on port 5900
+const screenSharingPort = ".5900"
+
+func getScreenSharingClients() ([]string, error) {
+	output, err := getCommandOutput("/usr/sbin/netstat", "-an", "-p", "tcp")
+	if err != nil {
+		return nil, err
+	}
+
+	// $ /usr/sbin/netstat -an -p tcp
+	// Active Internet connections (including servers)
+	// Proto Recv-Q Send-Q  Local Address          Foreign Address        (state)
+	// tcp4       0      0  192.168.1.20.5900      192.168.1.31.52144     ESTABLISHED
+	// tcp46      0      0  *.5900                 *.*                    LISTEN
+
+	clients := []string{}
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 6 || fields[5] != "ESTABLISHED" || !strings.HasSuffix(fields[3], screenSharingPort) {
+			continue
+		}
+
+		// the port is after the last dot of the foreign address
+		address := fields[4]
+		if i := strings.LastIndex(address, "."); i > 0 {
+			address = address[:i]
+		}
+		clients = append(clients, address)
+	}
+
+	return clients, nil
+}
+
+// Users in `who` with a remote host are logged in with SSH
+func getSSHUsers() ([]string, error) {
+	output, err := getCommandOutput("/usr/bin/who")
+	if err != nil {
+		return nil, err
+	}
+
+	// $ /usr/bin/who
+	// anna     console  Apr 10 08:00
+	// anna     ttys001  Apr 10 09:30  (192.168.1.31)
+
+	users := []string{}
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 2 && fields[1] != "console" && strings.HasSuffix(line, ")") {
+			users = append(users, fields[0])
+		}
+	}
+
+	return users, nil
+}
+
+func getRemoteSession() (remoteSessionState, error) {
+	clients, err := getScreenSharingClients()
+	if err != nil {
+		return remoteSessionState{}, err
+	}
+
+	sshUsers, err := getSSHUsers()
+	if err != nil {
+		return remoteSessionState{}, err
+	}
+
+	return remoteSessionState{
+		Active:            len(clients) > 0 || len(sshUsers) > 0,
+		ScreenSharing:     len(clients) > 0,
+		ScreenSharingFrom: clients,
+		SSHUsers:          sshUsers,
+	}, nil
+}
+
+func getConsoleUserState() (consoleUserState, error) {
+	user, err := getConsoleUser()
+	if err != nil {
+		return consoleUserState{}, err
+	}
+
+	users, err := getConsoleUsers()
+	if err != nil {
+		return consoleUserState{}, err
+	}
+
+	state := consoleUserState{User: user, Users: users}
+
+	if user != "" {
+		// $ /usr/bin/id -F anna
+		// Anna Smith
+		state.FullName, err = getCommandOutput("/usr/bin/id", "-F", user)
+		if err != nil {
+			return consoleUserState{}, err
+		}
+	}
+
+	if lastConsoleUser != nil && *lastConsoleUser != user {
+		logInfof("Console user changed from %q to %q", *lastConsoleUser, user)
+		lastConsoleSwitch = time.Now().UTC().Format(time.RFC3339)
+	}
+	lastConsoleUser = &user
+	state.LastSwitch = lastConsoleSwitch
+
+	return state, nil
+}
+
+func updateConsoleUser(client mqtt.Client) {
+	state, err := getConsoleUserState()
+	if err != nil {
+		reportError(client, "updating console user", err)
+		return
+	}
+
+	payload, err := json.Marshal(state)
+	if err != nil {
+		logErrorf("Error marshaling console user: %v", err)
+		return
+	}
+
+	publishState(client, "console user", getTopicPrefix()+"/state/console_user", payload)
+}
+
+func updateRemoteSession(client mqtt.Client) {
+	state, err := getRemoteSession()
+	if err != nil {
+		reportError(client, "updating remote session", err)
+		return
+	}
+
+	payload, err := json.Marshal(state)
+	if err != nil {
+		logErrorf("Error marshaling remote session: %v", err)
+		return
+	}
+
+	publishState(client, "remote session", getTopicPrefix()+"/state/remote_session", payload)
+}
+
+func publishRemoteSessionDiscoveryConfig(client mqtt.Client) {
+	topicPrefix := getTopicPrefix()
+	device := getDevice()
+
+	remoteSessionConfig := BinarySensorConfig{
+		Name:                hostname + " Remote Session",
+		Icon:                "mdi:remote-desktop",
+		StateTopic:          topicPrefix + "/state/remote_session",
+		ValueTemplate:       "{{ 'ON' if value_json.active else 'OFF' }}",
+		JsonAttributesTopic: topicPrefix + "/state/remote_session",
+		UniqueID:            hostname + "_remote_session",
+		DeviceClass:         "occupancy",
+		ExpireAfter:         expireAfter(screenInterval),
+		AvailabilityTopic:   getAvailabilityTopic(),
+		Device:              device,
+	}
+	publishConfig(client, "binary_sensor", hostname+"_remote_session", remoteSessionConfig)
+
+	screenSharingConfig := BinarySensorConfig{
+		Name:              hostname + " Screen Sharing",
+		Icon:              "mdi:monitor-share",
+		StateTopic:        topicPrefix + "/state/remote_session",
+		ValueTemplate:     "{{ 'ON' if value_json.screen_sharing else 'OFF' }}",
+		UniqueID:          hostname + "_screen_sharing",
+		DeviceClass:       "running",
+		ExpireAfter:       expireAfter(screenInterval),
+		AvailabilityTopic: getAvailabilityTopic(),
+		Device:            device,
+	}
+	publishConfig(client, "binary_sensor", hostname+"_screen_sharing", screenSharingConfig)
+}
+
+func publishConsoleUserDiscoveryConfig(client mqtt.Client) {
+	topicPrefix := getTopicPrefix()
+	device := getDevice()
+
+	consoleUserConfig := SensorConfig{
+		Name:                hostname + " Console User",
+		Icon:                "mdi:account",
+		StateTopic:          topicPrefix + "/state/console_user",
+		UniqueID:            hostname + "_console_user",
+		ValueTemplate:       "{{ value_json.user if value_json.user else 'none' }}",
+		JsonAttributesTopic: topicPrefix + "/state/console_user",
+		ExpireAfter:         expireAfter(screenInterval),
+		AvailabilityTopic:   getAvailabilityTopic(),
+		Device:              device,
+	}
+	publishConfig(client, "sensor", hostname+"_console_user", consoleUserConfig)
+
+	loggedInUsersConfig := SensorConfig{
+		Name:              hostname + " Logged In Users",
+		Icon:              "mdi:account-multiple",
+		StateTopic:        topicPrefix + "/state/console_user",
+		UniqueID:          hostname + "_logged_in_users",
+		ValueTemplate:     "{{ value_json.users | length }}",
+		ExpireAfter:       expireAfter(screenInterval),
+		AvailabilityTopic: getAvailabilityTopic(),
+		Device:            device,
+	}
+	publishConfig(client, "sensor", hostname+"_logged_in_users", loggedInUsersConfig)
+}
+
+func init() {
+	registerSensor(sensorFuncs{
+		name:      "console_user",
+		interval:  screenInterval,
+		poll:      updateConsoleUser,
+		discovery: publishConsoleUserDiscoveryConfig,
+	})
+
+	registerSensor(sensorFuncs{
+		name:      "remote_session",
+		interval:  screenInterval,
+		poll:      updateRemoteSession,
+		discovery: publishRemoteSessionDiscoveryConfig,
+	})
+}
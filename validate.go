@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// Token of a message that was not sent anywhere
+type doneToken struct{}
+
+func (doneToken) Wait() bool {
+	return true
+}
+
+func (doneToken) WaitTimeout(time.Duration) bool {
+	return true
+}
+
+func (doneToken) Done() <-chan struct{} {
+	done := make(chan struct{})
+	close(done)
+	return done
+}
+
+func (doneToken) Error() error {
+	return nil
+}
+
+// Client that remembers the messages instead of publishing them
+type recordingClient struct {
+	mqtt.Client
+
+	mutex    sync.Mutex
+	messages map[string]string
+}
+
+func (r *recordingClient) Publish(topic string, qos byte, retained bool, payload interface{}) mqtt.Token {
+	var value string
+	switch p := payload.(type) {
+	case string:
+		value = p
+	case []byte:
+		value = string(p)
+	default:
+		value = fmt.Sprint(p)
+	}
+
+	r.mutex.Lock()
+	r.messages[topic] = value
+	r.mutex.Unlock()
+
+	return doneToken{}
+}
+
+func (r *recordingClient) IsConnectionOpen() bool {
+	return false
+}
+
+// Connects to a broker and disconnects right away. The client id differs
+// from the one of the running mac2mqtt, otherwise the broker would drop it
+func checkBroker(broker, user, password string) error {
+	opts := getMQTTOptions(broker, user, password)
+	opts.SetClientID(settings.ClientID + "_validate")
+	opts.SetConnectRetry(false)
+	opts.SetAutoReconnect(false)
+	opts.WillEnabled = false
+
+	client := mqtt.NewClient(opts)
+	token := client.Connect()
+	if !token.WaitTimeout(10 * time.Second) {
+		return fmt.Errorf("connection timed out")
+	}
+	if token.Error() != nil {
+		return token.Error()
+	}
+
+	client.Disconnect(250)
+
+	return nil
+}
+
+// mac2mqtt validate [-config PATH]
+//
+// Reads the config, connects to the brokers and prints every entity and topic
+// that mac2mqtt would create, without publishing anything
+func validate(configPath string) error {
+	c := settings.getConfig(configPath)
+	setupHost(c)
+
+	failed := false
+
+	brokers := []brokerConfig{{URL: getBrokerURL(), User: c.User, Password: c.Password}}
+	brokers = append(brokers, c.Brokers...)
+
+	fmt.Println("Brokers:")
+	for _, b := range brokers {
+		if err := checkBroker(b.URL, b.User, b.Password); err != nil {
+			fmt.Printf("  %s: %v\n", b.URL, err)
+			failed = true
+		} else {
+			fmt.Printf("  %s: OK\n", b.URL)
+		}
+	}
+
+	client := &recordingClient{messages: map[string]string{}}
+
+	// states are read too, configs of entities that depend on the hardware,
+	// like disks or Bluetooth devices, are published on their first update
+	publishHADiscoveryConfig(client)
+	for _, s := range sensors {
+		// it would overwrite the status of the running mac2mqtt
+		if s.Name() == "menu_bar" || !isFeatureEnabled(s.Name()) {
+			continue
+		}
+		pollSensor(client, s)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+
+	fmt.Println("\nHome Assistant entities:")
+	var removed []string
+	for _, topic := range sortedKeys(client.messages) {
+		parts := strings.Split(topic, "/")
+		if len(parts) != 5 || parts[0] != "homeassistant" || parts[4] != "config" {
+			continue
+		}
+
+		if client.messages[topic] == "" {
+			removed = append(removed, parts[3])
+			continue
+		}
+
+		var config struct {
+			Name string `json:"name"`
+		}
+		json.Unmarshal([]byte(client.messages[topic]), &config)
+
+		fmt.Fprintf(w, "  %s\t%s\t%s\n", parts[1], config.Name, parts[3])
+	}
+	w.Flush()
+
+	if len(removed) > 0 {
+		fmt.Println("\nRemoved entities of turned off features:")
+		for _, objectId := range removed {
+			fmt.Printf("  %s\n", objectId)
+		}
+	}
+
+	fmt.Println("\nState topics:")
+	for _, topic := range sortedKeys(client.messages) {
+		if strings.HasPrefix(topic, getTopicPrefix()+"/") {
+			fmt.Fprintf(w, "  %s\t%s\n", topic, menuBarText(client.messages[topic]))
+		}
+	}
+	w.Flush()
+
+	fmt.Println("\nCommand topics:")
+	commands := map[string]string{}
+	for name, command := range commandHandlers {
+		if !isFeatureEnabled(command.feature) {
+			continue
+		}
+		// commands like run/NAME
+		if strings.HasSuffix(name, "/") {
+			name += "+"
+		}
+		commands[getTopicPrefix()+"/command/"+name] = command.feature
+	}
+	for _, topic := range sortedKeys(commands) {
+		fmt.Fprintf(w, "  %s\t%s\n", topic, commands[topic])
+	}
+	w.Flush()
+
+	if failed {
+		return fmt.Errorf("can't connect to MQTT")
+	}
+
+	return nil
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+type networkConfig struct {
+	// HTTPS address that answers with the public IP as plain text, e.g.
+	// https://api.ipify.org. The public IP sensor is off without it
+	PublicIPURL string `yaml:"public_ip_url"`
+	// Names of VPN services from System Settings that count as VPN. When
+	// empty, any connected VPN service or utun interface with an IPv4
+	// address counts
+	VPNServices []string `yaml:"vpn_services"`
+	// Interfaces with a link binary sensor, e.g. en0. By default all
+	// Ethernet and Wi-Fi ports
+	Interfaces []string `yaml:"interfaces"`
+}
+
+type vpnState struct {
+	Connected bool `json:"connected"`
+	// connected VPN services from System Settings
+	Services []string `json:"services"`
+	// utun interfaces with an IPv4 address, WireGuard, Tailscale and most
+	// other VPN apps create them
+	Interfaces []string `json:"interfaces"`
+}
+
+// Names of connected VPN services configured in System Settings
+func getConnectedVPNServices() ([]string, error) {
+	output, err := getCommandOutput("/usr/sbin/scutil", "--nc", "list")
+	if err != nil {
+		return nil, err
+	}
+
+	// $ /usr/sbin/scutil --nc list
+	// Available network connection services in the current set (*=enabled):
+	// * (Connected)      8B6E3D4A-... VPN (com.wireguard.macos) "Work"  [VPN/com.wireguard.macos]
+	// * (Disconnected)   1C2F9A7B-... IPSec                     "Office" [IPSec]
+
+	r := regexp.MustCompile(`\((\w+)\)\s+\S+\s+.*?"([^"]+)"`)
+
+	services := []string{}
+	for _, m := range r.FindAllStringSubmatch(output, -1) {
+		if m[1] == "Connected" {
+			services = append(services, m[2])
+		}
+	}
+
+	return services, nil
+}
+
+// utun interfaces with an IPv4 address. macOS itself keeps several utun
+// interfaces for iCloud and Continuity, but they have only IPv6 addresses
+func getVPNInterfaces() ([]string, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{}
+	for _, iface := range ifaces {
+		if !strings.HasPrefix(iface.Name, "utun") || iface.Flags&net.FlagUp == 0 {
+			continue
+		}
+
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.To4() != nil {
+				names = append(names, iface.Name)
+				break
+			}
+		}
+	}
+
+	return names, nil
+}
+
+func getVPNState() (vpnState, error) {
+	services, err := getConnectedVPNServices()
+	if err != nil {
+		return vpnState{}, err
+	}
+
+	state := vpnState{Services: services, Interfaces: []string{}}
+
+	if len(settings.Network.VPNServices) > 0 {
+		for _, service := range services {
+			for _, name := range settings.Network.VPNServices {
+				if service == name {
+					state.Connected = true
+				}
+			}
+		}
+		return state, nil
+	}
+
+	state.Interfaces, err = getVPNInterfaces()
+	if err != nil {
+		return vpnState{}, err
+	}
+
+	state.Connected = len(state.Services) > 0 || len(state.Interfaces) > 0
+
+	return state, nil
+}
+
+func updateVPN(client mqtt.Client) {
+	state, err := getVPNState()
+	if err != nil {
+		reportError(client, "updating vpn", err)
+		return
+	}
+
+	payload, err := json.Marshal(state)
+	if err != nil {
+		logErrorf("Error marshaling vpn state: %v", err)
+		return
+	}
+
+	publishState(client, "vpn", getTopicPrefix()+"/state/vpn", payload)
+}
+
+var publicIPClient = &http.Client{
+	Timeout: 10 * time.Second,
+	// a redirect must not take the request to plain HTTP either
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		if req.URL.Scheme != "https" {
+			return errors.New("redirect to " + req.URL.String() + " is not HTTPS")
+		}
+		if len(via) >= 10 {
+			return errors.New("stopped after 10 redirects")
+		}
+		return nil
+	},
+}
+
+func getPublicIP() (string, error) {
+	resp, err := publicIPClient.Get(settings.Network.PublicIPURL)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s returned %s", settings.Network.PublicIPURL, resp.Status)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	if err != nil {
+		return "", err
+	}
+
+	ip := net.ParseIP(strings.TrimSpace(string(body)))
+	if ip == nil {
+		return "", errors.New("no IP address in the answer of " + settings.Network.PublicIPURL)
+	}
+
+	return ip.String(), nil
+}
+
+// The outside service can take up to the client timeout to answer, so it is
+// asked in its own goroutine, like softwareupdate
+var publicIPChecking atomic.Bool
+
+func updatePublicIP(client mqtt.Client) {
+	if settings.Network.PublicIPURL == "" {
+		return
+	}
+
+	if !publicIPChecking.CompareAndSwap(false, true) {
+		return
+	}
+
+	go func() {
+		defer publicIPChecking.Store(false)
+
+		ip, err := getPublicIP()
+		if err != nil {
+			reportError(client, "updating public ip", err)
+			return
+		}
+
+		publishState(client, "public ip", getTopicPrefix()+"/state/public_ip", ip)
+	}()
+}
+
+func publishVPNDiscoveryConfig(client mqtt.Client) {
+	topicPrefix := getTopicPrefix()
+	device := getDevice()
+
+	vpnConfig := BinarySensorConfig{
+		Name:                hostname + " VPN",
+		Icon:                "mdi:vpn",
+		StateTopic:          topicPrefix + "/state/vpn",
+		ValueTemplate:       "{{ 'ON' if value_json.connected else 'OFF' }}",
+		JsonAttributesTopic: topicPrefix + "/state/vpn",
+		UniqueID:            hostname + "_vpn",
+		DeviceClass:         "connectivity",
+		ExpireAfter:         expireAfter(networkInterval),
+		AvailabilityTopic:   getAvailabilityTopic(),
+		Device:              device,
+	}
+	publishConfig(client, "binary_sensor", hostname+"_vpn", vpnConfig)
+}
+
+func publishPublicIPDiscoveryConfig(client mqtt.Client) {
+	if settings.Network.PublicIPURL == "" {
+		return
+	}
+
+	publicIPConfig := SensorConfig{
+		Name:              hostname + " Public IP",
+		Icon:              "mdi:ip",
+		EntityCategory:    "diagnostic",
+		StateTopic:        getTopicPrefix() + "/state/public_ip",
+		UniqueID:          hostname + "_public_ip",
+		ExpireAfter:       expireAfter(publicIPInterval),
+		AvailabilityTopic: getAvailabilityTopic(),
+		Device:            getDevice(),
+	}
+	publishConfig(client, "sensor", hostname+"_public_ip", publicIPConfig)
+}
+
+func init() {
+	registerSensor(sensorFuncs{
+		name:      "vpn",
+		interval:  networkInterval,
+		poll:      updateVPN,
+		discovery: publishVPNDiscoveryConfig,
+	})
+
+	registerSensor(sensorFuncs{
+		name:      "public_ip",
+		interval:  publicIPInterval,
+		poll:      updatePublicIP,
+		discovery: publishPublicIPDiscoveryConfig,
+	})
+}